@@ -0,0 +1,186 @@
+package accounts
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"firebase.google.com/go/auth"
+	stripe "github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/sub"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/keystore"
+	"github.com/writewithwrabit/server/mail"
+)
+
+// GracePeriod is how long a user has to change their mind after asking to be deleted
+const GracePeriod = 14 * 24 * time.Hour
+
+// Tables holding a user's personal data keyed by their Firebase ID.
+// Everything in these is removed when an account is erased.
+var personalTables = []string{
+	"entries",
+	"streaks",
+	"editors",
+	"reminders",
+	"push_subscriptions",
+	"tags",
+	"entry_tags",
+	"check_ins",
+	"frozen_days",
+	"achievements",
+	"daily_user_stats",
+	"entry_insights",
+	"email_changes",
+	"api_tokens",
+	"webhooks",
+	"webhook_deliveries",
+}
+
+// FirebaseUsers is the part of the Firebase auth client used to remove accounts
+type FirebaseUsers interface {
+	DeleteUser(ctx context.Context, uid string) error
+}
+
+// Eraser permanently removes accounts once their grace period is over
+type Eraser struct {
+	db       *sql.DB
+	firebase FirebaseUsers
+	keys     *keystore.Store
+}
+
+func NewEraser(db *sql.DB, firebase FirebaseUsers, keys *keystore.Store) *Eraser {
+	return &Eraser{
+		db:       db,
+		firebase: firebase,
+		keys:     keys,
+	}
+}
+
+type deletion struct {
+	id     string
+	userID string
+	email  sql.NullString
+}
+
+// Run erases every account whose grace period ended before now.
+// Each step is safe to repeat so failed erasures are retried on the next run.
+func (e *Eraser) Run(ctx context.Context, now time.Time) error {
+	res := wrabitDB.LogAndQuery(e.db, "SELECT id, user_id, email FROM account_deletions WHERE completed_at IS NULL AND purge_after <= $1", now)
+
+	var deletions []*deletion
+	for res.Next() {
+		var d = new(deletion)
+		if err := res.Scan(&d.id, &d.userID, &d.email); err != nil {
+			res.Close()
+			return err
+		}
+
+		deletions = append(deletions, d)
+	}
+	res.Close()
+
+	for _, d := range deletions {
+		if err := e.erase(ctx, d); err != nil {
+			log.Printf("failed to erase account %s: %v", d.userID, err)
+		}
+	}
+
+	return nil
+}
+
+func (e *Eraser) erase(ctx context.Context, d *deletion) error {
+	// The user row may already be gone if a previous run failed part way
+	var subscriptionID sql.NullString
+	res := wrabitDB.LogAndQueryRow(e.db, "SELECT stripe_subscription_id FROM users WHERE firebase_id = $1", d.userID)
+	if err := res.Scan(&subscriptionID); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if subscriptionID.Valid && subscriptionID.String != "" {
+		if err := cancelSubscription(subscriptionID.String); err != nil {
+			return err
+		}
+	}
+
+	if err := e.firebase.DeleteUser(ctx, d.userID); err != nil && !auth.IsUserNotFound(err) {
+		return err
+	}
+
+	// Shred first so content is unreadable even if the purge fails
+	e.keys.DestroyForUser(d.userID, "account deleted")
+
+	if err := e.purge(d.userID); err != nil {
+		return err
+	}
+
+	if d.email.Valid {
+		content := `Hey there,<br><br>
+
+  As requested, your Wrabit account and everything you wrote with us has been permanently deleted.<br><br>
+
+  Thanks for writing with us. You're always welcome back.<br><br>
+
+  Be well,<br>
+  Team Wrabit 🐇
+  `
+
+		if err := mail.Send(ctx, d.email.String, "Your account has been deleted", content); err != nil {
+			log.Printf("failed to send deletion confirmation for %s: %v", d.userID, err)
+		}
+	}
+
+	// Forget the email now that the confirmation is sent
+	wrabitDB.LogAndExec(e.db, "UPDATE account_deletions SET completed_at = NOW(), email = NULL WHERE id = $1", d.id)
+
+	return nil
+}
+
+// purge removes all personal rows in a single transaction.
+// Donations are kept without the user or entry so totals still add up.
+func (e *Eraser) purge(userID string) error {
+	tx, err := e.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range personalTables {
+		if _, err := wrabitDB.LogAndExecTx(tx, fmt.Sprintf("DELETE FROM %s WHERE user_id = $1", table), userID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := wrabitDB.LogAndExecTx(tx, "UPDATE donations SET user_id = NULL, entry_id = NULL WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	if _, err := wrabitDB.LogAndExecTx(tx, "DELETE FROM users WHERE firebase_id = $1", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func cancelSubscription(id string) error {
+	// Initialize Stripe
+	stripe.Key = os.Getenv("STRIPE_KEY")
+
+	subscription, err := sub.Get(id, nil)
+	if stripeErr, ok := err.(*stripe.Error); ok && stripeErr.Code == stripe.ErrorCodeResourceMissing {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if subscription.Status == stripe.SubscriptionStatusCanceled {
+		return nil
+	}
+
+	_, err = sub.Cancel(id, nil)
+
+	return err
+}
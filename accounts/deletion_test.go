@@ -0,0 +1,62 @@
+package accounts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/writewithwrabit/server/keystore"
+)
+
+type fakeFirebase struct {
+	deleted []string
+}
+
+func (f *fakeFirebase) DeleteUser(ctx context.Context, uid string) error {
+	f.deleted = append(f.deleted, uid)
+	return nil
+}
+
+func TestRunErasesAccountsPastGracePeriod(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+
+	mock.ExpectQuery("SELECT id, user_id, email FROM account_deletions WHERE completed_at IS NULL AND purge_after <= \\$1").
+		WithArgs(now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "email"}).AddRow("1", "abcdefg", nil))
+	mock.ExpectQuery("SELECT stripe_subscription_id FROM users WHERE firebase_id = \\$1").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"stripe_subscription_id"}).AddRow(nil))
+
+	mock.ExpectQuery("UPDATE encryption_keys SET wrapped_key = NULL, destroyed_at = NOW\\(\\) WHERE user_id = \\$1").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("5", "abcdefg"))
+	mock.ExpectExec("INSERT INTO key_audit").WithArgs("5", "abcdefg", "account deleted").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectBegin()
+	for _, table := range personalTables {
+		mock.ExpectExec("DELETE FROM " + table + " WHERE user_id = \\$1").WithArgs("abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec("UPDATE donations SET user_id = NULL, entry_id = NULL WHERE user_id = \\$1").WithArgs("abcdefg").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM users WHERE firebase_id = \\$1").WithArgs("abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectExec("UPDATE account_deletions SET completed_at = NOW\\(\\), email = NULL WHERE id = \\$1").WithArgs("1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	firebase := &fakeFirebase{}
+	err = NewEraser(db, firebase, keystore.New(db, "")).Run(context.Background(), now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abcdefg"}, firebase.deleted)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,217 @@
+package accounts
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"firebase.google.com/go/auth"
+	stripe "github.com/stripe/stripe-go"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	wrabitMail "github.com/writewithwrabit/server/mail"
+)
+
+// EmailLinkTTL is how long an email verification link can be used for
+const EmailLinkTTL = 24 * time.Hour
+
+// ErrEmailLinksDisabled is returned when there is no key to sign links with
+var ErrEmailLinksDisabled = fmt.Errorf("Email changes are not available right now")
+
+// ErrInvalidEmailLink is returned for links that are forged, expired or already used
+var ErrInvalidEmailLink = fmt.Errorf("This link is invalid or has expired")
+
+// FirebaseEmails is the part of the Firebase auth client used to change a user's email
+type FirebaseEmails interface {
+	UpdateUser(ctx context.Context, uid string, user *auth.UserToUpdate) (*auth.UserRecord, error)
+}
+
+// EmailChanges moves users to a new email once they prove they own it
+type EmailChanges struct {
+	db        *sql.DB
+	firebase  FirebaseEmails
+	customers Customers
+	secret    []byte
+	appURL    string
+	send      func(ctx context.Context, recipient string, subject string, content string) error
+}
+
+func NewEmailChanges(db *sql.DB, firebase FirebaseEmails, customers Customers, secret string, appURL string) *EmailChanges {
+	return &EmailChanges{
+		db:        db,
+		firebase:  firebase,
+		customers: customers,
+		secret:    []byte(secret),
+		appURL:    strings.TrimSuffix(appURL, "/"),
+		send:      wrabitMail.Send,
+	}
+}
+
+// Request emails a verification link to the new address.
+// Any earlier link the user was sent stops working.
+func (e *EmailChanges) Request(ctx context.Context, userID string, email string) error {
+	if len(e.secret) == 0 {
+		return ErrEmailLinksDisabled
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid email address", email)
+	}
+	email = address.Address
+
+	var current string
+	res := wrabitDB.LogAndQueryRow(e.db, "SELECT email FROM users WHERE firebase_id = $1", userID)
+	if err := res.Scan(&current); err != nil {
+		return err
+	}
+
+	if strings.EqualFold(current, email) {
+		return fmt.Errorf("This is already your email address")
+	}
+
+	wrabitDB.LogAndExec(e.db, "UPDATE email_changes SET expires_at = NOW() WHERE user_id = $1 AND confirmed_at IS NULL AND expires_at > NOW()", userID)
+
+	var id string
+	expiresAt := time.Now().Add(EmailLinkTTL)
+	res = wrabitDB.LogAndQueryRow(e.db, "INSERT INTO email_changes (user_id, email, expires_at) VALUES ($1, $2, $3) RETURNING id", userID, email, expiresAt)
+	if err := res.Scan(&id); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/confirm-email?token=%s", e.appURL, e.sign(id, expiresAt))
+	content := fmt.Sprintf(`Hey there,<br><br>
+
+  Someone asked to use this address for their Wrabit account. If that was you, <a href="%s">confirm your new email</a> within the next day.<br><br>
+
+  If it wasn't you, you can ignore this email and nothing will change.<br><br>
+
+  Be well,<br>
+  Team Wrabit 🐇
+  `, link)
+
+	return e.send(ctx, email, "Confirm your new email", content)
+}
+
+// Confirm switches the user to the email in a verification link. Firebase and
+// Stripe are updated before the database so a failed confirmation can be retried
+// with the same link until it expires.
+func (e *EmailChanges) Confirm(ctx context.Context, token string) (string, error) {
+	id, ok := e.verify(token, time.Now())
+	if !ok {
+		return "", ErrInvalidEmailLink
+	}
+
+	var userID, email, previous string
+	var stripeID sql.NullString
+	res := wrabitDB.LogAndQueryRow(e.db, "SELECT c.user_id, c.email, u.email, u.stripe_id FROM email_changes c JOIN users u ON u.firebase_id = c.user_id WHERE c.id = $1 AND c.confirmed_at IS NULL AND c.expires_at > NOW()", id)
+	if err := res.Scan(&userID, &email, &previous, &stripeID); err == sql.ErrNoRows {
+		return "", ErrInvalidEmailLink
+	} else if err != nil {
+		return "", err
+	}
+
+	if _, err := e.firebase.UpdateUser(ctx, userID, (&auth.UserToUpdate{}).Email(email).EmailVerified(true)); auth.IsEmailAlreadyExists(err) {
+		return "", fmt.Errorf("%s is already used by another account", email)
+	} else if err != nil {
+		return "", err
+	}
+
+	if stripeID.Valid {
+		if _, err := e.customers.Update(stripeID.String, &stripe.CustomerParams{Email: stripe.String(email)}); err != nil {
+			return "", err
+		}
+	}
+
+	tx, err := e.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := wrabitDB.LogAndExecTx(tx, "UPDATE users SET email = $1 WHERE firebase_id = $2", email, userID); err != nil {
+		return "", err
+	}
+
+	if _, err := wrabitDB.LogAndExecTx(tx, "UPDATE email_changes SET confirmed_at = NOW() WHERE id = $1", id); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	// Let the old address know in case the change wasn't theirs
+	content := fmt.Sprintf(`Hey there,<br><br>
+
+  The email for your Wrabit account was changed to %s. If you didn't do this, reply to this email right away and we'll help you get your account back.<br><br>
+
+  Be well,<br>
+  Team Wrabit 🐇
+  `, email)
+
+	if err := e.send(ctx, previous, "Your email was changed", content); err != nil {
+		log.Printf("failed to notify %s of their email change: %v", userID, err)
+	}
+
+	return userID, nil
+}
+
+// sign creates the token for a verification link.
+// It holds the change and when it expires along with their signature.
+func (e *EmailChanges) sign(id string, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%s:%d", id, expiresAt.Unix())
+
+	mac := hmac.New(sha256.New, e.secret)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks a token's signature and expiry and returns the change it is for
+func (e *EmailChanges) verify(token string, now time.Time) (string, bool) {
+	// Anyone could sign links without a secret
+	if len(e.secret) == 0 {
+		return "", false
+	}
+
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", false
+	}
+
+	mac := hmac.New(sha256.New, e.secret)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", false
+	}
+
+	fields := strings.SplitN(string(payload), ":", 2)
+	if len(fields) != 2 {
+		return "", false
+	}
+
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return "", false
+	}
+
+	return fields[0], true
+}
@@ -0,0 +1,104 @@
+package accounts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeFirebaseEmails struct {
+	updated []string
+}
+
+func (f *fakeFirebaseEmails) UpdateUser(ctx context.Context, uid string, user *auth.UserToUpdate) (*auth.UserRecord, error) {
+	f.updated = append(f.updated, uid)
+	return &auth.UserRecord{}, nil
+}
+
+func TestEmailLinkVerification(t *testing.T) {
+	changes := NewEmailChanges(nil, nil, nil, "secret", "https://example.com/")
+	now := time.Now()
+
+	token := changes.sign("12", now.Add(time.Hour))
+	id, ok := changes.verify(token, now)
+	assert.True(t, ok)
+	assert.Equal(t, "12", id)
+
+	_, ok = changes.verify(token, now.Add(2*time.Hour))
+	assert.False(t, ok, "expired links are rejected")
+
+	_, ok = changes.verify(changes.sign("12", now.Add(time.Hour))+"x", now)
+	assert.False(t, ok, "tampered links are rejected")
+
+	_, ok = NewEmailChanges(nil, nil, nil, "other", "").verify(token, now)
+	assert.False(t, ok, "links signed with another key are rejected")
+
+	_, ok = NewEmailChanges(nil, nil, nil, "", "").verify(NewEmailChanges(nil, nil, nil, "", "").sign("12", now.Add(time.Hour)), now)
+	assert.False(t, ok, "links can't be used without a key")
+}
+
+func TestConfirmEmailChange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	firebase := &fakeFirebaseEmails{}
+	customers := &fakeCustomers{}
+	changes := NewEmailChanges(db, firebase, customers, "secret", "")
+
+	var sent []string
+	changes.send = func(ctx context.Context, recipient string, subject string, content string) error {
+		sent = append(sent, recipient)
+		return nil
+	}
+
+	mock.ExpectQuery("SELECT c.user_id, c.email, u.email, u.stripe_id FROM email_changes c").
+		WithArgs("12").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email", "previous", "stripe_id"}).AddRow("abcdefg", "new@example.com", "old@example.com", "cus_123"))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET email = \\$1 WHERE firebase_id = \\$2").
+		WithArgs("new@example.com", "abcdefg").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE email_changes SET confirmed_at = NOW\\(\\) WHERE id = \\$1").
+		WithArgs("12").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	userID, err := changes.Confirm(context.Background(), changes.sign("12", time.Now().Add(time.Hour)))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "abcdefg", userID)
+	assert.Equal(t, []string{"abcdefg"}, firebase.updated)
+	assert.Equal(t, "new@example.com", *customers.updated["cus_123"].Email)
+	assert.Equal(t, []string{"old@example.com"}, sent)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestConfirmEmailChangeRejectsUsedLinks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	firebase := &fakeFirebaseEmails{}
+	changes := NewEmailChanges(db, firebase, &fakeCustomers{}, "secret", "")
+
+	mock.ExpectQuery("SELECT c.user_id, c.email, u.email, u.stripe_id FROM email_changes c").
+		WithArgs("12").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email", "previous", "stripe_id"}))
+
+	_, err = changes.Confirm(context.Background(), changes.sign("12", time.Now().Add(time.Hour)))
+
+	assert.Equal(t, ErrInvalidEmailLink, err)
+	assert.Empty(t, firebase.updated)
+}
@@ -0,0 +1,203 @@
+package accounts
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+
+	stripe "github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/customer"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/mail"
+	"github.com/writewithwrabit/server/models"
+)
+
+// Customers is the part of Stripe used to manage the customer behind each account
+type Customers interface {
+	New(params *stripe.CustomerParams) (*stripe.Customer, error)
+	Update(id string, params *stripe.CustomerParams) (*stripe.Customer, error)
+}
+
+// StripeCustomers manages customers through the Stripe API
+type StripeCustomers struct{}
+
+func (StripeCustomers) New(params *stripe.CustomerParams) (*stripe.Customer, error) {
+	// Initialize Stripe
+	stripe.Key = os.Getenv("STRIPE_KEY")
+
+	return customer.New(params)
+}
+
+func (StripeCustomers) Update(id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	// Initialize Stripe
+	stripe.Key = os.Getenv("STRIPE_KEY")
+
+	return customer.Update(id, params)
+}
+
+// Profile is what a user tells us about themselves when signing up
+type Profile struct {
+	FirstName string
+	LastName  *string
+	Timezone  *string
+}
+
+// Signup provisions everything a new account needs
+type Signup struct {
+	db        *sql.DB
+	customers Customers
+	send      func(ctx context.Context, recipient string, subject string, content string) error
+}
+
+func NewSignup(db *sql.DB, customers Customers) *Signup {
+	return &Signup{
+		db:        db,
+		customers: customers,
+		send:      mail.Send,
+	}
+}
+
+// Run signs up the owner of a verified Firebase account. The user row is
+// committed first, then the Stripe customer is created and stored, then the
+// default editor is written. A failed signup picks up where it left off when
+// retried, reusing the customer it already created. Running it again for an
+// account that is already set up returns the user without changing anything,
+// so clients can safely retry.
+//
+// Failures are recovered going forward rather than undone. The Stripe customer
+// and Firebase user are kept when a later step fails, since deleting them
+// could fail too and would leave a retry nothing to pick up.
+func (s *Signup) Run(ctx context.Context, firebaseID string, email string, profile Profile) (*models.User, error) {
+	user := &models.User{
+		FirebaseID: &firebaseID,
+		FirstName:  profile.FirstName,
+		LastName:   profile.LastName,
+		Email:      email,
+		Timezone:   "UTC",
+	}
+
+	if profile.Timezone != nil {
+		user.Timezone = *profile.Timezone
+	}
+
+	// The row's ID has to outlive a failed signup since it keys the Stripe customer
+	if err := s.insert(user); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	signedUp, err := s.lock(tx, user)
+	if err != nil {
+		return nil, err
+	} else if signedUp {
+		return user, tx.Commit()
+	}
+
+	if user.StripeID == nil {
+		params := &stripe.CustomerParams{
+			Name:  stripe.String(user.FirstName),
+			Email: stripe.String(user.Email),
+		}
+		params.AddMetadata("firebase_id", firebaseID)
+		params.SetIdempotencyKey(fmt.Sprintf("signup-%s-%s", firebaseID, user.ID))
+
+		created, err := s.customers.New(params)
+		if err != nil {
+			return nil, err
+		}
+
+		// Keep the customer even if the rest of the signup fails so a retry uses it
+		if _, err := wrabitDB.LogAndExecTx(tx, "UPDATE users SET stripe_id = $1 WHERE id = $2", created.ID, user.ID); err != nil {
+			return nil, err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+
+		if tx, err = s.db.Begin(); err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+
+		if signedUp, err = s.lock(tx, user); err != nil {
+			return nil, err
+		} else if signedUp {
+			return user, tx.Commit()
+		}
+	}
+
+	if err := s.provision(tx, user); err != nil {
+		return nil, err
+	}
+
+	subject := "Welcome to your writing journey!"
+	content := `Hey there! 👋<br><br>
+
+  We hope you're ready to build a daily writing habit. It might not be easy but it's definitely rewarding!
+  We have a few tips to help you get started.<br><br>
+
+  1. <b>Don't think too much.</b> Let whatever needs to come out, come out.<br>
+  2. <b>Don't feel to bad if you miss a day.</b> At Wrabit we start small and every word counts.<br>
+  3. <b>Have fun! 🎉</b> Building a habit is hard so we want it to be as enjoyable as possible.<br><br>
+
+  If there is anything we can do to support you, feel free to reach out. You can respond directly to this email! Our platform is new but we have lots planned. Thanks for being apart of <em>our</em> journey.<br><br>
+
+  Be well,<br>
+  Team Wrabit 🐇
+  `
+
+	// The account is ready so a missing welcome email shouldn't fail the signup
+	if err := s.send(ctx, user.Email, subject, content); err != nil {
+		log.Printf("failed to send welcome email to %s: %v", firebaseID, err)
+	}
+
+	return user, nil
+}
+
+// insert adds the user's row unless an earlier signup already did
+func (s *Signup) insert(user *models.User) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := wrabitDB.LogAndExecTx(tx, "INSERT INTO users (firebase_id, first_name, last_name, email, timezone) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (firebase_id) DO NOTHING", *user.FirebaseID, user.FirstName, user.LastName, user.Email, user.Timezone); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lock reads the user's row and holds it so concurrent signups for the same
+// account wait until the first one finishes. It reports if signup already finished.
+func (s *Signup) lock(tx *sql.Tx, user *models.User) (bool, error) {
+	var signedUpAt sql.NullTime
+	res := wrabitDB.LogAndQueryRowTx(tx, "SELECT id, stripe_id, first_name, last_name, email, word_goal, timezone, signed_up_at FROM users WHERE firebase_id = $1 FOR UPDATE", *user.FirebaseID)
+	if err := res.Scan(&user.ID, &user.StripeID, &user.FirstName, &user.LastName, &user.Email, &user.WordGoal, &user.Timezone, &signedUpAt); err != nil {
+		return false, err
+	}
+
+	return signedUpAt.Valid, nil
+}
+
+// provision finishes the user's row and creates their default editor
+func (s *Signup) provision(tx *sql.Tx, user *models.User) error {
+	if _, err := wrabitDB.LogAndExecTx(tx, "UPDATE users SET signed_up_at = NOW() WHERE id = $1", user.ID); err != nil {
+		return err
+	}
+
+	if _, err := wrabitDB.LogAndExecTx(tx, "INSERT INTO editors (user_id) SELECT $1 WHERE NOT EXISTS (SELECT 1 FROM editors WHERE user_id = $1)", *user.FirebaseID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
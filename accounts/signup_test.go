@@ -0,0 +1,165 @@
+package accounts
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	stripe "github.com/stripe/stripe-go"
+)
+
+type fakeCustomers struct {
+	created []*stripe.CustomerParams
+	updated map[string]*stripe.CustomerParams
+}
+
+func (f *fakeCustomers) New(params *stripe.CustomerParams) (*stripe.Customer, error) {
+	f.created = append(f.created, params)
+	return &stripe.Customer{ID: "cus_123"}, nil
+}
+
+func (f *fakeCustomers) Update(id string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	if f.updated == nil {
+		f.updated = map[string]*stripe.CustomerParams{}
+	}
+
+	f.updated[id] = params
+	return &stripe.Customer{ID: id}, nil
+}
+
+var signupColumns = []string{"id", "stripe_id", "first_name", "last_name", "email", "word_goal", "timezone", "signed_up_at"}
+
+func newTestSignup(t *testing.T) (*sql.DB, *Signup, sqlmock.Sqlmock, *fakeCustomers, *[]string) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	customers := &fakeCustomers{}
+	sent := &[]string{}
+
+	signup := NewSignup(db, customers)
+	signup.send = func(ctx context.Context, recipient string, subject string, content string) error {
+		*sent = append(*sent, recipient)
+		return nil
+	}
+
+	return db, signup, mock, customers, sent
+}
+
+// expectUserRow commits the user's row and locks it again
+func expectUserRow(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users \\(firebase_id, first_name, last_name, email, timezone\\)").
+		WithArgs("abcdefg", "Test", nil, "test@example.com", "UTC").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE firebase_id = \\$1 FOR UPDATE").
+		WithArgs("abcdefg").
+		WillReturnRows(rows)
+}
+
+func TestSignupProvisionsNewAccount(t *testing.T) {
+	db, signup, mock, customers, sent := newTestSignup(t)
+	defer db.Close()
+
+	expectUserRow(mock, sqlmock.NewRows(signupColumns).AddRow("7", nil, "Test", nil, "test@example.com", 1000, "UTC", nil))
+
+	// The customer is stored before the rest of the signup
+	mock.ExpectExec("UPDATE users SET stripe_id = \\$1 WHERE id = \\$2").
+		WithArgs("cus_123", "7").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE firebase_id = \\$1 FOR UPDATE").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows(signupColumns).AddRow("7", "cus_123", "Test", nil, "test@example.com", 1000, "UTC", nil))
+
+	mock.ExpectExec("UPDATE users SET signed_up_at = NOW\\(\\) WHERE id = \\$1").
+		WithArgs("7").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO editors \\(user_id\\)").
+		WithArgs("abcdefg").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	user, err := signup.Run(context.Background(), "abcdefg", "test@example.com", Profile{FirstName: "Test"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "cus_123", *user.StripeID)
+	assert.Len(t, customers.created, 1)
+	assert.Equal(t, "signup-abcdefg-7", *customers.created[0].IdempotencyKey)
+	assert.Equal(t, []string{"test@example.com"}, *sent)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSignupRetryReturnsExistingAccount(t *testing.T) {
+	db, signup, mock, customers, sent := newTestSignup(t)
+	defer db.Close()
+
+	expectUserRow(mock, sqlmock.NewRows(signupColumns).AddRow("7", "cus_123", "Test", nil, "test@example.com", 1000, "UTC", time.Now()))
+	mock.ExpectCommit()
+
+	user, err := signup.Run(context.Background(), "abcdefg", "test@example.com", Profile{FirstName: "Test"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "7", user.ID)
+	assert.Empty(t, customers.created)
+	assert.Empty(t, *sent)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSignupRetryReusesCustomer(t *testing.T) {
+	db, signup, mock, customers, sent := newTestSignup(t)
+	defer db.Close()
+
+	// The first attempt stored the customer but failed to create the editor
+	expectUserRow(mock, sqlmock.NewRows(signupColumns).AddRow("7", nil, "Test", nil, "test@example.com", 1000, "UTC", nil))
+	mock.ExpectExec("UPDATE users SET stripe_id").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE firebase_id = \\$1 FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows(signupColumns).AddRow("7", "cus_123", "Test", nil, "test@example.com", 1000, "UTC", nil))
+	mock.ExpectExec("UPDATE users SET signed_up_at").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO editors").WillReturnError(fmt.Errorf("connection reset"))
+	mock.ExpectRollback()
+
+	user, err := signup.Run(context.Background(), "abcdefg", "test@example.com", Profile{FirstName: "Test"})
+
+	assert.Nil(t, user)
+	assert.NotNil(t, err)
+	assert.Empty(t, *sent)
+
+	// The retry finishes with the same customer
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE firebase_id = \\$1 FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows(signupColumns).AddRow("7", "cus_123", "Test", nil, "test@example.com", 1000, "UTC", nil))
+	mock.ExpectExec("UPDATE users SET signed_up_at").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO editors").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	user, err = signup.Run(context.Background(), "abcdefg", "test@example.com", Profile{FirstName: "Test"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "cus_123", *user.StripeID)
+	assert.Len(t, customers.created, 1)
+	assert.Equal(t, []string{"test@example.com"}, *sent)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,214 @@
+package achievements
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/push"
+	"github.com/writewithwrabit/server/webhooks"
+)
+
+// Facts describe the user's writing at the moment an entry was saved
+type Facts struct {
+	UserID          string
+	EntryID         string
+	WordCount       int
+	GoalHit         bool
+	StreakLength    int
+	EntryCount      int
+	LifetimeWords   int
+	PreviousLongest int
+	WrittenAt       time.Time
+}
+
+// Rule awards an achievement when the facts satisfy it
+type Rule struct {
+	Kind        models.AchievementKind
+	Title       string
+	Description string
+	Earned      func(facts Facts) bool
+}
+
+// Rules are evaluated in order every time an entry is saved
+var Rules = []Rule{
+	{
+		Kind:        models.AchievementKindFirstEntry,
+		Title:       "First words",
+		Description: "Wrote your first entry.",
+		Earned: func(facts Facts) bool {
+			return facts.WordCount > 0
+		},
+	},
+	{
+		Kind:        models.AchievementKindThirtyDayStreak,
+		Title:       "Habit formed",
+		Description: "Hit your goal 30 days in a row.",
+		Earned: func(facts Facts) bool {
+			return facts.StreakLength >= 30
+		},
+	},
+	{
+		Kind:        models.AchievementKindHundredThousandWords,
+		Title:       "Novelist",
+		Description: "Wrote 100,000 words.",
+		Earned: func(facts Facts) bool {
+			return facts.LifetimeWords >= 100000
+		},
+	},
+	{
+		Kind:        models.AchievementKindLongestEntry,
+		Title:       "Personal best",
+		Description: "Beat your longest entry.",
+		Earned: func(facts Facts) bool {
+			return facts.EntryCount > 1 && facts.PreviousLongest > 0 && facts.WordCount > facts.PreviousLongest
+		},
+	},
+	{
+		Kind:        models.AchievementKindEarlyBird,
+		Title:       "Early bird",
+		Description: "Hit your goal before 7am.",
+		Earned: func(facts Facts) bool {
+			return facts.GoalHit && facts.WrittenAt.Hour() < 7
+		},
+	},
+}
+
+// Describe fills in the title and description of an achievement from its rule
+func Describe(achievement *models.Achievement) {
+	for _, rule := range Rules {
+		if rule.Kind == achievement.Kind {
+			achievement.Title = rule.Title
+			achievement.Description = rule.Description
+		}
+	}
+}
+
+// Notifier is told about every newly earned achievement
+type Notifier interface {
+	Notify(ctx context.Context, achievement *models.Achievement) error
+}
+
+// Engine evaluates the rules and records what users have earned
+type Engine struct {
+	db        *sql.DB
+	rules     []Rule
+	notifiers []Notifier
+}
+
+// New creates an engine using the default rules
+func New(db *sql.DB) *Engine {
+	return &Engine{
+		db:    db,
+		rules: Rules,
+	}
+}
+
+// Register adds a notifier for earned achievements
+func (e *Engine) Register(notifier Notifier) {
+	e.notifiers = append(e.notifiers, notifier)
+}
+
+// Evaluate checks every rule after an entry was saved.
+// Achievements are only ever earned once so it is safe to call repeatedly.
+func (e *Engine) Evaluate(ctx context.Context, userID string, entryID string, streakLength int) ([]*models.Achievement, error) {
+	if e == nil {
+		return nil, nil
+	}
+
+	facts, err := e.facts(userID, entryID, streakLength)
+	if err != nil {
+		return nil, err
+	}
+
+	var earned []*models.Achievement
+	for _, rule := range e.rules {
+		if !rule.Earned(*facts) {
+			continue
+		}
+
+		achievement := &models.Achievement{
+			UserID:  userID,
+			Kind:    rule.Kind,
+			EntryID: &entryID,
+		}
+
+		// Nothing is returned when the achievement was already earned
+		res := wrabitDB.LogAndQueryRow(e.db, "INSERT INTO achievements (user_id, kind, entry_id) VALUES ($1, $2, $3) ON CONFLICT (user_id, kind) DO NOTHING RETURNING id, created_at", userID, rule.Kind, entryID)
+		err := res.Scan(&achievement.ID, &achievement.EarnedAt)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return earned, err
+		}
+
+		Describe(achievement)
+		earned = append(earned, achievement)
+
+		for _, notifier := range e.notifiers {
+			if err := notifier.Notify(ctx, achievement); err != nil {
+				log.Printf("failed to notify %s about %s: %v", userID, rule.Kind, err)
+			}
+		}
+	}
+
+	return earned, nil
+}
+
+func (e *Engine) facts(userID string, entryID string, streakLength int) (*Facts, error) {
+	facts := &Facts{
+		UserID:       userID,
+		EntryID:      entryID,
+		StreakLength: streakLength,
+	}
+
+	var timezone string
+	res := wrabitDB.LogAndQueryRow(e.db, "SELECT e.word_count, e.goal_hit, u.timezone FROM entries e JOIN users u ON u.firebase_id = e.user_id WHERE e.id = $1 AND e.user_id = $2", entryID, userID)
+	if err := res.Scan(&facts.WordCount, &facts.GoalHit, &timezone); err != nil {
+		return nil, err
+	}
+
+	res = wrabitDB.LogAndQueryRow(e.db, "SELECT count(*), COALESCE(sum(word_count), 0), COALESCE(max(word_count) FILTER (WHERE id <> $2), 0) FROM entries WHERE user_id = $1 AND word_count > 0 AND deleted_at IS NULL", userID, entryID)
+	if err := res.Scan(&facts.EntryCount, &facts.LifetimeWords, &facts.PreviousLongest); err != nil {
+		return nil, err
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	facts.WrittenAt = time.Now().In(loc)
+
+	return facts, nil
+}
+
+// PushNotifier lets the user know about an achievement on their subscribed browsers
+type PushNotifier struct {
+	Client *push.Client
+}
+
+func (n PushNotifier) Notify(ctx context.Context, achievement *models.Achievement) error {
+	err := n.Client.Notify(ctx, achievement.UserID, push.Message{
+		Title: fmt.Sprintf("Achievement unlocked: %s 🏆", achievement.Title),
+		Body:  achievement.Description,
+		URL:   "/",
+	})
+	if err == push.ErrNotConfigured {
+		return nil
+	}
+
+	return err
+}
+
+// WebhookNotifier sends achievement.earned to the user's webhooks
+type WebhookNotifier struct {
+	Webhooks *webhooks.Dispatcher
+}
+
+func (n WebhookNotifier) Notify(ctx context.Context, achievement *models.Achievement) error {
+	return n.Webhooks.Publish(ctx, achievement.UserID, models.WebhookEventAchievementEarned, achievement)
+}
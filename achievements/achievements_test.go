@@ -0,0 +1,92 @@
+package achievements
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/writewithwrabit/server/models"
+)
+
+type recorder struct {
+	earned []models.AchievementKind
+}
+
+func (r *recorder) Notify(ctx context.Context, achievement *models.Achievement) error {
+	r.earned = append(r.earned, achievement.Kind)
+	return nil
+}
+
+func TestRules(t *testing.T) {
+	earned := func(facts Facts) []models.AchievementKind {
+		var kinds []models.AchievementKind
+		for _, rule := range Rules {
+			if rule.Earned(facts) {
+				kinds = append(kinds, rule.Kind)
+			}
+		}
+
+		return kinds
+	}
+
+	noon := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	dawn := time.Date(2020, 3, 1, 6, 30, 0, 0, time.UTC)
+
+	assert.Empty(t, earned(Facts{WrittenAt: noon}))
+	assert.Equal(t, []models.AchievementKind{models.AchievementKindFirstEntry}, earned(Facts{WordCount: 10, EntryCount: 1, PreviousLongest: 0, WrittenAt: noon}))
+	assert.Contains(t, earned(Facts{WordCount: 900, EntryCount: 5, PreviousLongest: 800, WrittenAt: noon}), models.AchievementKindLongestEntry)
+	assert.Contains(t, earned(Facts{WordCount: 10, StreakLength: 30, WrittenAt: noon}), models.AchievementKindThirtyDayStreak)
+	assert.Contains(t, earned(Facts{WordCount: 10, LifetimeWords: 100000, WrittenAt: noon}), models.AchievementKindHundredThousandWords)
+	assert.Contains(t, earned(Facts{WordCount: 10, GoalHit: true, WrittenAt: dawn}), models.AchievementKindEarlyBird)
+	assert.NotContains(t, earned(Facts{WordCount: 10, GoalHit: false, WrittenAt: dawn}), models.AchievementKindEarlyBird)
+}
+
+func TestEvaluateOnlyAwardsOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT e.word_count, e.goal_hit, u.timezone FROM entries e").
+		WithArgs("1", "abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"word_count", "goal_hit", "timezone"}).AddRow(1200, true, "UTC"))
+	mock.ExpectQuery("SELECT count\\(\\*\\), COALESCE\\(sum\\(word_count\\), 0\\)").
+		WithArgs("abcdefg", "1").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "sum", "max"}).AddRow(31, 40000, 1500))
+
+	// First entry was earned long ago, the streak is new
+	mock.ExpectQuery("INSERT INTO achievements \\(user_id, kind, entry_id\\)").
+		WithArgs("abcdefg", models.AchievementKindFirstEntry, "1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}))
+	mock.ExpectQuery("INSERT INTO achievements \\(user_id, kind, entry_id\\)").
+		WithArgs("abcdefg", models.AchievementKindThirtyDayStreak, "1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow("7", "2020-03-01"))
+
+	engine := New(db)
+	engine.rules = Rules[:2]
+	notified := &recorder{}
+	engine.Register(notified)
+
+	earned, err := engine.Evaluate(context.Background(), "abcdefg", "1", 30)
+
+	assert.Nil(t, err)
+	assert.Len(t, earned, 1)
+	assert.Equal(t, "Habit formed", earned[0].Title)
+	assert.Equal(t, []models.AchievementKind{models.AchievementKindThirtyDayStreak}, notified.earned)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestEvaluateWithoutEngine(t *testing.T) {
+	var engine *Engine
+
+	earned, err := engine.Evaluate(context.Background(), "abcdefg", "1", 0)
+
+	assert.Nil(t, earned)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,93 @@
+// Package admin runs operational tasks against the database from the server
+// binary so they don't need hand written SQL.
+//
+//	server admin user show <firebase id or email>
+//	server admin streaks recompute [-user <firebase id>] [-apply]
+//	server admin entries reencrypt [-user <firebase id>] [-dry-run]
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	stripe "github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/sub"
+	"github.com/writewithwrabit/server/keystore"
+)
+
+// ErrUsage is returned when the arguments don't name a command
+var ErrUsage = errors.New("unknown admin command")
+
+// Subscriptions is the part of Stripe used to look up a user's subscription
+type Subscriptions interface {
+	Get(id string) (*stripe.Subscription, error)
+}
+
+// StripeSubscriptions looks up subscriptions through the Stripe API
+type StripeSubscriptions struct{}
+
+func (StripeSubscriptions) Get(id string) (*stripe.Subscription, error) {
+	// Initialize Stripe
+	stripe.Key = os.Getenv("STRIPE_KEY")
+
+	return sub.Get(id, nil)
+}
+
+// Admin runs commands with the same stores the server uses
+type Admin struct {
+	db            *sql.DB
+	keys          *keystore.Store
+	subscriptions Subscriptions
+	out           io.Writer
+}
+
+func New(db *sql.DB, keys *keystore.Store, subscriptions Subscriptions, out io.Writer) *Admin {
+	return &Admin{
+		db:            db,
+		keys:          keys,
+		subscriptions: subscriptions,
+		out:           out,
+	}
+}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(a *Admin, ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"user show", "<firebase id or email>", "show a user's account, subscription and writing", (*Admin).showUser},
+	{"streaks recompute", "[-user <firebase id>] [-apply]", "report streaks that drifted from the goals users hit, fixing them with -apply", (*Admin).recomputeStreaks},
+	{"entries reencrypt", "[-user <firebase id>] [-dry-run]", "move entries still on the global key to their own keys", (*Admin).reencryptEntries},
+}
+
+// Usage describes every command
+func Usage(w io.Writer) {
+	fmt.Fprint(w, "Usage: server admin <command> [args]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s %s\n      %s\n", c.name, c.args, c.summary)
+	}
+}
+
+// Run runs the command named by the first two arguments, like `user show abcdefg`
+func (a *Admin) Run(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return ErrUsage
+	}
+
+	name := strings.Join(args[:2], " ")
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(a, ctx, args[2:])
+		}
+	}
+
+	return ErrUsage
+}
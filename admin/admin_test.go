@@ -0,0 +1,229 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	stripe "github.com/stripe/stripe-go"
+	"github.com/writewithwrabit/server/keystore"
+)
+
+type fakeSubscriptions map[string]*stripe.Subscription
+
+func (f fakeSubscriptions) Get(id string) (*stripe.Subscription, error) {
+	if subscription, ok := f[id]; ok {
+		return subscription, nil
+	}
+
+	return nil, errors.New("no such subscription")
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	a := New(nil, nil, fakeSubscriptions{}, &bytes.Buffer{})
+
+	assert.Equal(t, ErrUsage, a.Run(context.Background(), []string{"user"}))
+	assert.Equal(t, ErrUsage, a.Run(context.Background(), []string{"user", "delete", "abcdefg"}))
+}
+
+func TestShowUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	joined := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE firebase_id = \\$1 OR lower\\(email\\) = lower\\(\\$1\\)").
+		WithArgs("jane@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"firebase_id", "email", "first_name", "last_name", "timezone", "word_goal", "rest_days", "streak_freezes", "client_encryption", "stripe_id", "stripe_subscription_id", "created_at"}).
+			AddRow("abcdefg", "jane@example.com", "Jane", "Doe", "UTC", 500, "{0,6}", 2, false, "cus_1", "sub_1", joined))
+	mock.ExpectQuery("SELECT count\\(\\*\\) (.+) FROM entries WHERE user_id = \\$1").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "goals", "words", "max", "trashed"}).AddRow(12, 9, 6000, joined, 1))
+	mock.ExpectQuery("SELECT day_count, updated_at FROM streaks WHERE user_id = \\$1").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"day_count", "updated_at"}).AddRow(9, joined))
+	mock.ExpectQuery("SELECT count\\(\\*\\), (.+) FROM donations WHERE user_id = \\$1").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "unpaid"}).AddRow(1, 1))
+	mock.ExpectQuery("SELECT purge_after FROM account_deletions WHERE user_id = \\$1").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"purge_after"}))
+
+	var out bytes.Buffer
+	subscriptions := fakeSubscriptions{"sub_1": {ID: "sub_1", Status: stripe.SubscriptionStatusActive, CurrentPeriodEnd: joined.Unix()}}
+	err = New(db, nil, subscriptions, &out).Run(context.Background(), []string{"user", "show", "jane@example.com"})
+
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "Jane Doe")
+	assert.Contains(t, out.String(), "Sunday, Saturday")
+	assert.Contains(t, out.String(), "9 days")
+	assert.Contains(t, out.String(), "1 in the trash")
+	assert.Contains(t, out.String(), "sub_1, active")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// expectStreaks has a user whose saved streak kept going through two missed days
+func expectStreaks(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT timezone FROM users WHERE firebase_id = \\$1 FOR UPDATE").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"timezone"}).AddRow("America/Toronto"))
+	mock.ExpectQuery("SELECT rest_days, (.+) FROM users WHERE firebase_id = \\$1").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"rest_days", "today"}).AddRow("{}", time.Date(2020, 3, 5, 0, 0, 0, 0, time.UTC)))
+	mock.ExpectQuery("SELECT e.id, (.+) FROM entries e JOIN users u (.+) AND e.kind = 'DAILY' AND e.goal_hit").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "day"}).
+			AddRow("1", time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)).
+			AddRow("2", time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)).
+			AddRow("3", time.Date(2020, 3, 5, 0, 0, 0, 0, time.UTC)))
+
+	mock.ExpectQuery("SELECT streak_freezes FROM users WHERE firebase_id = \\$1").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"streak_freezes"}).AddRow(1))
+	mock.ExpectQuery("SELECT s.id, (.+) FROM streaks s JOIN users u").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "day_count", "last_entry_id", "start", "end"}).
+			AddRow("5", 3, "3", time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 3, 5, 0, 0, 0, 0, time.UTC)))
+	mock.ExpectQuery("SELECT streak_id, day FROM frozen_days WHERE user_id = \\$1").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"streak_id", "day"}))
+}
+
+func TestRecomputeStreaksReportsDrift(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT firebase_id FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"firebase_id"}).AddRow("abcdefg"))
+	expectStreaks(mock)
+	mock.ExpectCommit()
+
+	var out bytes.Buffer
+	err = New(db, nil, nil, &out).Run(context.Background(), []string{"streaks", "recompute"})
+
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "streak 2020-03-01 to 2020-03-05 (3 days, 0 frozen, last entry 3) should be 2020-03-01 to 2020-03-02 (2 days, 0 frozen, last entry 2)")
+	assert.Contains(t, out.String(), "missing streak 2020-03-05 to 2020-03-05")
+	assert.Contains(t, out.String(), "1 freezes should be 0")
+	assert.Contains(t, out.String(), "1 of 1 users drifted, run again with -apply")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRecomputeStreaksApplies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectStreaks(mock)
+
+	mock.ExpectExec("DELETE FROM frozen_days WHERE user_id = \\$1").WithArgs("abcdefg").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM streaks WHERE user_id = \\$1").WithArgs("abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO streaks").
+		WithArgs("abcdefg", 2, "2", "2020-03-01", "2020-03-02", "America/Toronto").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("7"))
+	mock.ExpectQuery("INSERT INTO streaks").
+		WithArgs("abcdefg", 1, "3", "2020-03-05", "2020-03-05", "America/Toronto").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("8"))
+	mock.ExpectExec("UPDATE users SET streak_freezes = \\$1 WHERE firebase_id = \\$2").WithArgs(0, "abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	var out bytes.Buffer
+	err = New(db, nil, nil, &out).Run(context.Background(), []string{"streaks", "recompute", "-user", "abcdefg", "-apply"})
+
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "1 of 1 users drifted, all fixed")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestReencryptEntries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	keys := keystore.New(db, "01234567890123456789012345678901")
+	legacy := keystore.Seal("Dear diary", keys.Master())
+
+	mock.ExpectQuery("SELECT id, user_id, content, title FROM entries WHERE key_id IS NULL AND NOT client_encrypted").
+		WithArgs(nil, "0", reencryptBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "content", "title"}).
+			AddRow("1", "abcdefg", legacy, nil).
+			AddRow("2", "abcdefg", "Written before encryption", nil))
+
+	for i, id := range []string{"1", "2"} {
+		mock.ExpectQuery("INSERT INTO encryption_keys").WithArgs("abcdefg", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10 + i))
+		mock.ExpectExec("UPDATE entries SET content = \\$1, title = \\$2, key_id = \\$3 WHERE id = \\$4 AND key_id IS NULL").
+			WithArgs(sqlmock.AnyArg(), nil, sqlmock.AnyArg(), id).
+			WillReturnResult(sqlmock.NewResult(0, int64(1-i)))
+	}
+
+	// The second entry was saved by its user in the meantime
+	mock.ExpectQuery("UPDATE encryption_keys SET wrapped_key = NULL").WithArgs("11").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("11", "abcdefg"))
+	mock.ExpectExec("INSERT INTO key_audit").WithArgs("11", "abcdefg", "entry already re-encrypted").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectQuery("SELECT id, user_id, content, title FROM entries WHERE key_id IS NULL AND NOT client_encrypted").
+		WithArgs(nil, "2", reencryptBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "content", "title"}))
+
+	var out bytes.Buffer
+	err = New(db, keys, nil, &out).Run(context.Background(), []string{"entries", "reencrypt"})
+
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "1 entries re-encrypted")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestReencryptDestroysKeyWhenUpdateFails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	keys := keystore.New(db, "01234567890123456789012345678901")
+
+	mock.ExpectQuery("INSERT INTO encryption_keys").WithArgs("abcdefg", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("10"))
+	mock.ExpectExec("UPDATE entries SET content = \\$1").WillReturnError(sql.ErrConnDone)
+	mock.ExpectQuery("UPDATE encryption_keys SET wrapped_key = NULL").WithArgs("10").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("10", "abcdefg"))
+	mock.ExpectExec("INSERT INTO key_audit").WithArgs("10", "abcdefg", "entry not re-encrypted").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	a := New(db, keys, nil, &bytes.Buffer{})
+	assert.Panics(t, func() {
+		a.reencrypt(&legacyEntry{id: "1", userID: "abcdefg", content: "Written before encryption"})
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,131 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/keystore"
+)
+
+// How many entries are re-encrypted per query
+const reencryptBatch = 100
+
+type legacyEntry struct {
+	id      string
+	userID  string
+	content string
+	title   *string
+}
+
+// reencryptEntries moves entries still encrypted with the global key onto keys
+// of their own, the same as saving them again would. Client encrypted entries
+// are left alone since the server can't read them.
+func (a *Admin) reencryptEntries(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("entries reencrypt", flag.ContinueOnError)
+	flags.SetOutput(a.out)
+	userID := flags.String("user", "", "only re-encrypt this user's entries")
+	dryRun := flags.Bool("dry-run", false, "count the entries without changing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var only *string
+	if *userID != "" {
+		only = userID
+	}
+
+	if *dryRun {
+		var count int
+		res := wrabitDB.LogAndQueryRow(a.db, "SELECT count(*) FROM entries WHERE key_id IS NULL AND NOT client_encrypted AND ($1::varchar IS NULL OR user_id = $1)", only)
+		if err := res.Scan(&count); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(a.out, "%d entries to re-encrypt\n", count)
+		return nil
+	}
+
+	done, after := 0, "0"
+	for {
+		batch, err := a.legacyEntries(only, after)
+		if err != nil {
+			return err
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, entry := range batch {
+			if a.reencrypt(entry) {
+				done++
+			}
+			after = entry.id
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(a.out, "%d entries re-encrypted\n", done)
+
+	return nil
+}
+
+func (a *Admin) legacyEntries(userID *string, after string) ([]*legacyEntry, error) {
+	rows := wrabitDB.LogAndQuery(a.db, "SELECT id, user_id, content, title FROM entries WHERE key_id IS NULL AND NOT client_encrypted AND ($1::varchar IS NULL OR user_id = $1) AND id > $2 ORDER BY id LIMIT $3", userID, after, reencryptBatch)
+	defer rows.Close()
+
+	var entries []*legacyEntry
+	for rows.Next() {
+		var entry = new(legacyEntry)
+		var content sql.NullString
+		if err := rows.Scan(&entry.id, &entry.userID, &content, &entry.title); err != nil {
+			return nil, err
+		}
+
+		entry.content = content.String
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// reencrypt seals an entry with a new key. The entry's version is left alone
+// since its content hasn't changed for anyone editing it. It reports whether
+// the entry still needed moving.
+func (a *Admin) reencrypt(entry *legacyEntry) (moved bool) {
+	master := a.keys.Master()
+	keyID, key := a.keys.Create(entry.userID)
+
+	// Keys live in their own database so the key can't be created in the
+	// entry's transaction. It is destroyed again unless the entry ends up
+	// using it, including when the update fails.
+	reason := "entry not re-encrypted"
+	defer func() {
+		if !moved {
+			a.keys.Destroy(keyID, reason)
+		}
+	}()
+
+	content := keystore.Seal(keystore.Open(entry.content, master), key)
+
+	var title *string
+	if entry.title != nil {
+		sealed := keystore.Seal(keystore.Open(*entry.title, master), key)
+		title = &sealed
+	}
+
+	res := wrabitDB.LogAndExec(a.db, "UPDATE entries SET content = $1, title = $2, key_id = $3 WHERE id = $4 AND key_id IS NULL AND NOT client_encrypted", content, title, keyID, entry.id)
+	if updated, err := res.RowsAffected(); err == nil && updated == 0 {
+		// Saved by its user in the meantime, which already gave it a key
+		reason = "entry already re-encrypted"
+		return false
+	}
+
+	return true
+}
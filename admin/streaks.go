@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/streaks"
+)
+
+// recomputeStreaks rebuilds streaks from the goals users hit and reports where
+// the saved ones drifted. Nothing is changed without -apply.
+func (a *Admin) recomputeStreaks(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("streaks recompute", flag.ContinueOnError)
+	flags.SetOutput(a.out)
+	userID := flags.String("user", "", "Firebase ID of the user, every user when left out")
+	apply := flags.Bool("apply", false, "save the rebuilt streaks for users that drifted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	userIDs := []string{*userID}
+	if *userID == "" {
+		var err error
+		if userIDs, err = a.userIDs(); err != nil {
+			return err
+		}
+	}
+
+	drifted, failed := 0, 0
+	for _, id := range userIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		drift, err := a.streakDrift(id, *apply)
+		if err != nil {
+			fmt.Fprintf(a.out, "%s: %v\n", id, err)
+			failed++
+			continue
+		}
+
+		if len(drift) == 0 {
+			continue
+		}
+
+		drifted++
+		fmt.Fprintf(a.out, "%s:\n", id)
+		for _, line := range drift {
+			fmt.Fprintf(a.out, "  %s\n", line)
+		}
+	}
+
+	fmt.Fprintf(a.out, "%d of %d users drifted", drifted, len(userIDs))
+	if *apply {
+		fmt.Fprint(a.out, ", all fixed\n")
+	} else if drifted > 0 {
+		fmt.Fprint(a.out, ", run again with -apply to fix them\n")
+	} else {
+		fmt.Fprint(a.out, "\n")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d users couldn't be recomputed", failed)
+	}
+
+	return nil
+}
+
+// streakDrift compares a user's saved streaks with rebuilt ones, saving the
+// rebuilt ones when asked to
+func (a *Admin) streakDrift(userID string, apply bool) ([]string, error) {
+	return streaks.Recompute(a.db, userID, apply)
+}
+
+func (a *Admin) userIDs() ([]string, error) {
+	rows := wrabitDB.LogAndQuery(a.db, "SELECT firebase_id FROM users WHERE firebase_id IS NOT NULL ORDER BY id")
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,133 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lib/pq"
+	wrabitDB "github.com/writewithwrabit/server/db"
+)
+
+// showUser prints what support usually needs to know about an account
+func (a *Admin) showUser(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("user show takes a Firebase ID or email")
+	}
+
+	var (
+		firebaseID, timezone                                 string
+		email, firstName, lastName, stripeID, subscriptionID sql.NullString
+		wordGoal, freezes                                    int
+		restDays                                             []int64
+		clientEncryption                                     bool
+		createdAt                                            time.Time
+	)
+	res := wrabitDB.LogAndQueryRow(a.db, "SELECT firebase_id, email, first_name, last_name, timezone, word_goal, rest_days, streak_freezes, client_encryption, stripe_id, stripe_subscription_id, created_at FROM users WHERE firebase_id = $1 OR lower(email) = lower($1) LIMIT 1", args[0])
+	err := res.Scan(&firebaseID, &email, &firstName, &lastName, &timezone, &wordGoal, pq.Array(&restDays), &freezes, &clientEncryption, &stripeID, &subscriptionID, &createdAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no user with the Firebase ID or email %q", args[0])
+	} else if err != nil {
+		return err
+	}
+
+	var entries, goalsHit, wordsWritten, trashed int
+	var lastEntry *time.Time
+	res = wrabitDB.LogAndQueryRow(a.db, "SELECT count(*) FILTER (WHERE deleted_at IS NULL), count(*) FILTER (WHERE goal_hit AND deleted_at IS NULL), COALESCE(sum(word_count) FILTER (WHERE deleted_at IS NULL), 0), max(created_at) FILTER (WHERE deleted_at IS NULL), count(*) FILTER (WHERE deleted_at IS NOT NULL) FROM entries WHERE user_id = $1", firebaseID)
+	if err := res.Scan(&entries, &goalsHit, &wordsWritten, &lastEntry, &trashed); err != nil {
+		return err
+	}
+
+	var streakDays int
+	var streakUpdatedAt time.Time
+	res = wrabitDB.LogAndQueryRow(a.db, "SELECT day_count, updated_at FROM streaks WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1", firebaseID)
+	err = res.Scan(&streakDays, &streakUpdatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	var donations, unpaid int
+	res = wrabitDB.LogAndQueryRow(a.db, "SELECT count(*), count(*) FILTER (WHERE paid IS NOT TRUE AND voided_at IS NULL) FROM donations WHERE user_id = $1", firebaseID)
+	if err := res.Scan(&donations, &unpaid); err != nil {
+		return err
+	}
+
+	var purgeAfter *time.Time
+	res = wrabitDB.LogAndQueryRow(a.db, "SELECT purge_after FROM account_deletions WHERE user_id = $1 AND completed_at IS NULL", firebaseID)
+	if err := res.Scan(&purgeAfter); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	w := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Firebase ID\t%s\n", firebaseID)
+	fmt.Fprintf(w, "Email\t%s\n", email.String)
+	fmt.Fprintf(w, "Name\t%s\n", strings.TrimSpace(firstName.String+" "+lastName.String))
+	fmt.Fprintf(w, "Joined\t%s\n", createdAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Timezone\t%s\n", timezone)
+	fmt.Fprintf(w, "Word goal\t%d\n", wordGoal)
+	fmt.Fprintf(w, "Rest days\t%s\n", weekdays(restDays))
+	fmt.Fprintf(w, "Client encryption\t%t\n", clientEncryption)
+	fmt.Fprintf(w, "Entries\t%d (%d goals hit, %d words, %d in the trash)\n", entries, goalsHit, wordsWritten, trashed)
+	if lastEntry != nil {
+		fmt.Fprintf(w, "Last entry\t%s\n", lastEntry.Format(time.RFC3339))
+	}
+	if streakDays > 0 {
+		fmt.Fprintf(w, "Latest streak\t%d days, last extended %s\n", streakDays, streakUpdatedAt.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "Freezes\t%d\n", freezes)
+	fmt.Fprintf(w, "Donations\t%d (%d unpaid)\n", donations, unpaid)
+	fmt.Fprintf(w, "Stripe customer\t%s\n", stripeID.String)
+	if purgeAfter != nil {
+		fmt.Fprintf(w, "Deletion\tscheduled for %s\n", purgeAfter.Format(time.RFC3339))
+	}
+
+	if subscriptionID.Valid {
+		a.showSubscription(w, subscriptionID.String)
+	} else {
+		fmt.Fprint(w, "Subscription\tnone\n")
+	}
+
+	return w.Flush()
+}
+
+// showSubscription prints the state of a subscription in Stripe.
+// Stripe being unreachable shouldn't hide everything else about the user.
+func (a *Admin) showSubscription(w *tabwriter.Writer, id string) {
+	subscription, err := a.subscriptions.Get(id)
+	if err != nil {
+		fmt.Fprintf(w, "Subscription\t%s (error fetching from Stripe: %v)\n", id, err)
+		return
+	}
+
+	fmt.Fprintf(w, "Subscription\t%s, %s\n", subscription.ID, subscription.Status)
+	if subscription.Plan != nil {
+		fmt.Fprintf(w, "Plan\t%s\n", subscription.Plan.ID)
+	}
+	fmt.Fprintf(w, "Current period ends\t%s\n", unix(subscription.CurrentPeriodEnd))
+	if subscription.TrialEnd != 0 {
+		fmt.Fprintf(w, "Trial ends\t%s\n", unix(subscription.TrialEnd))
+	}
+	if subscription.CancelAt != 0 {
+		fmt.Fprintf(w, "Cancels at\t%s\n", unix(subscription.CancelAt))
+	}
+}
+
+func unix(seconds int64) string {
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
+
+func weekdays(days []int64) string {
+	if len(days) == 0 {
+		return "none"
+	}
+
+	var names []string
+	for _, day := range days {
+		names = append(names, time.Weekday(day).String())
+	}
+
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,185 @@
+// Package api serves a small REST API for clients that don't want GraphQL.
+// Every route calls the same resolvers as the GraphQL schema so both APIs
+// share their access checks and behaviour.
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/vektah/gqlparser/gqlerror"
+	"github.com/writewithwrabit/server/auth"
+	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/resolvers"
+)
+
+// Queries are the GraphQL queries the REST API is built on
+type Queries interface {
+	UserByFirebaseID(ctx context.Context, firebaseID *string) (*models.User, error)
+	Entries(ctx context.Context, id *string) ([]*models.Entry, error)
+	EntriesByUserID(ctx context.Context, userID string, startDate *string, endDate *string, tag *string, kind *models.EntryKind) ([]*models.Entry, error)
+	DailyEntry(ctx context.Context, userID string, date string) (*models.Entry, error)
+	WordGoal(ctx context.Context, userID string, date string) (int, error)
+	Stats(ctx context.Context, global bool) (*models.Stats, error)
+	StatsRange(ctx context.Context, from string, to string, granularity models.Granularity) (*models.RangeStats, error)
+}
+
+// Mutations are the GraphQL mutations the REST API is built on
+type Mutations interface {
+	CreateEntry(ctx context.Context, input models.NewEntry) (*models.Entry, error)
+	UpdateEntry(ctx context.Context, id string, input models.ExistingEntry, date string) (*models.Entry, error)
+	DeleteEntry(ctx context.Context, id string) (*models.Entry, error)
+}
+
+// API handles requests under /api/v1
+type API struct {
+	queries   Queries
+	mutations Mutations
+}
+
+// New creates the router for the REST API, including its OpenAPI document
+func New(queries Queries, mutations Mutations) http.Handler {
+	a := &API{
+		queries:   queries,
+		mutations: mutations,
+	}
+
+	router := chi.NewRouter()
+
+	document, err := json.Marshal(openAPI(a.routes()))
+	if err != nil {
+		panic(err)
+	}
+
+	router.Get("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	})
+
+	for _, route := range a.routes() {
+		router.Method(route.method, route.path, a.handler(route))
+	}
+
+	return router
+}
+
+// Error is the body of every failed response
+type Error struct {
+	Error string        `json:"error"`
+	Entry *models.Entry `json:"entry,omitempty"`
+}
+
+// handler checks who is calling and turns the route's result into a response
+func (a *API) handler(route route) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				// The resolvers panic when a row they expect is missing
+				if recovered == sql.ErrNoRows {
+					writeJSON(w, http.StatusNotFound, Error{Error: "Not found"})
+					return
+				}
+
+				log.Printf("panic serving %s %s: %v", r.Method, r.URL.Path, recovered)
+				writeJSON(w, http.StatusInternalServerError, Error{Error: "Something went wrong"})
+			}
+		}()
+
+		user := auth.ForContext(r.Context())
+		if user == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, Error{Error: "Access denied"})
+			return
+		}
+
+		if err := resolvers.CheckScope(r.Context(), route.field); err != nil {
+			writeJSON(w, http.StatusForbidden, Error{Error: err.Error()})
+			return
+		}
+
+		status := http.StatusOK
+		if route.method == http.MethodPost {
+			status = http.StatusCreated
+		}
+
+		res, err := route.handle(r, user.Subject)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, status, res)
+	})
+}
+
+// requestError is a problem with the request itself
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+func badRequest(message string) error {
+	return &requestError{status: http.StatusBadRequest, message: message}
+}
+
+func notFound() error {
+	return &requestError{status: http.StatusNotFound, message: "Not found"}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch e := err.(type) {
+	case *requestError:
+		writeJSON(w, e.status, Error{Error: e.message})
+	case *gqlerror.Error:
+		// Save conflicts include the entry as it is now
+		if e.Extensions["code"] == "CONFLICT" {
+			entry, _ := e.Extensions["entry"].(*models.Entry)
+			writeJSON(w, http.StatusConflict, Error{Error: e.Message, Entry: entry})
+			return
+		}
+
+		writeJSON(w, http.StatusBadRequest, Error{Error: e.Message})
+	default:
+		if err.Error() == "Access denied" {
+			writeJSON(w, http.StatusForbidden, Error{Error: err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusBadRequest, Error{Error: err.Error()})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
+
+// today is the start of the current day for the user, which is how
+// the app asks for daily entries and goals
+func (a *API) today(ctx context.Context, userID string) (string, error) {
+	user, err := a.queries.UserByFirebaseID(ctx, &userID)
+	if err != nil {
+		return "", err
+	}
+
+	loc, err := time.LoadLocation(user.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	now := time.Now().In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).Format(time.RFC3339), nil
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	firebase "firebase.google.com/go/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/gqlerror"
+	"github.com/writewithwrabit/server/auth"
+	"github.com/writewithwrabit/server/models"
+)
+
+// fakeResolvers stands in for the GraphQL resolvers and remembers what it was asked
+type fakeResolvers struct {
+	entries map[string]*models.Entry
+	created models.NewEntry
+	userID  string
+	kind    *models.EntryKind
+	date    string
+}
+
+func newFake() *fakeResolvers {
+	return &fakeResolvers{
+		entries: map[string]*models.Entry{
+			"1": {ID: "1", UserID: "abcdefg", Content: "Mine", Version: 2},
+			"2": {ID: "2", UserID: "someone", Content: "Theirs"},
+		},
+	}
+}
+
+func (f *fakeResolvers) UserByFirebaseID(ctx context.Context, firebaseID *string) (*models.User, error) {
+	return &models.User{FirebaseID: firebaseID, Timezone: "America/Toronto"}, nil
+}
+
+func (f *fakeResolvers) Entries(ctx context.Context, id *string) ([]*models.Entry, error) {
+	entry, ok := f.entries[*id]
+	if !ok {
+		panic(sql.ErrNoRows)
+	}
+
+	return []*models.Entry{entry}, nil
+}
+
+func (f *fakeResolvers) EntriesByUserID(ctx context.Context, userID string, startDate *string, endDate *string, tag *string, kind *models.EntryKind) ([]*models.Entry, error) {
+	f.userID, f.kind = userID, kind
+	return nil, nil
+}
+
+func (f *fakeResolvers) DailyEntry(ctx context.Context, userID string, date string) (*models.Entry, error) {
+	f.userID, f.date = userID, date
+	return f.entries["1"], nil
+}
+
+func (f *fakeResolvers) WordGoal(ctx context.Context, userID string, date string) (int, error) {
+	return 250, nil
+}
+
+func (f *fakeResolvers) Stats(ctx context.Context, global bool) (*models.Stats, error) {
+	return &models.Stats{WordsWritten: 1000}, nil
+}
+
+func (f *fakeResolvers) StatsRange(ctx context.Context, from string, to string, granularity models.Granularity) (*models.RangeStats, error) {
+	return &models.RangeStats{From: from, To: to, Granularity: granularity}, nil
+}
+
+func (f *fakeResolvers) CreateEntry(ctx context.Context, input models.NewEntry) (*models.Entry, error) {
+	f.created = input
+	return &models.Entry{ID: "3", UserID: input.UserID, Content: input.Content}, nil
+}
+
+func (f *fakeResolvers) UpdateEntry(ctx context.Context, id string, input models.ExistingEntry, date string) (*models.Entry, error) {
+	return &models.Entry{}, &gqlerror.Error{
+		Message:    "Entry has been updated on another device",
+		Extensions: map[string]interface{}{"code": "CONFLICT", "entry": f.entries[id]},
+	}
+}
+
+func (f *fakeResolvers) DeleteEntry(ctx context.Context, id string) (*models.Entry, error) {
+	if id == "fail" {
+		return &models.Entry{}, fmt.Errorf("Access denied")
+	}
+
+	return &models.Entry{}, nil
+}
+
+// serve sends a request as the given token, or anonymously when it is nil
+func serve(t *testing.T, fake *fakeResolvers, token *firebase.Token, method string, target string, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != nil {
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserCtxKey, token))
+	}
+
+	rec := httptest.NewRecorder()
+	New(fake, fake).ServeHTTP(rec, req)
+
+	var res map[string]interface{}
+	if strings.HasPrefix(strings.TrimSpace(rec.Body.String()), "{") {
+		require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	}
+
+	return rec, res
+}
+
+var user = &firebase.Token{Subject: "abcdefg"}
+
+func TestEntries(t *testing.T) {
+	fake := newFake()
+
+	rec, _ := serve(t, fake, nil, http.MethodGet, "/entries", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec, _ = serve(t, fake, user, http.MethodGet, "/entries?kind=NOTE", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "[]", strings.TrimSpace(rec.Body.String()), "no entries is an empty list")
+	assert.Equal(t, "abcdefg", fake.userID)
+	assert.Equal(t, models.EntryKindNote, *fake.kind)
+
+	rec, _ = serve(t, fake, user, http.MethodGet, "/entries?kind=POEM", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, res := serve(t, fake, user, http.MethodPost, "/entries", `{"userId": "someone", "content": "Hello", "wordCount": 1}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "abcdefg", fake.created.UserID, "entries are written for the caller")
+	assert.Equal(t, "Hello", res["content"])
+
+	rec, _ = serve(t, fake, user, http.MethodPost, "/entries", `{"content":`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, res = serve(t, fake, user, http.MethodGet, "/entries/1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "Mine", res["content"])
+
+	rec, _ = serve(t, fake, user, http.MethodGet, "/entries/2", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code, "other users' entries are hidden")
+
+	rec, _ = serve(t, fake, user, http.MethodGet, "/entries/9", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code, "missing rows are not found")
+
+	rec, res = serve(t, fake, user, http.MethodPut, "/entries/1", `{"content": "Stale", "wordCount": 1, "version": 1}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, "Mine", res["entry"].(map[string]interface{})["content"], "conflicts include the current entry")
+
+	rec, _ = serve(t, fake, user, http.MethodDelete, "/entries/9", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec, _ = serve(t, fake, user, http.MethodDelete, "/entries/fail", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestTodaysEntry(t *testing.T) {
+	fake := newFake()
+
+	rec, _ := serve(t, fake, user, http.MethodGet, "/entries/today", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, fake.date, "T00:00:00-0", "today starts at midnight where the user lives")
+}
+
+func TestStats(t *testing.T) {
+	fake := newFake()
+
+	rec, res := serve(t, fake, user, http.MethodGet, "/goal", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, float64(250), res["wordGoal"])
+
+	rec, res = serve(t, fake, user, http.MethodGet, "/stats", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, float64(1000), res["wordsWritten"])
+
+	rec, res = serve(t, fake, user, http.MethodGet, "/stats/range?from=2020-01-01&to=2020-01-31", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "DAY", res["granularity"])
+
+	rec, _ = serve(t, fake, user, http.MethodGet, "/stats/range?from=2020-01-01", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	fake := newFake()
+	token := &firebase.Token{
+		Issuer:  auth.APITokenIssuer,
+		Subject: "abcdefg",
+		Claims:  map[string]interface{}{"scopes": []string{string(models.APITokenScopeEntriesRead)}},
+	}
+
+	rec, _ := serve(t, fake, token, http.MethodGet, "/entries", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec, res := serve(t, fake, token, http.MethodPost, "/entries", `{"content": "Hello"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "This API token can't use createEntry", res["error"])
+
+	rec, _ = serve(t, fake, token, http.MethodGet, "/stats", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestOpenAPI(t *testing.T) {
+	rec, res := serve(t, newFake(), nil, http.MethodGet, "/openapi.json", "")
+	assert.Equal(t, http.StatusOK, rec.Code, "the document is public")
+	assert.Equal(t, "3.0.3", res["openapi"])
+
+	paths := res["paths"].(map[string]interface{})
+	for _, route := range (&API{}).routes() {
+		path, ok := paths[route.path].(map[string]interface{})
+		require.True(t, ok, route.path)
+		assert.Contains(t, path, strings.ToLower(route.method), route.path)
+	}
+
+	// Every schema a route refers to is described
+	schemas := res["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for _, route := range (&API{}).routes() {
+		assert.Contains(t, schemas, route.response)
+		if route.body != "" {
+			assert.Contains(t, schemas, route.body)
+		}
+	}
+}
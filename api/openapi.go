@@ -0,0 +1,209 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// object is a piece of the OpenAPI document
+type object map[string]interface{}
+
+// openAPI describes the routes as an OpenAPI 3 document
+func openAPI(routes []route) object {
+	paths := object{}
+	for _, route := range routes {
+		path, ok := paths[route.path].(object)
+		if !ok {
+			path = object{}
+			paths[route.path] = path
+		}
+
+		path[strings.ToLower(route.method)] = operation(route)
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   "Wrabit",
+			"version": "1",
+			"description": "A REST API for clients that don't want GraphQL. " +
+				"Authenticate with a Firebase ID token or a personal API token as a bearer token.",
+		},
+		"servers":  []object{{"url": "/api/v1"}},
+		"security": []object{{"bearer": []string{}}},
+		"paths":    paths,
+		"components": object{
+			"securitySchemes": object{
+				"bearer": object{"type": "http", "scheme": "bearer"},
+			},
+			"schemas": schemas,
+		},
+	}
+}
+
+func operation(route route) object {
+	status := "200"
+	if route.method == http.MethodPost {
+		status = "201"
+	}
+
+	op := object{
+		"summary":     route.summary,
+		"operationId": route.field,
+		"responses": object{
+			status: jsonContent("The "+strings.ToLower(route.response), route.response),
+			"400":  jsonContent("The request was invalid", "Error"),
+			"401":  jsonContent("No valid token was given", "Error"),
+			"403":  jsonContent("The token can't be used for this", "Error"),
+			"404":  jsonContent("Nothing was found", "Error"),
+		},
+	}
+
+	if route.method == http.MethodPut {
+		op["responses"].(object)["409"] = jsonContent("The entry changed since the given version. The current entry is included.", "Error")
+	}
+
+	if len(route.params) > 0 {
+		var params []object
+		for _, p := range route.params {
+			schema := object{"type": "string"}
+			if len(p.enum) > 0 {
+				schema["enum"] = p.enum
+			}
+
+			params = append(params, object{
+				"name":        p.name,
+				"in":          p.in,
+				"description": p.description,
+				"required":    p.required,
+				"schema":      schema,
+			})
+		}
+
+		op["parameters"] = params
+	}
+
+	if route.body != "" {
+		body := jsonContent("", route.body)
+		delete(body, "description")
+		body["required"] = true
+		op["requestBody"] = body
+	}
+
+	return op
+}
+
+func jsonContent(description string, schema string) object {
+	return object{
+		"description": description,
+		"content": object{
+			"application/json": object{"schema": ref(schema)},
+		},
+	}
+}
+
+func ref(schema string) object {
+	return object{"$ref": "#/components/schemas/" + schema}
+}
+
+func properties(required []string, props object) object {
+	return object{
+		"type":       "object",
+		"required":   required,
+		"properties": props,
+	}
+}
+
+func nullable(schema object) object {
+	copied := object{"nullable": true}
+	for k, v := range schema {
+		copied[k] = v
+	}
+
+	return copied
+}
+
+var (
+	str     = object{"type": "string"}
+	integer = object{"type": "integer"}
+	number  = object{"type": "number"}
+	boolean = object{"type": "boolean"}
+	kind    = object{"type": "string", "enum": entryKinds()}
+)
+
+// schemas mirror the JSON the models encode to
+var schemas = object{
+	"Entry": properties([]string{"id", "userId", "kind", "wordCount", "content", "goalHit", "version", "clientEncrypted", "createdAt", "updatedAt"}, object{
+		"id":              str,
+		"userId":          str,
+		"kind":            kind,
+		"title":           nullable(str),
+		"wordCount":       integer,
+		"content":         str,
+		"goalHit":         boolean,
+		"version":         integer,
+		"clientEncrypted": boolean,
+		"createdAt":       str,
+		"updatedAt":       str,
+		"deletedAt":       nullable(str),
+	}),
+	"EntryList": object{
+		"type":  "array",
+		"items": ref("Entry"),
+	},
+	"NewEntry": properties([]string{"wordCount", "content"}, object{
+		"wordCount":       integer,
+		"content":         str,
+		"kind":            kind,
+		"title":           nullable(str),
+		"clientEncrypted": boolean,
+	}),
+	"ExistingEntry": properties([]string{"wordCount", "content", "goalHit"}, object{
+		"wordCount":       integer,
+		"content":         str,
+		"goalHit":         boolean,
+		"title":           nullable(str),
+		"version":         nullable(integer),
+		"clientEncrypted": boolean,
+	}),
+	"Goal": properties([]string{"wordGoal"}, object{
+		"wordGoal": integer,
+	}),
+	"Stats": properties([]string{"wordsWritten", "longestStreak", "longestEntry", "preferredWritingTimes", "preferredDayOfWeek"}, object{
+		"wordsWritten":  integer,
+		"longestStreak": integer,
+		"longestEntry":  integer,
+		"preferredWritingTimes": object{
+			"type": "array",
+			"items": properties([]string{"hour", "count"}, object{
+				"hour":  integer,
+				"count": integer,
+			}),
+		},
+		"preferredDayOfWeek": integer,
+	}),
+	"RangeStats": properties([]string{"from", "to", "granularity", "buckets", "wordsWritten", "averageEntryLength", "goalHitRate", "consistencyScore"}, object{
+		"from":        str,
+		"to":          str,
+		"granularity": object{"type": "string", "enum": granularities()},
+		"buckets": object{
+			"type": "array",
+			"items": properties([]string{"start", "wordsWritten", "entries", "goalsHit"}, object{
+				"start":        str,
+				"wordsWritten": integer,
+				"entries":      integer,
+				"goalsHit":     integer,
+			}),
+		},
+		"wordsWritten":       integer,
+		"averageEntryLength": number,
+		"goalHitRate":        number,
+		"consistencyScore":   number,
+		"vocabularySize":     nullable(integer),
+		"minutesToGoal":      nullable(number),
+	}),
+	"Error": properties([]string{"error"}, object{
+		"error": str,
+		"entry": ref("Entry"),
+	}),
+}
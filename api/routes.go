@@ -0,0 +1,282 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/writewithwrabit/server/models"
+)
+
+// route is one REST endpoint. The same table drives the router and the OpenAPI document.
+type route struct {
+	method  string
+	path    string
+	summary string
+	// The GraphQL field the route stands in for, which decides the API token scope it needs
+	field    string
+	params   []param
+	body     string
+	response string
+	handle   func(r *http.Request, userID string) (interface{}, error)
+}
+
+type param struct {
+	name        string
+	in          string
+	description string
+	required    bool
+	enum        []string
+}
+
+var entryID = param{name: "id", in: "path", description: "The entry's ID", required: true}
+
+func (a *API) routes() []route {
+	return []route{
+		{
+			method:   http.MethodGet,
+			path:     "/entries",
+			summary:  "List your entries, newest first",
+			field:    "entriesByUserID",
+			response: "EntryList",
+			params: []param{
+				{name: "startDate", in: "query", description: "Only entries created at or after this time"},
+				{name: "endDate", in: "query", description: "Only entries created at or before this time"},
+				{name: "tag", in: "query", description: "Only entries with this tag"},
+				{name: "kind", in: "query", description: "Only entries of this kind", enum: entryKinds()},
+			},
+			handle: a.listEntries,
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/entries",
+			summary:  "Write a new entry",
+			field:    "createEntry",
+			body:     "NewEntry",
+			response: "Entry",
+			handle:   a.createEntry,
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/entries/today",
+			summary:  "Get today's daily entry, starting it if needed",
+			field:    "dailyEntry",
+			response: "Entry",
+			handle:   a.todaysEntry,
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/entries/{id}",
+			summary:  "Get an entry",
+			field:    "entries",
+			params:   []param{entryID},
+			response: "Entry",
+			handle:   a.getEntry,
+		},
+		{
+			method:  http.MethodPut,
+			path:    "/entries/{id}",
+			summary: "Save an entry. Include the version you last read to be told about changes made elsewhere.",
+			field:   "updateEntry",
+			params: []param{
+				entryID,
+				{name: "date", in: "query", description: "When the entry was written, for streaks. Defaults to now."},
+			},
+			body:     "ExistingEntry",
+			response: "Entry",
+			handle:   a.updateEntry,
+		},
+		{
+			method:   http.MethodDelete,
+			path:     "/entries/{id}",
+			summary:  "Move an entry to the trash, restorable for 30 days through restoreEntry",
+			field:    "deleteEntry",
+			params:   []param{entryID},
+			response: "Entry",
+			handle:   a.deleteEntry,
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/goal",
+			summary:  "Get today's word goal",
+			field:    "wordGoal",
+			response: "Goal",
+			handle:   a.goal,
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/stats",
+			summary:  "Get your all time stats",
+			field:    "stats",
+			response: "Stats",
+			handle:   a.stats,
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/stats/range",
+			summary: "Get your stats between two dates",
+			field:   "statsRange",
+			params: []param{
+				{name: "from", in: "query", description: "The first day, as YYYY-MM-DD", required: true},
+				{name: "to", in: "query", description: "The last day, as YYYY-MM-DD", required: true},
+				{name: "granularity", in: "query", description: "How to bucket the days. Defaults to DAY.", enum: granularities()},
+			},
+			response: "RangeStats",
+			handle:   a.statsRange,
+		},
+	}
+}
+
+func (a *API) listEntries(r *http.Request, userID string) (interface{}, error) {
+	var kind *models.EntryKind
+	if value := r.URL.Query().Get("kind"); value != "" {
+		k := models.EntryKind(value)
+		if !k.IsValid() {
+			return nil, badRequest(value + " is not a kind of entry")
+		}
+
+		kind = &k
+	}
+
+	entries, err := a.queries.EntriesByUserID(r.Context(), userID, optional(r, "startDate"), optional(r, "endDate"), optional(r, "tag"), kind)
+	if err != nil {
+		return nil, err
+	}
+
+	if entries == nil {
+		entries = []*models.Entry{}
+	}
+
+	return entries, nil
+}
+
+func (a *API) createEntry(r *http.Request, userID string) (interface{}, error) {
+	var input models.NewEntry
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, badRequest("Invalid entry: " + err.Error())
+	}
+
+	// Entries are always written for whoever is calling
+	input.UserID = userID
+
+	return a.mutations.CreateEntry(r.Context(), input)
+}
+
+func (a *API) todaysEntry(r *http.Request, userID string) (interface{}, error) {
+	today, err := a.today(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.queries.DailyEntry(r.Context(), userID, today)
+}
+
+func (a *API) getEntry(r *http.Request, userID string) (interface{}, error) {
+	id := chi.URLParam(r, "id")
+
+	entries, err := a.queries.Entries(r.Context(), &id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Someone else's entry looks the same as a missing one
+	if len(entries) == 0 || entries[0].UserID != userID {
+		return nil, notFound()
+	}
+
+	return entries[0], nil
+}
+
+func (a *API) updateEntry(r *http.Request, userID string) (interface{}, error) {
+	var input models.ExistingEntry
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, badRequest("Invalid entry: " + err.Error())
+	}
+
+	input.UserID = userID
+
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = time.Now().Format(time.RFC3339)
+	}
+
+	return a.mutations.UpdateEntry(r.Context(), chi.URLParam(r, "id"), input, date)
+}
+
+func (a *API) deleteEntry(r *http.Request, userID string) (interface{}, error) {
+	entry, err := a.mutations.DeleteEntry(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing was deleted
+	if entry.ID == "" {
+		return nil, notFound()
+	}
+
+	return entry, nil
+}
+
+// Goal is the body of GET /goal
+type Goal struct {
+	WordGoal int `json:"wordGoal"`
+}
+
+func (a *API) goal(r *http.Request, userID string) (interface{}, error) {
+	wordGoal, err := a.queries.WordGoal(r.Context(), userID, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+
+	return Goal{WordGoal: wordGoal}, nil
+}
+
+func (a *API) stats(r *http.Request, userID string) (interface{}, error) {
+	return a.queries.Stats(r.Context(), false)
+}
+
+func (a *API) statsRange(r *http.Request, userID string) (interface{}, error) {
+	query := r.URL.Query()
+	if query.Get("from") == "" || query.Get("to") == "" {
+		return nil, badRequest("from and to are required")
+	}
+
+	granularity := models.GranularityDay
+	if value := query.Get("granularity"); value != "" {
+		granularity = models.Granularity(value)
+		if !granularity.IsValid() {
+			return nil, badRequest(value + " is not a granularity")
+		}
+	}
+
+	return a.queries.StatsRange(r.Context(), query.Get("from"), query.Get("to"), granularity)
+}
+
+// optional reads a query parameter that can be left out
+func optional(r *http.Request, name string) *string {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil
+	}
+
+	return &value
+}
+
+func entryKinds() []string {
+	var kinds []string
+	for _, kind := range models.AllEntryKind {
+		kinds = append(kinds, string(kind))
+	}
+
+	return kinds
+}
+
+func granularities() []string {
+	var values []string
+	for _, granularity := range models.AllGranularity {
+		values = append(values, string(granularity))
+	}
+
+	return values
+}
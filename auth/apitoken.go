@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"firebase.google.com/go/auth"
+	"github.com/lib/pq"
+	wrabitDB "github.com/writewithwrabit/server/db"
+)
+
+// APITokenPrefix starts every personal API token so they can't be mistaken for ID tokens
+const APITokenPrefix = "wrb_"
+
+// APITokenIssuer is the issuer of tokens verified from personal API tokens
+const APITokenIssuer = "wrabit-api-token"
+
+// NewAPIToken creates a random API token. Only its hash is ever stored.
+func NewAPIToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return APITokenPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// HashAPIToken is how an API token is looked up.
+// The tokens are random so a plain hash is enough.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// APITokens accepts personal API tokens and hands every other token to the next verifier
+type APITokens struct {
+	db   *sql.DB
+	next Verifier
+}
+
+func WithAPITokens(db *sql.DB, next Verifier) *APITokens {
+	return &APITokens{
+		db:   db,
+		next: next,
+	}
+}
+
+// VerifyIDToken finds the user an API token belongs to and records that it was used.
+// Revoked and expired tokens are rejected.
+func (a *APITokens) VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error) {
+	if !strings.HasPrefix(idToken, APITokenPrefix) {
+		return a.next.VerifyIDToken(ctx, idToken)
+	}
+
+	var id, userID string
+	var scopes []string
+	var expires sql.NullInt64
+	res := wrabitDB.LogAndQueryRow(a.db, "UPDATE api_tokens SET last_used_at = NOW() WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW()) RETURNING id, user_id, scopes, extract(epoch FROM expires_at)::bigint", HashAPIToken(idToken))
+	if err := res.Scan(&id, &userID, pq.Array(&scopes), &expires); err == sql.ErrNoRows {
+		return nil, fmt.Errorf("unknown API token")
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Tokens that never expire are left at zero
+	return &auth.Token{
+		Issuer:  APITokenIssuer,
+		Subject: userID,
+		UID:     userID,
+		Expires: expires.Int64,
+		Claims: map[string]interface{}{
+			"api_token_id": id,
+			"scopes":       scopes,
+		},
+	}, nil
+}
+
+// IsAPIToken tells API tokens apart from ID tokens signed in through the app
+func IsAPIToken(token *auth.Token) bool {
+	return token != nil && token.Issuer == APITokenIssuer
+}
+
+// HasScope checks whether an API token was granted a scope
+func HasScope(token *auth.Token, scope string) bool {
+	scopes, _ := token.Claims["scopes"].([]string)
+	for _, granted := range scopes {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
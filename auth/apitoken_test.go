@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPITokens(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	issuer := newIssuer(t)
+	verifier := WithAPITokens(db, newVerifier(t, issuer))
+
+	secret, err := NewAPIToken()
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(secret, APITokenPrefix))
+
+	mock.ExpectQuery("UPDATE api_tokens SET last_used_at = NOW\\(\\) WHERE token_hash = \\$1 AND revoked_at IS NULL").
+		WithArgs(HashAPIToken(secret)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "scopes", "expires_at"}).AddRow("3", "abcdefg", "{ENTRIES_WRITE}", 1590000000))
+
+	token, err := verifier.VerifyIDToken(context.Background(), secret)
+	require.Nil(t, err)
+	assert.Equal(t, "abcdefg", token.Subject)
+	assert.True(t, IsAPIToken(token))
+	assert.True(t, HasScope(token, "ENTRIES_WRITE"))
+	assert.False(t, HasScope(token, "ENTRIES_READ"))
+	assert.Equal(t, int64(1590000000), token.Expires)
+
+	mock.ExpectQuery("UPDATE api_tokens SET last_used_at").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "scopes", "expires_at"}))
+
+	_, err = verifier.VerifyIDToken(context.Background(), APITokenPrefix+"revoked")
+	assert.NotNil(t, err, "unknown and revoked tokens are rejected")
+
+	// Other tokens go to the next verifier without touching the database
+	idToken, _ := issuer.Mint("abcdefg", "test@example.com", time.Hour)
+	token, err = verifier.VerifyIDToken(context.Background(), idToken)
+	require.Nil(t, err)
+	assert.False(t, IsAPIToken(token))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
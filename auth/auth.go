@@ -2,11 +2,13 @@ package auth
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
 
 	"firebase.google.com/go/auth"
+	"github.com/99designs/gqlgen/handler"
 )
 
 // A private key for context that only this package can access. This is important
@@ -17,17 +19,38 @@ type contextKey struct {
 	name string
 }
 
-// Middleware decodes the share session cookie and packs the session into context
-func Middleware(client *auth.Client) func(http.Handler) http.Handler {
+// Verifier checks ID tokens. The Firebase auth client is one, LocalVerifier is another.
+type Verifier interface {
+	VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error)
+}
+
+// bearerToken pulls the token out of an "Authorization: Bearer <token>" value
+func bearerToken(authorization string) (string, bool) {
+	t := strings.SplitN(authorization, " ", 2)
+	if len(t) != 2 || t[0] != "Bearer" || t[1] == "" {
+		return "", false
+	}
+
+	return t[1], true
+}
+
+// Middleware decodes the share session cookie and packs the session into context.
+// Requests without an Authorization header carry on without a user.
+func Middleware(verifier Verifier) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var token *auth.Token
-			t := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
-			if len(t) == 2 || t[0] == "Bearer" {
+			if authorization := r.Header.Get("Authorization"); authorization != "" {
+				idToken, ok := bearerToken(authorization)
+				if !ok {
+					unauthorized(w, "Malformed Authorization header")
+					return
+				}
+
 				var err error
-				token, err = client.VerifyIDToken(context.Background(), t[1])
+				token, err = verifier.VerifyIDToken(r.Context(), idToken)
 				if err != nil {
-					http.Error(w, "Invalid token", http.StatusForbidden)
+					unauthorized(w, "Invalid token")
 					return
 				}
 
@@ -44,6 +67,29 @@ func Middleware(client *auth.Client) func(http.Handler) http.Handler {
 	}
 }
 
+func unauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, message, http.StatusUnauthorized)
+}
+
+// WebsocketInit authenticates websocket connections. Browsers can't set headers
+// on websockets so the token is sent in the connection_init payload instead.
+func WebsocketInit(verifier Verifier) func(ctx context.Context, payload handler.InitPayload) (context.Context, error) {
+	return func(ctx context.Context, payload handler.InitPayload) (context.Context, error) {
+		idToken, ok := bearerToken(payload.Authorization())
+		if !ok {
+			return ctx, nil
+		}
+
+		token, err := verifier.VerifyIDToken(ctx, idToken)
+		if err != nil {
+			return ctx, fmt.Errorf("Invalid token")
+		}
+
+		return context.WithValue(ctx, UserCtxKey, token), nil
+	}
+}
+
 // ForContext finds the user from the context. REQUIRES Middleware to have run.
 func ForContext(ctx context.Context) *auth.Token {
 	raw, _ := ctx.Value(UserCtxKey).(*auth.Token)
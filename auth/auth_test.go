@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newIssuer(t *testing.T) *Issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+
+	return NewIssuer(key)
+}
+
+func newVerifier(t *testing.T, issuer *Issuer) *LocalVerifier {
+	jwks, err := issuer.JWKS()
+	require.Nil(t, err)
+
+	verifier, err := NewLocalVerifier(jwks)
+	require.Nil(t, err)
+
+	return verifier
+}
+
+func TestLocalVerifier(t *testing.T) {
+	issuer := newIssuer(t)
+	verifier := newVerifier(t, issuer)
+
+	token, err := issuer.Mint("abcdefg", "test@example.com", time.Hour)
+	require.Nil(t, err)
+
+	verified, err := verifier.VerifyIDToken(context.Background(), token)
+	require.Nil(t, err)
+	assert.Equal(t, "abcdefg", verified.Subject)
+	assert.Equal(t, "abcdefg", verified.UID)
+	assert.Equal(t, "test@example.com", verified.Claims["email"])
+	assert.NotContains(t, verified.Claims, "sub")
+
+	expired, _ := issuer.Mint("abcdefg", "test@example.com", -time.Hour)
+	_, err = verifier.VerifyIDToken(context.Background(), expired)
+	assert.NotNil(t, err, "expired tokens are rejected")
+
+	_, err = verifier.VerifyIDToken(context.Background(), token[:len(token)-4]+"AAAA")
+	assert.NotNil(t, err, "tampered tokens are rejected")
+
+	other, _ := newIssuer(t).Mint("abcdefg", "test@example.com", time.Hour)
+	_, err = verifier.VerifyIDToken(context.Background(), other)
+	assert.NotNil(t, err, "tokens from other keys are rejected")
+}
+
+func TestMiddleware(t *testing.T) {
+	issuer := newIssuer(t)
+	token, err := issuer.Mint("abcdefg", "test@example.com", time.Hour)
+	require.Nil(t, err)
+
+	var subject string
+	handler := Middleware(newVerifier(t, issuer))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = ""
+		if user := ForContext(r.Context()); user != nil {
+			subject = user.Subject
+		}
+	}))
+
+	tests := []struct {
+		authorization string
+		status        int
+		subject       string
+	}{
+		{"", http.StatusOK, ""},
+		{"Bearer " + token, http.StatusOK, "abcdefg"},
+		{token, http.StatusUnauthorized, ""},
+		{"Bearer", http.StatusUnauthorized, ""},
+		{"Basic " + token, http.StatusUnauthorized, ""},
+		{"Bearer nonsense", http.StatusUnauthorized, ""},
+	}
+
+	for _, test := range tests {
+		subject = ""
+		req := httptest.NewRequest("POST", "/query", nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assert.Equal(t, test.status, res.Code, test.authorization)
+		assert.Equal(t, test.subject, subject, test.authorization)
+	}
+}
@@ -0,0 +1,85 @@
+// Command devtoken mints ID tokens for running the server locally without Firebase.
+//
+// The first run creates a signing key and the key set the server checks tokens
+// against. Start the server with AUTH_JWKS pointing at that key set and send the
+// printed token as "Authorization: Bearer <token>".
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/writewithwrabit/server/auth"
+)
+
+func main() {
+	keyPath := flag.String("key", ".dev/auth-key.pem", "private key to sign with, created if missing")
+	jwksPath := flag.String("jwks", ".dev/jwks.json", "where to write the key set for AUTH_JWKS")
+	uid := flag.String("uid", "6uP1r7qI8ZaYetQcGG6GYYYB2Em2", "Firebase ID of the user to sign in as")
+	email := flag.String("email", "testing@writewithwrabit.com", "email claim for the token")
+	ttl := flag.Duration("ttl", time.Hour, "how long the token is valid for")
+	flag.Parse()
+
+	if os.Getenv("NODE_ENV") != "" && os.Getenv("NODE_ENV") != "dev" {
+		log.Fatal("devtoken only mints tokens for dev")
+	}
+
+	key, err := loadKey(*keyPath)
+	if err != nil {
+		log.Fatalf("error loading key: %v", err)
+	}
+
+	issuer := auth.NewIssuer(key)
+
+	jwks, err := issuer.JWKS()
+	if err != nil {
+		log.Fatalf("error building key set: %v", err)
+	}
+
+	if err := ioutil.WriteFile(*jwksPath, jwks, 0644); err != nil {
+		log.Fatalf("error writing key set: %v", err)
+	}
+
+	token, err := issuer.Mint(*uid, *email, *ttl)
+	if err != nil {
+		log.Fatalf("error minting token: %v", err)
+	}
+
+	fmt.Println(token)
+}
+
+// loadKey reads the signing key or creates one the first time
+func loadKey(path string) (*rsa.PrivateKey, error) {
+	encoded, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+
+		block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+		return key, ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600)
+	} else if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(encoded)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM encoded key", path)
+	}
+
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// client sends GraphQL requests to the server using an API token
+type client struct {
+	server     string
+	token      string
+	httpClient *http.Client
+}
+
+func newClient(server string, token string) *client {
+	return &client{
+		server:     strings.TrimRight(server, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// gqlError is an error the server returned for the operation
+type gqlError struct {
+	Message    string `json:"message"`
+	Extensions struct {
+		Code string `json:"code"`
+	} `json:"extensions"`
+}
+
+func (e *gqlError) Error() string {
+	return e.Message
+}
+
+// isConflict checks if a save was refused because the entry changed elsewhere
+func isConflict(err error) bool {
+	e, ok := err.(*gqlError)
+	return ok && e.Extensions.Code == "CONFLICT"
+}
+
+// do runs the operation and decodes its data into out
+func (c *client) do(query string, variables map[string]interface{}, out interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", c.server+"/query", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("the server didn't accept your API token")
+	}
+
+	var res struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []*gqlError     `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("unexpected response from the server (%s): %v", resp.Status, err)
+	}
+
+	if len(res.Errors) > 0 {
+		return res.Errors[0]
+	}
+
+	return json.Unmarshal(res.Data, out)
+}
+
+// entry is the part of an entry the CLI uses
+type entry struct {
+	ID              string  `json:"id"`
+	Kind            string  `json:"kind"`
+	Title           *string `json:"title"`
+	Content         string  `json:"content"`
+	WordCount       int     `json:"wordCount"`
+	GoalHit         bool    `json:"goalHit"`
+	Version         int     `json:"version"`
+	ClientEncrypted bool    `json:"clientEncrypted"`
+	CreatedAt       string  `json:"createdAt"`
+}
+
+const entryFields = "id kind title content wordCount goalHit version clientEncrypted createdAt"
+
+// viewer finds the user the token belongs to
+func (c *client) viewer() (string, error) {
+	var res struct {
+		Viewer struct {
+			UserID string `json:"userID"`
+		} `json:"viewer"`
+	}
+	if err := c.do("query { viewer { userID } }", nil, &res); err != nil {
+		return "", err
+	}
+
+	return res.Viewer.UserID, nil
+}
+
+func (c *client) dailyEntry(userID string, date string) (*entry, error) {
+	var res struct {
+		DailyEntry *entry `json:"dailyEntry"`
+	}
+	err := c.do("query ($userID: ID!, $date: String!) { dailyEntry(userID: $userID, date: $date) { "+entryFields+" } }", map[string]interface{}{
+		"userID": userID,
+		"date":   date,
+	}, &res)
+
+	return res.DailyEntry, err
+}
+
+func (c *client) wordGoal(userID string, date string) (int, error) {
+	var res struct {
+		WordGoal int `json:"wordGoal"`
+	}
+	err := c.do("query ($userID: ID!, $date: String!) { wordGoal(userID: $userID, date: $date) }", map[string]interface{}{
+		"userID": userID,
+		"date":   date,
+	}, &res)
+
+	return res.WordGoal, err
+}
+
+func (c *client) updateEntry(id string, input map[string]interface{}, date string) (*entry, error) {
+	var res struct {
+		UpdateEntry *entry `json:"updateEntry"`
+	}
+	err := c.do("mutation ($id: ID!, $input: ExistingEntry!, $date: String!) { updateEntry(id: $id, input: $input, date: $date) { "+entryFields+" } }", map[string]interface{}{
+		"id":    id,
+		"input": input,
+		"date":  date,
+	}, &res)
+
+	return res.UpdateEntry, err
+}
+
+func (c *client) entries(userID string, from *string, to *string) ([]*entry, error) {
+	var res struct {
+		Entries []*entry `json:"entriesByUserID"`
+	}
+	err := c.do("query ($userID: ID!, $from: String, $to: String) { entriesByUserID(userID: $userID, startDate: $from, endDate: $to) { "+entryFields+" } }", map[string]interface{}{
+		"userID": userID,
+		"from":   from,
+		"to":     to,
+	}, &res)
+
+	return res.Entries, err
+}
+
+type stats struct {
+	WordsWritten       int `json:"wordsWritten"`
+	LongestStreak      int `json:"longestStreak"`
+	LongestEntry       int `json:"longestEntry"`
+	PreferredDayOfWeek int `json:"preferredDayOfWeek"`
+}
+
+func (c *client) stats() (*stats, error) {
+	var res struct {
+		Stats *stats `json:"stats"`
+	}
+	err := c.do("query { stats(global: false) { wordsWritten longestStreak longestEntry preferredDayOfWeek } }", nil, &res)
+
+	return res.Stats, err
+}
+
+type streakStatus struct {
+	DayCount         int     `json:"dayCount"`
+	LastGoalHitOn    *string `json:"lastGoalHitOn"`
+	RestDays         []int   `json:"restDays"`
+	FreezesRemaining int     `json:"freezesRemaining"`
+	DaysAtRisk       int     `json:"daysAtRisk"`
+}
+
+func (c *client) streakStatus(userID string) (*streakStatus, error) {
+	var res struct {
+		StreakStatus *streakStatus `json:"streakStatus"`
+	}
+	err := c.do("query ($userID: ID!) { streakStatus(userID: $userID) { dayCount lastGoalHitOn restDays freezesRemaining daysAtRisk } }", map[string]interface{}{
+		"userID": userID,
+	}, &res)
+
+	return res.StreakStatus, err
+}
@@ -0,0 +1,296 @@
+// Command wrabit is for writing in Wrabit from the terminal.
+//
+// It signs in with a personal API token (see createAPIToken) read from
+// WRABIT_TOKEN and talks to the GraphQL API at WRABIT_SERVER.
+//
+//	wrabit [write]     open today's entry in $EDITOR and save it
+//	wrabit stats       show your all time stats
+//	wrabit streak      show your current streak
+//	wrabit export      print your entries as markdown or JSON
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: wrabit [flags] [command]
+
+Commands:
+  write    open today's entry in $EDITOR and save it (the default)
+  stats    show your all time stats
+  streak   show your current streak
+  export   print your entries as markdown or JSON
+
+Flags:
+`
+
+func main() {
+	server := flag.String("server", envOr("WRABIT_SERVER", "http://localhost:8080"), "Wrabit server to use, defaults to $WRABIT_SERVER")
+	token := flag.String("token", os.Getenv("WRABIT_TOKEN"), "API token to sign in with, defaults to $WRABIT_TOKEN")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *token == "" {
+		fail(fmt.Errorf("no API token, create one in the app and set WRABIT_TOKEN"))
+	}
+
+	c := newClient(*server, *token)
+
+	command, args := "write", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "write":
+		err = write(c, args)
+	case "stats":
+		err = showStats(c, os.Stdout)
+	case "streak":
+		err = showStreak(c, os.Stdout)
+	case "export":
+		err = export(c, args, os.Stdout)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fail(err)
+	}
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "wrabit: %v\n", err)
+	os.Exit(1)
+}
+
+// write opens today's daily entry in the user's editor and saves what they wrote
+func write(c *client, args []string) error {
+	flags := flag.NewFlagSet("write", flag.ExitOnError)
+	flatten := flags.Bool("flatten", false, "edit entries with formatting as plain text, dropping the formatting")
+	flags.Parse(args)
+
+	userID, err := c.viewer()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	entry, err := c.dailyEntry(userID, today.Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+
+	if entry.ClientEncrypted {
+		return fmt.Errorf("today's entry is encrypted on your devices, the terminal can't read it")
+	}
+
+	text, formatted := toText(entry.Content)
+	if formatted && !*flatten {
+		return fmt.Errorf("today's entry has formatting the terminal can't keep, edit it in the app or run wrabit write -flatten")
+	}
+
+	goal, err := c.wordGoal(userID, now.Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile("", "wrabit-*.txt")
+	if err != nil {
+		return err
+	}
+	path := file.Name()
+
+	_, err = file.WriteString(text)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := edit(path); err != nil {
+		return fmt.Errorf("%v, your text is in %s", err, path)
+	}
+
+	edited, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(string(edited)) == text {
+		os.Remove(path)
+		fmt.Println("No changes")
+		return nil
+	}
+
+	wordCount := countWords(string(edited))
+	goalHit := entry.GoalHit || wordCount >= goal
+
+	saved, err := c.updateEntry(entry.ID, map[string]interface{}{
+		"userID":    userID,
+		"content":   toHTML(string(edited)),
+		"wordCount": wordCount,
+		"goalHit":   goalHit,
+		"version":   entry.Version,
+	}, time.Now().Format(time.RFC3339))
+	if isConflict(err) {
+		return fmt.Errorf("today's entry was changed on another device, your text is in %s", path)
+	} else if err != nil {
+		return fmt.Errorf("%v, your text is in %s", err, path)
+	}
+
+	os.Remove(path)
+
+	if saved.GoalHit {
+		fmt.Printf("Saved %d words, goal of %d hit!\n", saved.WordCount, goal)
+	} else {
+		fmt.Printf("Saved %d of %d words\n", saved.WordCount, goal)
+	}
+
+	return nil
+}
+
+// edit opens the file in $VISUAL or $EDITOR and waits for it to close
+func edit(path string) error {
+	editor := envOr("VISUAL", envOr("EDITOR", "vi"))
+
+	command := strings.Fields(editor)
+	cmd := exec.Command(command[0], append(command[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func showStats(c *client, out io.Writer) error {
+	stats, err := c.stats()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Words written\t%d\n", stats.WordsWritten)
+	fmt.Fprintf(w, "Longest streak\t%d days\n", stats.LongestStreak)
+	fmt.Fprintf(w, "Longest entry\t%d words\n", stats.LongestEntry)
+	fmt.Fprintf(w, "Favourite day\t%s\n", time.Weekday(stats.PreferredDayOfWeek))
+
+	return w.Flush()
+}
+
+func showStreak(c *client, out io.Writer) error {
+	userID, err := c.viewer()
+	if err != nil {
+		return err
+	}
+
+	status, err := c.streakStatus(userID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Current streak\t%d days\n", status.DayCount)
+	if status.LastGoalHitOn != nil {
+		fmt.Fprintf(w, "Last goal hit\t%s\n", *status.LastGoalHitOn)
+	}
+	fmt.Fprintf(w, "Freezes left\t%d\n", status.FreezesRemaining)
+	if status.DaysAtRisk > 0 {
+		fmt.Fprintf(w, "At risk\tHit today's goal to keep your %d day streak\n", status.DaysAtRisk)
+	}
+
+	return w.Flush()
+}
+
+// export prints entries, oldest first
+func export(c *client, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	from := flags.String("from", "", "only entries written on or after this date (YYYY-MM-DD)")
+	to := flags.String("to", "", "only entries written before this date (YYYY-MM-DD)")
+	format := flags.String("format", "markdown", "markdown or json")
+	flags.Parse(args)
+
+	if *format != "markdown" && *format != "json" {
+		return fmt.Errorf("unknown format %q, use markdown or json", *format)
+	}
+
+	userID, err := c.viewer()
+	if err != nil {
+		return err
+	}
+
+	entries, err := c.entries(userID, optional(*from), optional(*to))
+	if err != nil {
+		return err
+	}
+
+	// The server lists the newest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+
+	for _, entry := range entries {
+		fmt.Fprintf(out, "# %s", day(entry.CreatedAt))
+		if entry.Title != nil && *entry.Title != "" {
+			fmt.Fprintf(out, " %s", *entry.Title)
+		}
+		fmt.Fprint(out, "\n\n")
+
+		if entry.ClientEncrypted {
+			fmt.Fprint(out, "_Encrypted on your devices._\n\n")
+			continue
+		}
+
+		text, _ := toText(entry.Content)
+		fmt.Fprintf(out, "%s\n\n", text)
+	}
+
+	return nil
+}
+
+func optional(value string) *string {
+	if value == "" {
+		return nil
+	}
+
+	return &value
+}
+
+// day formats when an entry was written as a local date
+func day(createdAt string) string {
+	t, err := time.Parse(time.RFC3339, createdAt)
+	if err != nil {
+		return createdAt
+	}
+
+	return t.Local().Format("Monday, January 2, 2006")
+}
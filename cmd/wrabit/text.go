@@ -0,0 +1,74 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// The app saves entries as HTML. The terminal edits them as plain text where
+// blank lines separate paragraphs.
+var (
+	lineBreak  = regexp.MustCompile(`(?i)<br\s*/?>`)
+	blockEnd   = regexp.MustCompile(`(?i)</(p|div)>`)
+	tag        = regexp.MustCompile(`<(/?)([a-zA-Z0-9]+)[^>]*>`)
+	blankLines = regexp.MustCompile(`\n[ \t]*\n\s*`)
+)
+
+// Tags that only lay out paragraphs and survive a trip through the terminal
+var plainTags = map[string]bool{
+	"p":   true,
+	"br":  true,
+	"div": true,
+}
+
+// toText turns an entry's HTML into text for the editor.
+// It reports whether formatting (like bold or lists) had to be dropped.
+func toText(content string) (string, bool) {
+	formatted := false
+	for _, match := range tag.FindAllStringSubmatch(content, -1) {
+		if !plainTags[strings.ToLower(match[2])] {
+			formatted = true
+		}
+	}
+
+	text := lineBreak.ReplaceAllString(content, "\n")
+	text = blockEnd.ReplaceAllString(text, "\n\n")
+	text = tag.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+	text = blankLines.ReplaceAllString(text, "\n\n")
+
+	return strings.TrimSpace(text), formatted
+}
+
+// toHTML turns text from the editor back into paragraphs
+func toHTML(text string) string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+
+	var b strings.Builder
+	for _, paragraph := range blankLines.Split(strings.TrimSpace(text), -1) {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+
+		b.WriteString("<p>")
+		b.WriteString(strings.Replace(html.EscapeString(paragraph), "\n", "<br>", -1))
+		b.WriteString("</p>")
+	}
+
+	return b.String()
+}
+
+// countWords counts whitespace separated words, skipping lone punctuation
+func countWords(text string) int {
+	count := 0
+	for _, field := range strings.Fields(text) {
+		if strings.IndexFunc(field, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			count++
+		}
+	}
+
+	return count
+}
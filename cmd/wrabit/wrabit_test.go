@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer answers the CLI's operations and remembers the last save
+type fakeServer struct {
+	content  string
+	conflict bool
+	saved    map[string]interface{}
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer wrb_test" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	var res interface{}
+	switch {
+	case strings.Contains(req.Query, "viewer"):
+		res = map[string]interface{}{"data": map[string]interface{}{"viewer": map[string]string{"userID": "abcdefg"}}}
+	case strings.Contains(req.Query, "dailyEntry"):
+		res = map[string]interface{}{"data": map[string]interface{}{"dailyEntry": map[string]interface{}{"id": "1", "content": f.content, "wordCount": 2, "version": 3}}}
+	case strings.Contains(req.Query, "wordGoal"):
+		res = map[string]interface{}{"data": map[string]interface{}{"wordGoal": 5}}
+	case strings.Contains(req.Query, "updateEntry"):
+		f.saved = req.Variables["input"].(map[string]interface{})
+		if f.conflict {
+			res = map[string]interface{}{"errors": []interface{}{map[string]interface{}{
+				"message":    "Entry has been updated on another device",
+				"extensions": map[string]string{"code": "CONFLICT"},
+			}}}
+		} else {
+			res = map[string]interface{}{"data": map[string]interface{}{"updateEntry": map[string]interface{}{"id": "1", "wordCount": f.saved["wordCount"], "goalHit": f.saved["goalHit"]}}}
+		}
+	case strings.Contains(req.Query, "entriesByUserID"):
+		res = map[string]interface{}{"data": map[string]interface{}{"entriesByUserID": []interface{}{
+			map[string]interface{}{"id": "2", "content": "<p>Second</p>", "createdAt": "2020-03-02T12:00:00Z"},
+			map[string]interface{}{"id": "1", "content": "<p>First</p>", "createdAt": "2020-03-01T12:00:00Z"},
+		}}}
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// withEditor points $VISUAL at a script that appends a line to the file it is given
+func withEditor(t *testing.T, line string) func() {
+	dir, err := ioutil.TempDir("", "wrabit-test")
+	require.Nil(t, err)
+
+	script := filepath.Join(dir, "editor.sh")
+	require.Nil(t, ioutil.WriteFile(script, []byte("#!/bin/sh\nprintf '\\n\\n"+line+"' >> \"$1\"\n"), 0700))
+
+	previous := os.Getenv("VISUAL")
+	os.Setenv("VISUAL", script)
+
+	return func() {
+		os.Setenv("VISUAL", previous)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	defer withEditor(t, "Three more words")()
+
+	fake := &fakeServer{content: "<p>Dear diary</p>"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	require.Nil(t, write(newClient(server.URL, "wrb_test"), nil))
+	assert.Equal(t, "<p>Dear diary</p><p>Three more words</p>", fake.saved["content"])
+	assert.Equal(t, float64(5), fake.saved["wordCount"])
+	assert.Equal(t, true, fake.saved["goalHit"])
+	assert.Equal(t, float64(3), fake.saved["version"], "saves are based on the version that was edited")
+}
+
+func TestWriteKeepsTextOnConflict(t *testing.T) {
+	defer withEditor(t, "Lost?")()
+
+	fake := &fakeServer{content: "<p>Dear diary</p>", conflict: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	err := write(newClient(server.URL, "wrb_test"), nil)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "changed on another device")
+
+	path := err.Error()[strings.LastIndex(err.Error(), " ")+1:]
+	defer os.Remove(path)
+
+	text, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	assert.Contains(t, string(text), "Lost?")
+}
+
+func TestWriteRefusesFormatting(t *testing.T) {
+	fake := &fakeServer{content: "<p>Dear <b>diary</b></p>"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	assert.NotNil(t, write(newClient(server.URL, "wrb_test"), nil))
+	assert.Nil(t, fake.saved)
+}
+
+func TestExport(t *testing.T) {
+	server := httptest.NewServer(&fakeServer{})
+	defer server.Close()
+
+	var out bytes.Buffer
+	require.Nil(t, export(newClient(server.URL, "wrb_test"), nil, &out))
+	assert.True(t, strings.Index(out.String(), "First") < strings.Index(out.String(), "Second"), "oldest entries come first")
+
+	err := export(newClient(server.URL, "wrb_nope"), nil, &out)
+	assert.NotNil(t, err, "bad tokens are reported")
+}
+
+func TestText(t *testing.T) {
+	text, formatted := toText("<p>Dear diary,</p><p>Today &amp; tomorrow<br>are mine</p>")
+	assert.Equal(t, "Dear diary,\n\nToday & tomorrow\nare mine", text)
+	assert.False(t, formatted)
+
+	_, formatted = toText("<ul><li>One</li></ul>")
+	assert.True(t, formatted)
+
+	assert.Equal(t, "<p>Dear diary,</p><p>Today &amp; tomorrow<br>are mine</p>", toHTML(text))
+	assert.Equal(t, "", toHTML("\n\n"))
+	assert.Equal(t, 6, countWords(text))
+}
@@ -0,0 +1,31 @@
+package cron
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Job is a unit of scheduled work. It receives the time the cron fired.
+type Job func(ctx context.Context, now time.Time) error
+
+// Handler runs the job when App Engine cron calls the endpoint.
+// App Engine strips the X-Appengine-Cron header from external requests
+// so its presence is enough to know the call came from the scheduler.
+func Handler(name string, job Job) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Appengine-Cron") != "true" {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+
+		if err := job(r.Context(), time.Now().UTC()); err != nil {
+			log.Printf("cron %s failed: %v", name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	})
+}
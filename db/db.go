@@ -35,3 +35,28 @@ func LogAndExec(db *sql.DB, query string, args ...interface{}) sql.Result {
 
 	return res
 }
+
+// LogAndExecTx runs a statement inside a transaction.
+// Unlike the other helpers it returns errors so the caller can roll back.
+func LogAndExecTx(tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	fmt.Println(query)
+	fmt.Println(args...)
+
+	return tx.Exec(query, args...)
+}
+
+// LogAndQueryRowTx runs a single row query inside a transaction
+func LogAndQueryRowTx(tx *sql.Tx, query string, args ...interface{}) *sql.Row {
+	fmt.Println(query)
+	fmt.Println(args...)
+
+	return tx.QueryRow(query, args...)
+}
+
+// LogAndQueryTx runs a query inside a transaction
+func LogAndQueryTx(tx *sql.Tx, query string, args ...interface{}) (*sql.Rows, error) {
+	fmt.Println(query)
+	fmt.Println(args...)
+
+	return tx.Query(query, args...)
+}
@@ -0,0 +1,36 @@
+-- The key database is kept apart from the main database and backed up
+-- separately, for no longer than keystore.BackupRetention
+
+CREATE TABLE encryption_keys (
+  id SERIAL,
+  user_id VARCHAR,
+  wrapped_key VARCHAR,
+  user_key BOOLEAN NOT NULL DEFAULT false,
+  destroyed_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX encryption_keys_user_key ON encryption_keys (user_id) WHERE user_key AND destroyed_at IS NULL;
+
+CREATE TABLE key_audit (
+  id SERIAL,
+  key_id INT,
+  user_id VARCHAR,
+  action VARCHAR NOT NULL,
+  reason VARCHAR,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE OR REPLACE FUNCTION trigger_updated()
+RETURNS TRIGGER AS $$
+BEGIN
+  NEW.updated_at = NOW();
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER updated
+BEFORE UPDATE ON encryption_keys
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_updated();
@@ -1,12 +1,18 @@
 CREATE TABLE users (
   id SERIAL,
-  firebase_id VARCHAR,
+  firebase_id VARCHAR UNIQUE,
   stripe_id VARCHAR,
   stripe_subscription_id VARCHAR,
   first_name VARCHAR,
   last_name VARCHAR,
   email VARCHAR,
   word_goal INT NOT NULL DEFAULT 1000,
+  timezone VARCHAR NOT NULL DEFAULT 'UTC',
+  client_encryption BOOLEAN NOT NULL DEFAULT false,
+  client_encryption_params VARCHAR,
+  rest_days INT[] NOT NULL DEFAULT '{}',
+  streak_freezes INT NOT NULL DEFAULT 0,
+  signed_up_at TIMESTAMPTZ,
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
   updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
@@ -28,7 +34,15 @@ CREATE TABLE entries (
   content TEXT,
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
   updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-  goal_hit BOOLEAN DEFAULT false
+  goal_hit BOOLEAN DEFAULT false,
+  version INT NOT NULL DEFAULT 1,
+  key_id INT,
+  client_encrypted BOOLEAN NOT NULL DEFAULT false,
+  kind VARCHAR NOT NULL DEFAULT 'DAILY',
+  title TEXT,
+  started_at TIMESTAMPTZ,
+  goal_hit_at TIMESTAMPTZ,
+  deleted_at TIMESTAMPTZ
 );
 
 CREATE TABLE streaks (
@@ -47,7 +61,161 @@ CREATE TABLE donations (
   paid BOOLEAN DEFAULT false,
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
   updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-  entry_id VARCHAR
+  entry_id VARCHAR,
+  voided_at TIMESTAMPTZ
+);
+
+CREATE TABLE reminders (
+  id SERIAL,
+  user_id VARCHAR UNIQUE,
+  time VARCHAR NOT NULL,
+  days_of_week INT[] NOT NULL DEFAULT '{0,1,2,3,4,5,6}',
+  channel VARCHAR NOT NULL DEFAULT 'EMAIL',
+  enabled BOOLEAN NOT NULL DEFAULT true,
+  last_sent_on DATE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE push_subscriptions (
+  id SERIAL,
+  user_id VARCHAR,
+  endpoint VARCHAR UNIQUE,
+  p256dh VARCHAR NOT NULL,
+  auth VARCHAR NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE account_deletions (
+  id SERIAL,
+  user_id VARCHAR UNIQUE,
+  email VARCHAR,
+  purge_after TIMESTAMPTZ NOT NULL,
+  completed_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE tags (
+  id SERIAL,
+  user_id VARCHAR,
+  name VARCHAR NOT NULL,
+  name_hash VARCHAR NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (user_id, name_hash)
+);
+
+CREATE TABLE entry_tags (
+  entry_id INT,
+  tag_id INT,
+  user_id VARCHAR,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (entry_id, tag_id)
+);
+
+CREATE TABLE check_ins (
+  id SERIAL,
+  user_id VARCHAR,
+  entry_id INT,
+  date DATE NOT NULL,
+  mood VARCHAR,
+  energy INT,
+  trackers JSONB NOT NULL DEFAULT '[]',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (user_id, date)
+);
+
+CREATE TABLE frozen_days (
+  id SERIAL,
+  user_id VARCHAR,
+  streak_id INT,
+  day DATE NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (user_id, day)
+);
+
+CREATE TABLE achievements (
+  id SERIAL,
+  user_id VARCHAR,
+  kind VARCHAR NOT NULL,
+  entry_id INT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (user_id, kind)
+);
+
+CREATE TABLE daily_user_stats (
+  user_id VARCHAR,
+  day DATE NOT NULL,
+  words_written INT NOT NULL DEFAULT 0,
+  entries INT NOT NULL DEFAULT 0,
+  longest_entry INT NOT NULL DEFAULT 0,
+  goal_hit BOOLEAN NOT NULL DEFAULT false,
+  hours INT[] NOT NULL DEFAULT '{}',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, day)
+);
+
+CREATE TABLE entry_insights (
+  entry_id INT PRIMARY KEY,
+  user_id VARCHAR,
+  analysis TEXT NOT NULL,
+  key_id INT,
+  word_count INT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE email_changes (
+  id SERIAL,
+  user_id VARCHAR,
+  email VARCHAR NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  confirmed_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE api_tokens (
+  id SERIAL,
+  user_id VARCHAR,
+  name VARCHAR NOT NULL,
+  prefix VARCHAR NOT NULL,
+  token_hash VARCHAR NOT NULL UNIQUE,
+  scopes VARCHAR[] NOT NULL DEFAULT '{}',
+  last_used_at TIMESTAMPTZ,
+  expires_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE webhooks (
+  id SERIAL,
+  user_id VARCHAR,
+  url VARCHAR NOT NULL,
+  events VARCHAR[] NOT NULL DEFAULT '{}',
+  secret VARCHAR NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE webhook_deliveries (
+  id SERIAL,
+  webhook_id INT NOT NULL,
+  user_id VARCHAR,
+  event VARCHAR NOT NULL,
+  payload TEXT NOT NULL,
+  attempts INT NOT NULL DEFAULT 0,
+  status_code INT,
+  error VARCHAR,
+  delivered_at TIMESTAMPTZ,
+  next_attempt_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
 CREATE OR REPLACE FUNCTION trigger_updated()
@@ -83,4 +251,59 @@ BEFORE UPDATE ON donations
 FOR EACH ROW
 EXECUTE PROCEDURE trigger_updated();
 
+CREATE TRIGGER updated
+BEFORE UPDATE ON reminders
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_updated();
+
+CREATE TRIGGER updated
+BEFORE UPDATE ON push_subscriptions
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_updated();
+
+CREATE TRIGGER updated
+BEFORE UPDATE ON account_deletions
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_updated();
+
+CREATE TRIGGER updated
+BEFORE UPDATE ON tags
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_updated();
+
+CREATE TRIGGER updated
+BEFORE UPDATE ON check_ins
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_updated();
+
+CREATE TRIGGER updated
+BEFORE UPDATE ON daily_user_stats
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_updated();
+
+CREATE TRIGGER updated
+BEFORE UPDATE ON entry_insights
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_updated();
+
+CREATE TRIGGER updated
+BEFORE UPDATE ON email_changes
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_updated();
+
+CREATE TRIGGER updated
+BEFORE UPDATE ON api_tokens
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_updated();
+
+CREATE TRIGGER updated
+BEFORE UPDATE ON webhooks
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_updated();
+
+CREATE TRIGGER updated
+BEFORE UPDATE ON webhook_deliveries
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_updated();
+
 INSERT INTO users (firebase_id, stripe_id, stripe_subscription_id, first_name, last_name, email, word_goal) VALUES ('6uP1r7qI8ZaYetQcGG6GYYYB2Em2', 'cus_GIHI1V0ryeznB2', 'sub_GIHImr4be4B275', 'Test', 'Account', 'testing@writewithwrabit.com', 1000);
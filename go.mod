@@ -10,6 +10,7 @@ require (
 	github.com/GoogleCloudPlatform/cloudsql-proxy v1.17.0
 	github.com/go-chi/chi v4.1.0+incompatible
 	github.com/go-chi/cors v1.1.1
+	github.com/gorilla/websocket v1.2.0
 	github.com/joho/godotenv v1.3.0
 	github.com/lib/pq v1.3.0
 	github.com/mailgun/mailgun-go/v3 v3.6.4
@@ -0,0 +1,169 @@
+package insights
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/writewithwrabit/server/stats"
+)
+
+// How many keywords are kept for each entry
+const keywordsPerEntry = 10
+
+// Keyword is a word and how often it was used
+type Keyword struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+// Analysis is everything learned from a single entry
+type Analysis struct {
+	Keywords     []Keyword `json:"keywords"`
+	Sentiment    float64   `json:"sentiment"`
+	ReadingEase  float64   `json:"readingEase"`
+	GradeLevel   float64   `json:"gradeLevel"`
+	Words        int       `json:"words"`
+	Sentences    int       `json:"sentences"`
+	AverageWords float64   `json:"averageWords"`
+}
+
+// Analyze runs the whole pipeline on an entry's content.
+// Everything happens in process so entries never leave the server.
+func Analyze(content string) *Analysis {
+	words := stats.Words(content)
+	sentences := Sentences(content)
+
+	analysis := &Analysis{
+		Keywords:  Keywords(words, keywordsPerEntry),
+		Sentiment: Sentiment(words),
+		Words:     len(words),
+		Sentences: sentences,
+	}
+
+	if len(words) > 0 && sentences > 0 {
+		analysis.AverageWords = float64(len(words)) / float64(sentences)
+		analysis.ReadingEase, analysis.GradeLevel = Readability(words, sentences)
+	}
+
+	return analysis
+}
+
+// Keywords are the most used words that aren't stop words
+func Keywords(words []string, limit int) []Keyword {
+	counts := map[string]int{}
+	for _, word := range words {
+		word = strings.Trim(word, "'")
+		if len(word) < 3 || stopWords[word] {
+			continue
+		}
+
+		counts[word]++
+	}
+
+	keywords := []Keyword{}
+	for word, count := range counts {
+		keywords = append(keywords, Keyword{Word: word, Count: count})
+	}
+
+	sort.Slice(keywords, func(i, j int) bool {
+		if keywords[i].Count == keywords[j].Count {
+			return keywords[i].Word < keywords[j].Word
+		}
+
+		return keywords[i].Count > keywords[j].Count
+	})
+
+	if len(keywords) > limit {
+		keywords = keywords[:limit]
+	}
+
+	return keywords
+}
+
+// Sentiment scores words against the lexicon, from -1 (negative) to 1 (positive).
+// A negation flips the word that follows it.
+func Sentiment(words []string) float64 {
+	var score float64
+	var scored int
+	for i, word := range words {
+		value, ok := lexicon[word]
+		if !ok {
+			continue
+		}
+
+		if i > 0 && negations[words[i-1]] {
+			value = -value
+		}
+
+		score += float64(value)
+		scored++
+	}
+
+	if scored == 0 {
+		return 0
+	}
+
+	// Lexicon values run from -3 to 3
+	return math.Max(-1, math.Min(1, score/float64(scored)/3))
+}
+
+// Block tags end a sentence even when the writer left off the punctuation
+var blocks = regexp.MustCompile(`(?i)</(p|div|li|h[1-6]|blockquote)>|<br\s*/?>|\n`)
+
+// Sentences counts the sentences in the content
+func Sentences(content string) int {
+	text := stats.PlainText(blocks.ReplaceAllString(content, "."))
+
+	count := 0
+	for _, sentence := range strings.FieldsFunc(text, func(r rune) bool { return r == '.' || r == '!' || r == '?' }) {
+		if len(stats.Words(sentence)) > 0 {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Readability is the Flesch reading ease and Flesch-Kincaid grade level
+func Readability(words []string, sentences int) (float64, float64) {
+	syllables := 0
+	for _, word := range words {
+		syllables += Syllables(word)
+	}
+
+	wordsPerSentence := float64(len(words)) / float64(sentences)
+	syllablesPerWord := float64(syllables) / float64(len(words))
+
+	ease := 206.835 - 1.015*wordsPerSentence - 84.6*syllablesPerWord
+	grade := 0.39*wordsPerSentence + 11.8*syllablesPerWord - 15.59
+
+	return ease, math.Max(0, grade)
+}
+
+// Syllables estimates the syllables in a word by counting vowel groups
+func Syllables(word string) int {
+	word = strings.Trim(strings.ToLower(word), "'")
+
+	count := 0
+	previousVowel := false
+	for _, r := range word {
+		vowel := strings.ContainsRune("aeiouy", r)
+		if vowel && !previousVowel {
+			count++
+		}
+		previousVowel = vowel
+	}
+
+	// A trailing e is usually silent, as in "write"
+	if strings.HasSuffix(word, "e") && !strings.HasSuffix(word, "le") && count > 1 {
+		count--
+	}
+
+	if count == 0 {
+		return 1
+	}
+
+	return count
+}
@@ -0,0 +1,69 @@
+package insights
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeywords(t *testing.T) {
+	words := []string{"the", "garden", "was", "quiet", "garden", "rain", "rain", "garden", "it's"}
+
+	assert.Equal(t, []Keyword{{"garden", 3}, {"rain", 2}, {"quiet", 1}}, Keywords(words, 10))
+	assert.Equal(t, []Keyword{{"garden", 3}}, Keywords(words, 1))
+}
+
+func TestSentiment(t *testing.T) {
+	assert.Equal(t, 0.0, Sentiment([]string{"the", "table"}))
+	assert.True(t, Sentiment([]string{"a", "wonderful", "happy", "day"}) > 0.5)
+	assert.True(t, Sentiment([]string{"i", "was", "not", "happy"}) < 0)
+	assert.Equal(t, -1.0, Sentiment([]string{"terrible", "awful"}))
+}
+
+func TestSentences(t *testing.T) {
+	assert.Equal(t, 3, Sentences("<p>One. Two!</p><p>Three without punctuation</p>"))
+	assert.Equal(t, 0, Sentences("<p></p>"))
+}
+
+func TestSyllables(t *testing.T) {
+	assert.Equal(t, 1, Syllables("write"))
+	assert.Equal(t, 2, Syllables("table"))
+	assert.Equal(t, 3, Syllables("beautiful"))
+	assert.Equal(t, 1, Syllables("hmm"))
+}
+
+func TestAnalyze(t *testing.T) {
+	analysis := Analyze("<p>The cat sat on the mat. The cat was happy.</p>")
+
+	require.NotEmpty(t, analysis.Keywords)
+	assert.Equal(t, Keyword{"cat", 2}, analysis.Keywords[0])
+	assert.Equal(t, 10, analysis.Words)
+	assert.Equal(t, 2, analysis.Sentences)
+	assert.Equal(t, 5.0, analysis.AverageWords)
+	assert.True(t, analysis.Sentiment > 0)
+	assert.True(t, analysis.ReadingEase > 90)
+
+	assert.Equal(t, 0.0, Analyze("").ReadingEase)
+}
+
+func TestSummarize(t *testing.T) {
+	summary := Summarize("2020-03", []*Analysis{
+		{Keywords: []Keyword{{"garden", 2}, {"rain", 1}}, Sentiment: 0.5, ReadingEase: 80, GradeLevel: 4, Words: 20, Sentences: 2},
+		{Keywords: []Keyword{{"rain", 3}}, Sentiment: -0.1, ReadingEase: 60, GradeLevel: 8, Words: 10, Sentences: 1},
+		{Words: 0},
+	})
+
+	assert.Equal(t, "2020-03", summary.Month)
+	assert.Equal(t, 2, summary.EntriesAnalyzed)
+	assert.Equal(t, "rain", summary.Keywords[0].Word)
+	assert.Equal(t, 4, summary.Keywords[0].Count)
+	assert.InDelta(t, 0.2, *summary.AverageSentiment, 0.0001)
+	assert.Equal(t, 70.0, *summary.AverageReadingEase)
+	assert.Equal(t, 6.0, *summary.AverageGradeLevel)
+
+	empty := Summarize("2020-04", nil)
+	assert.Equal(t, 0, empty.EntriesAnalyzed)
+	assert.Nil(t, empty.AverageSentiment)
+	assert.NotNil(t, empty.Keywords)
+}
@@ -0,0 +1,50 @@
+package insights
+
+// Common words that say nothing about what an entry is about
+var stopWords = toSet(
+	"about", "above", "after", "again", "against", "all", "also", "and", "any", "are", "aren't", "because",
+	"been", "before", "being", "below", "between", "both", "but", "can", "can't", "cannot", "could", "couldn't",
+	"did", "didn't", "does", "doesn't", "doing", "don't", "down", "during", "each", "even", "ever", "every",
+	"few", "for", "from", "further", "get", "got", "had", "hadn't", "has", "hasn't", "have", "haven't", "having",
+	"her", "here", "hers", "herself", "him", "himself", "his", "how", "i'd", "i'll", "i'm", "i've", "into",
+	"isn't", "it's", "its", "itself", "just", "let's", "like", "more", "most", "much", "mustn't", "myself",
+	"nor", "not", "now", "off", "once", "only", "other", "ought", "our", "ours", "ourselves", "out", "over",
+	"own", "really", "same", "she", "she's", "should", "shouldn't", "some", "still", "such", "than", "that",
+	"that's", "the", "their", "theirs", "them", "themselves", "then", "there", "there's", "these", "they",
+	"they're", "thing", "things", "this", "those", "through", "too", "under", "until", "very", "was", "wasn't",
+	"way", "well", "were", "weren't", "what", "what's", "when", "where", "which", "while", "who", "whom", "why",
+	"will", "with", "won't", "would", "wouldn't", "yet", "you", "you're", "your", "yours", "yourself",
+)
+
+// Words that flip the sentiment of the word after them
+var negations = toSet(
+	"not", "no", "never", "don't", "didn't", "doesn't", "isn't", "wasn't", "aren't", "weren't", "can't",
+	"couldn't", "won't", "wouldn't", "hardly",
+)
+
+// Sentiment values from -3 (very negative) to 3 (very positive)
+var lexicon = map[string]int{
+	"amazing": 3, "awesome": 3, "beautiful": 3, "blessed": 3, "brilliant": 3, "ecstatic": 3, "excellent": 3,
+	"fantastic": 3, "joy": 3, "joyful": 3, "love": 3, "loved": 3, "perfect": 3, "thrilled": 3, "wonderful": 3,
+	"accomplished": 2, "calm": 2, "celebrate": 2, "confident": 2, "delighted": 2, "energized": 2, "excited": 2,
+	"fun": 2, "glad": 2, "grateful": 2, "great": 2, "happy": 2, "hope": 2, "hopeful": 2, "inspired": 2,
+	"laugh": 2, "laughed": 2, "lucky": 2, "peaceful": 2, "proud": 2, "relaxed": 2, "relieved": 2, "thankful": 2,
+	"better": 1, "content": 1, "enjoy": 1, "enjoyed": 1, "fine": 1, "good": 1, "interesting": 1, "kind": 1,
+	"nice": 1, "okay": 1, "productive": 1, "rested": 1, "safe": 1, "smile": 1, "progress": 1, "win": 1,
+	"bored": -1, "busy": -1, "confused": -1, "distracted": -1, "meh": -1, "restless": -1, "tired": -1,
+	"unsure": -1, "worried": -1, "late": -1, "messy": -1, "difficult": -1, "hard": -1,
+	"afraid": -2, "angry": -2, "annoyed": -2, "anxious": -2, "bad": -2, "disappointed": -2, "exhausted": -2,
+	"frustrated": -2, "guilty": -2, "hurt": -2, "lonely": -2, "lost": -2, "nervous": -2, "sad": -2,
+	"scared": -2, "sick": -2, "stressed": -2, "upset": -2, "overwhelmed": -2, "cry": -2, "cried": -2,
+	"awful": -3, "depressed": -3, "devastated": -3, "hate": -3, "hated": -3, "hopeless": -3, "miserable": -3,
+	"panic": -3, "terrible": -3, "worst": -3,
+}
+
+func toSet(words ...string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range words {
+		set[word] = true
+	}
+
+	return set
+}
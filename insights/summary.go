@@ -0,0 +1,64 @@
+package insights
+
+import (
+	"github.com/writewithwrabit/server/models"
+)
+
+// How many keywords are shown for a month
+const keywordsPerMonth = 10
+
+// Summarize combines the analyses of a month's entries.
+// Averages are left empty when nothing was written.
+func Summarize(month string, analyses []*Analysis) *models.InsightSummary {
+	summary := &models.InsightSummary{
+		Month:    month,
+		Keywords: []*models.Keyword{},
+	}
+
+	var words []string
+	var sentiment, ease, grade []float64
+	for _, analysis := range analyses {
+		if analysis.Words == 0 {
+			continue
+		}
+
+		summary.EntriesAnalyzed++
+		sentiment = append(sentiment, analysis.Sentiment)
+
+		if analysis.Sentences > 0 {
+			ease = append(ease, analysis.ReadingEase)
+			grade = append(grade, analysis.GradeLevel)
+		}
+
+		// Each entry only keeps its top keywords so those are what get merged
+		for _, keyword := range analysis.Keywords {
+			for i := 0; i < keyword.Count; i++ {
+				words = append(words, keyword.Word)
+			}
+		}
+	}
+
+	for _, keyword := range Keywords(words, keywordsPerMonth) {
+		summary.Keywords = append(summary.Keywords, &models.Keyword{Word: keyword.Word, Count: keyword.Count})
+	}
+
+	summary.AverageSentiment = average(sentiment)
+	summary.AverageReadingEase = average(ease)
+	summary.AverageGradeLevel = average(grade)
+
+	return summary
+}
+
+func average(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+
+	var total float64
+	for _, value := range values {
+		total += value
+	}
+
+	mean := total / float64(len(values))
+	return &mean
+}
@@ -0,0 +1,243 @@
+package keystore
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	cryptopasta "github.com/writewithwrabit/server/cryptopasta"
+	wrabitDB "github.com/writewithwrabit/server/db"
+)
+
+// ErrDestroyed is returned for keys that have been shredded
+var ErrDestroyed = errors.New("encryption key has been destroyed")
+
+// ErrNoKey is returned when the user hasn't been given a key yet
+var ErrNoKey = errors.New("user has no encryption key")
+
+// BackupRetention is the longest backups of the key database may be kept.
+// Destroyed keys are gone from every copy once it has passed.
+const BackupRetention = 7 * 24 * time.Hour
+
+// Store keeps a data key per entry, wrapped with the master key.
+// Keys live in a database of their own, apart from the content they encrypt,
+// whose backups are kept for at most BackupRetention. Destroying a data key
+// makes everything encrypted with it unreadable, in backups of the main
+// database too.
+type Store struct {
+	db     *sql.DB
+	master [32]byte
+}
+
+// New creates a store that keeps data keys in the key database, wrapped with
+// the given master key
+func New(db *sql.DB, masterKey string) *Store {
+	s := &Store{db: db}
+	copy(s.master[:], masterKey)
+
+	return s
+}
+
+// Master is the global key content was encrypted with before per-entry keys
+func (s *Store) Master() *[32]byte {
+	return &s.master
+}
+
+// Create generates a new data key for the user
+func (s *Store) Create(userID string) (string, *[32]byte) {
+	key := cryptopasta.NewEncryptionKey()
+
+	var id string
+	res := wrabitDB.LogAndQueryRow(s.db, "INSERT INTO encryption_keys (user_id, wrapped_key) VALUES ($1, $2) RETURNING id", userID, s.wrap(key))
+	if err := res.Scan(&id); err != nil {
+		panic(err)
+	}
+
+	return id, key
+}
+
+// wrap encrypts a data key with the master key for storing
+func (s *Store) wrap(key *[32]byte) string {
+	wrapped, err := cryptopasta.Encrypt(key[:], &s.master)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(wrapped)
+}
+
+// unwrap decrypts a data key stored by wrap
+func (s *Store) unwrap(wrapped string) (*[32]byte, error) {
+	decoded, err := hex.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	unwrapped, err := cryptopasta.Decrypt(decoded, &s.master)
+	if err != nil {
+		return nil, err
+	}
+
+	key := [32]byte{}
+	copy(key[:], unwrapped)
+
+	return &key, nil
+}
+
+// Get unwraps a data key
+func (s *Store) Get(id string) (*[32]byte, error) {
+	keys, err := s.GetMany([]string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := keys[id]
+	if !ok {
+		return nil, ErrDestroyed
+	}
+
+	return key, nil
+}
+
+// GetMany unwraps several data keys at once. Destroyed keys are left out of the result.
+func (s *Store) GetMany(ids []string) (map[string]*[32]byte, error) {
+	keys := map[string]*[32]byte{}
+	if len(ids) == 0 {
+		return keys, nil
+	}
+
+	res := wrabitDB.LogAndQuery(s.db, "SELECT id, wrapped_key FROM encryption_keys WHERE id = ANY($1::int[]) AND destroyed_at IS NULL", pq.Array(ids))
+	defer res.Close()
+	for res.Next() {
+		var id, wrapped string
+		if err := res.Scan(&id, &wrapped); err != nil {
+			return nil, err
+		}
+
+		key, err := s.unwrap(wrapped)
+		if err != nil {
+			return nil, err
+		}
+
+		keys[id] = key
+	}
+
+	return keys, nil
+}
+
+// Destroy shreds a single data key and records why
+func (s *Store) Destroy(id string, reason string) {
+	res := wrabitDB.LogAndQuery(s.db, "UPDATE encryption_keys SET wrapped_key = NULL, destroyed_at = NOW() WHERE id = $1 AND destroyed_at IS NULL RETURNING id, user_id", id)
+	s.audit(res, reason)
+}
+
+// DestroyMany shreds several data keys at once. Unlike Destroy it returns
+// errors, so the caller can keep whatever the keys encrypted when they fail.
+func (s *Store) DestroyMany(ids []string, reason string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := wrabitDB.LogAndQueryTx(tx, "UPDATE encryption_keys SET wrapped_key = NULL, destroyed_at = NOW() WHERE id = ANY($1::int[]) AND destroyed_at IS NULL RETURNING id, user_id", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	destroyed := map[string]string{}
+	for rows.Next() {
+		var id, userID string
+		if err := rows.Scan(&id, &userID); err != nil {
+			rows.Close()
+			return err
+		}
+
+		destroyed[id] = userID
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		userID, ok := destroyed[id]
+		if !ok {
+			continue
+		}
+
+		if _, err := wrabitDB.LogAndExecTx(tx, "INSERT INTO key_audit (key_id, user_id, action, reason) VALUES ($1, $2, 'destroyed', $3)", id, userID, reason); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DestroyForUser shreds every data key belonging to the user
+func (s *Store) DestroyForUser(userID string, reason string) {
+	res := wrabitDB.LogAndQuery(s.db, "UPDATE encryption_keys SET wrapped_key = NULL, destroyed_at = NOW() WHERE user_id = $1 AND destroyed_at IS NULL RETURNING id, user_id", userID)
+	s.audit(res, reason)
+}
+
+// audit records each destroyed key in the audit trail
+func (s *Store) audit(destroyed *sql.Rows, reason string) {
+	type key struct {
+		id     string
+		userID string
+	}
+
+	var keys []key
+	for destroyed.Next() {
+		var k key
+		if err := destroyed.Scan(&k.id, &k.userID); err != nil {
+			destroyed.Close()
+			panic(err)
+		}
+
+		keys = append(keys, k)
+	}
+	destroyed.Close()
+
+	for _, k := range keys {
+		wrabitDB.LogAndExec(s.db, "INSERT INTO key_audit (key_id, user_id, action, reason) VALUES ($1, $2, 'destroyed', $3)", k.id, k.userID, reason)
+	}
+}
+
+// UserKey returns the key for data that belongs to the user as a whole rather
+// than a single entry, creating it the first time it is needed
+func (s *Store) UserKey(userID string) (*[32]byte, error) {
+	key, err := s.FindUserKey(userID)
+	if err != ErrNoKey {
+		return key, err
+	}
+
+	// Another request may have created the key first, in which case use theirs
+	key = cryptopasta.NewEncryptionKey()
+	var id string
+	res := wrabitDB.LogAndQueryRow(s.db, "INSERT INTO encryption_keys (user_id, wrapped_key, user_key) VALUES ($1, $2, true) ON CONFLICT (user_id) WHERE user_key AND destroyed_at IS NULL DO NOTHING RETURNING id", userID, s.wrap(key))
+	if err := res.Scan(&id); err == sql.ErrNoRows {
+		return s.FindUserKey(userID)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// FindUserKey returns the user's key without creating one, for reads that
+// have nothing to find until the user has stored something with it
+func (s *Store) FindUserKey(userID string) (*[32]byte, error) {
+	var wrapped string
+	res := wrabitDB.LogAndQueryRow(s.db, "SELECT wrapped_key FROM encryption_keys WHERE user_id = $1 AND user_key AND destroyed_at IS NULL", userID)
+	if err := res.Scan(&wrapped); err == sql.ErrNoRows {
+		return nil, ErrNoKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	return s.unwrap(wrapped)
+}
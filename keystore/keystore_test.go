@@ -0,0 +1,117 @@
+package keystore
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// captures an argument so it can be handed back in a later query
+type capture struct {
+	value driver.Value
+}
+
+func (c *capture) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+func TestCreatedKeysUnwrap(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	wrapped := &capture{}
+	mock.ExpectQuery("INSERT INTO encryption_keys \\(user_id, wrapped_key\\) VALUES \\(\\$1, \\$2\\) RETURNING id").
+		WithArgs("abcdefg", wrapped).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("5"))
+
+	store := New(db, "thisencryptsuserdatainthedatabase")
+	id, key := store.Create("abcdefg")
+
+	mock.ExpectQuery("SELECT id, wrapped_key FROM encryption_keys WHERE id = ANY\\(\\$1::int\\[\\]\\) AND destroyed_at IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "wrapped_key"}).AddRow("5", wrapped.value))
+
+	unwrapped, err := store.Get(id)
+
+	assert.Equal(t, "5", id)
+	assert.Nil(t, err)
+	assert.Equal(t, key, unwrapped)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDestroyedKeysAreGone(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, wrapped_key FROM encryption_keys").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "wrapped_key"}))
+
+	_, err = New(db, "").Get("5")
+
+	assert.Equal(t, ErrDestroyed, err)
+}
+
+func TestUserKeyIsCreatedOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := New(db, "thisencryptsuserdatainthedatabase")
+
+	wrapped := &capture{}
+	mock.ExpectQuery("SELECT wrapped_key FROM encryption_keys WHERE user_id = \\$1 AND user_key AND destroyed_at IS NULL").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"wrapped_key"}))
+	mock.ExpectQuery("INSERT INTO encryption_keys \\(user_id, wrapped_key, user_key\\) VALUES \\(\\$1, \\$2, true\\) ON CONFLICT \\(user_id\\) WHERE user_key AND destroyed_at IS NULL DO NOTHING").
+		WithArgs("abcdefg", wrapped).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("9"))
+
+	key, err := store.UserKey("abcdefg")
+	assert.Nil(t, err)
+
+	// Another request creating it at the same time gets the same key back
+	mock.ExpectQuery("SELECT wrapped_key FROM encryption_keys").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"wrapped_key"}))
+	mock.ExpectQuery("INSERT INTO encryption_keys").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("SELECT wrapped_key FROM encryption_keys").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"wrapped_key"}).AddRow(wrapped.value))
+
+	other, err := store.UserKey("abcdefg")
+	assert.Nil(t, err)
+	assert.Equal(t, key, other)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFindUserKeyDoesntCreateOne(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT wrapped_key FROM encryption_keys").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"wrapped_key"}))
+
+	_, err = New(db, "").FindUserKey("abcdefg")
+
+	assert.Equal(t, ErrNoKey, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,33 @@
+package keystore
+
+import (
+	"encoding/hex"
+
+	cryptopasta "github.com/writewithwrabit/server/cryptopasta"
+)
+
+// Seal encrypts a value with a data key for storing as text
+func Seal(plaintext string, key *[32]byte) string {
+	sealed, err := cryptopasta.Encrypt([]byte(plaintext), key)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(sealed)
+}
+
+// Open decrypts a value from Seal, leaving it as is if it can't be decrypted.
+// Entries from before encryption are stored as plaintext and come back unchanged.
+func Open(sealed string, key *[32]byte) string {
+	decoded, err := hex.DecodeString(sealed)
+	if err != nil {
+		return sealed
+	}
+
+	plaintext, err := cryptopasta.Decrypt(decoded, key)
+	if err != nil {
+		return sealed
+	}
+
+	return string(plaintext)
+}
@@ -0,0 +1,34 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/mailgun/mailgun-go/v3"
+)
+
+const (
+	domain   = "mg.writewithwrabit.com"
+	sender   = "Team Wrabit <hello@writewithwrabit.com>"
+	template = "app-template"
+)
+
+// Send emails the recipient using the shared app template.
+// The content is injected into the template as HTML.
+func Send(ctx context.Context, recipient string, subject string, content string) error {
+	// Initialize Mailgun
+	mgKey := os.Getenv("MAILGUN_KEY")
+	mg := mailgun.NewMailgun(domain, mgKey)
+
+	message := mg.NewMessage(sender, subject, "", recipient)
+	message.SetTemplate(template)
+	message.AddTemplateVariable("content", content)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	_, _, err := mg.Send(ctx, message)
+
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -13,13 +14,27 @@ import (
 	_ "github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/dialers/postgres"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/cors"
+	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	_ "github.com/sqreen/go-agent/agent"
 	"github.com/sqreen/go-agent/sdk/middleware/sqhttp"
+	"github.com/writewithwrabit/server/accounts"
+	"github.com/writewithwrabit/server/achievements"
+	"github.com/writewithwrabit/server/admin"
+	"github.com/writewithwrabit/server/api"
 	"github.com/writewithwrabit/server/auth"
+	"github.com/writewithwrabit/server/cron"
 	"github.com/writewithwrabit/server/graph/generated"
+	"github.com/writewithwrabit/server/keystore"
+	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/push"
+	"github.com/writewithwrabit/server/reminders"
 	"github.com/writewithwrabit/server/resolvers"
+	"github.com/writewithwrabit/server/stats"
+	"github.com/writewithwrabit/server/streaks"
+	"github.com/writewithwrabit/server/trash"
+	"github.com/writewithwrabit/server/webhooks"
 	"google.golang.org/api/option"
 )
 
@@ -37,6 +52,11 @@ func main() {
 		log.Println("File .env not found!")
 	}
 
+	// `server admin ...` runs an operational task instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		os.Exit(runAdmin(os.Args[2:]))
+	}
+
 	router := chi.NewRouter()
 
 	// Basic CORS
@@ -58,23 +78,50 @@ func main() {
 		port = defaultPort
 	}
 
-	// Setup Google token verification
-	opt := option.WithCredentialsFile(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
-	app, err := firebase.NewApp(context.Background(), nil, opt)
-	if err != nil {
-		log.Fatalf("error initializing app: %v\n", err)
-	}
+	idTokens, users := authentication(env)
 
-	client, err := app.Auth(context.Background())
-	if err != nil {
-		log.Fatalf("error getting Auth client: %v\n", err)
-	}
+	// Personal API tokens work everywhere ID tokens do
+	verifier := auth.WithAPITokens(db, idTokens)
+	router.Use(auth.Middleware(verifier))
 
-	router.Use(auth.Middleware(client))
+	pushClient := push.New(db)
+	keys := keystore.New(KeysDB(), os.Getenv("ENCRYPTION_KEY"))
 
+	emails := accounts.NewEmailChanges(db, users, accounts.StripeCustomers{}, os.Getenv("EMAIL_LINK_KEY"), os.Getenv("APP_URL"))
+
+	hooks := webhooks.New(db)
+
+	engine := achievements.New(db)
+	engine.Register(achievements.PushNotifier{Client: pushClient})
+	engine.Register(achievements.WebhookNotifier{Webhooks: hooks})
+
+	cfg := resolvers.New(db, pushClient, keys, engine, emails, hooks)
+
+	// Subscriptions are served over a websocket on the same endpoint
 	router.Handle("/query", handler.GraphQL(
-		generated.NewExecutableSchema(resolvers.New(db))),
-	)
+		generated.NewExecutableSchema(cfg),
+		handler.WebsocketUpgrader(websocket.Upgrader{
+			// Origins are already open through CORS and every operation requires a token
+			CheckOrigin: func(r *http.Request) bool { return true },
+		}),
+		handler.WebsocketInitFunc(auth.WebsocketInit(verifier)),
+		handler.ResolverMiddleware(resolvers.RequireScopes),
+	))
+
+	// REST for clients that don't want GraphQL, backed by the same resolvers
+	router.Mount("/api/v1", api.New(cfg.Resolvers.Query(), cfg.Resolvers.Mutation()))
+
+	scheduler := reminders.New(db)
+	scheduler.Register(models.ReminderChannelPush, reminders.PushDeliverer{Client: pushClient})
+
+	// Scheduled jobs triggered by App Engine cron (see cron.yaml)
+	router.Handle("/cron/reminders", cron.Handler("reminders", scheduler.Run))
+	router.Handle("/cron/streak-freezes", cron.Handler("streak-freezes", streaks.FreezeMissed(db)))
+	router.Handle("/cron/streak-warnings", cron.Handler("streak-warnings", reminders.WarnStreaksAtRisk(db, pushClient)))
+	router.Handle("/cron/account-deletions", cron.Handler("account-deletions", accounts.NewEraser(db, users, keys).Run))
+	router.Handle("/cron/stats-rollups", cron.Handler("stats-rollups", stats.Reconcile(db)))
+	router.Handle("/cron/webhook-retries", cron.Handler("webhook-retries", hooks.Retry))
+	router.Handle("/cron/entry-purges", cron.Handler("entry-purges", trash.Purge(db, keys)))
 
 	if env == "dev" {
 		// Only allow the playground in dev
@@ -87,14 +134,83 @@ func main() {
 	}
 }
 
+// runAdmin runs an admin command against the same database and keys as the server
+func runAdmin(args []string) int {
+	db = DB()
+	keys := keystore.New(KeysDB(), os.Getenv("ENCRYPTION_KEY"))
+
+	err := admin.New(db, keys, admin.StripeSubscriptions{}, os.Stdout).Run(context.Background(), args)
+	if err == admin.ErrUsage {
+		admin.Usage(os.Stderr)
+		return 2
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "admin: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// FirebaseUsers is what the server changes about users in Firebase
+type FirebaseUsers interface {
+	accounts.FirebaseUsers
+	accounts.FirebaseEmails
+}
+
+// authentication sets up token verification. In dev AUTH_JWKS can point at a
+// key set from `go run ./cmd/devtoken` to run without Firebase.
+func authentication(env string) (auth.Verifier, FirebaseUsers) {
+	if jwks := os.Getenv("AUTH_JWKS"); jwks != "" && env == "dev" {
+		keys, err := ioutil.ReadFile(jwks)
+		if err != nil {
+			log.Fatalf("error reading local key set: %v\n", err)
+		}
+
+		verifier, err := auth.NewLocalVerifier(keys)
+		if err != nil {
+			log.Fatalf("error loading local key set: %v\n", err)
+		}
+
+		log.Printf("Using local tokens from %s", jwks)
+
+		return verifier, auth.LocalUsers{}
+	}
+
+	// Setup Google token verification
+	opt := option.WithCredentialsFile(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	app, err := firebase.NewApp(context.Background(), nil, opt)
+	if err != nil {
+		log.Fatalf("error initializing app: %v\n", err)
+	}
+
+	client, err := app.Auth(context.Background())
+	if err != nil {
+		log.Fatalf("error getting Auth client: %v\n", err)
+	}
+
+	return client, client
+}
+
 // DB gets a connection to the database.
 // This can panic for malformed database connection strings, invalid credentials, or non-existance database instance.
 func DB() *sql.DB {
+	return openDB("CLOUDSQL")
+}
+
+// KeysDB connects to the database encryption keys are kept in. It is backed up
+// separately from DB and only for keystore.BackupRetention.
+func KeysDB() *sql.DB {
+	return openDB("KEYS_CLOUDSQL")
+}
+
+// openDB connects to the database configured by the environment variables
+// starting with prefix
+func openDB(prefix string) *sql.DB {
 	var (
-		connectionName = mustGetenv("CLOUDSQL_CONNECTION_NAME")
-		user           = mustGetenv("CLOUDSQL_USER")
-		dbName         = os.Getenv("CLOUDSQL_DATABASE_NAME")
-		password       = os.Getenv("CLOUDSQL_PASSWORD")
+		connectionName = mustGetenv(prefix + "_CONNECTION_NAME")
+		user           = mustGetenv(prefix + "_USER")
+		dbName         = os.Getenv(prefix + "_DATABASE_NAME")
+		password       = os.Getenv(prefix + "_PASSWORD")
 		env            = os.Getenv("NODE_ENV")
 	)
 
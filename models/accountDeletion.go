@@ -0,0 +1,10 @@
+package models
+
+type AccountDeletion struct {
+	ID          string  `json:"id"`
+	UserID      string  `json:"userID"`
+	PurgeAfter  string  `json:"purgeAfter"`
+	CompletedAt *string `json:"completedAt"`
+	CreatedAt   string  `json:"createdAt"`
+	UpdatedAt   string  `json:"updatedAt"`
+}
@@ -0,0 +1,11 @@
+package models
+
+type Achievement struct {
+	ID          string          `json:"id"`
+	UserID      string          `json:"userID"`
+	Kind        AchievementKind `json:"kind"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	EntryID     *string         `json:"entryID"`
+	EarnedAt    string          `json:"earnedAt"`
+}
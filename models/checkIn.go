@@ -0,0 +1,13 @@
+package models
+
+type CheckIn struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"userID"`
+	EntryID   *string    `json:"entryID"`
+	Date      string     `json:"date"`
+	Mood      *int       `json:"mood"`
+	Energy    *int       `json:"energy"`
+	Trackers  []*Tracker `json:"trackers"`
+	CreatedAt string     `json:"createdAt"`
+	UpdatedAt string     `json:"updatedAt"`
+}
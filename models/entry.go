@@ -1,11 +1,17 @@
 package models
 
 type Entry struct {
-	ID        string `json:"id"`
-	UserID    string `json:"userId"`
-	WordCount int    `json:"wordCount"`
-	Content   string `json:"content"`
-	GoalHit   bool   `json:"goalHit"`
-	CreatedAt string `json:"createdAt"`
-	UpdatedAt string `json:"updatedAt"`
+	ID              string    `json:"id"`
+	UserID          string    `json:"userId"`
+	Kind            EntryKind `json:"kind"`
+	Title           *string   `json:"title"`
+	WordCount       int       `json:"wordCount"`
+	Content         string    `json:"content"`
+	GoalHit         bool      `json:"goalHit"`
+	Version         int       `json:"version"`
+	ClientEncrypted bool      `json:"clientEncrypted"`
+	KeyID           *string   `json:"-"`
+	CreatedAt       string    `json:"createdAt"`
+	UpdatedAt       string    `json:"updatedAt"`
+	DeletedAt       *string   `json:"deletedAt"`
 }
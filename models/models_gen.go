@@ -2,11 +2,90 @@
 
 package models
 
-type ExistingEntry struct {
-	UserID    string `json:"userID"`
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type APIToken struct {
+	ID         string          `json:"id"`
+	UserID     string          `json:"userID"`
+	Name       string          `json:"name"`
+	Prefix     string          `json:"prefix"`
+	Scopes     []APITokenScope `json:"scopes"`
+	LastUsedAt *string         `json:"lastUsedAt"`
+	ExpiresAt  *string         `json:"expiresAt"`
+	RevokedAt  *string         `json:"revokedAt"`
+	CreatedAt  string          `json:"createdAt"`
+}
+
+type CalendarDay struct {
+	Date      string `json:"date"`
 	WordCount int    `json:"wordCount"`
-	Content   string `json:"content"`
 	GoalHit   bool   `json:"goalHit"`
+	RestDay   bool   `json:"restDay"`
+	Frozen    bool   `json:"frozen"`
+}
+
+type CreatedAPIToken struct {
+	Token    string    `json:"token"`
+	APIToken *APIToken `json:"apiToken"`
+}
+
+type ExistingEntry struct {
+	UserID          string  `json:"userID"`
+	WordCount       int     `json:"wordCount"`
+	Content         string  `json:"content"`
+	GoalHit         bool    `json:"goalHit"`
+	Title           *string `json:"title"`
+	Version         *int    `json:"version"`
+	ClientEncrypted *bool   `json:"clientEncrypted"`
+}
+
+type InsightSummary struct {
+	Month              string     `json:"month"`
+	EntriesAnalyzed    int        `json:"entriesAnalyzed"`
+	Keywords           []*Keyword `json:"keywords"`
+	AverageSentiment   *float64   `json:"averageSentiment"`
+	AverageReadingEase *float64   `json:"averageReadingEase"`
+	AverageGradeLevel  *float64   `json:"averageGradeLevel"`
+}
+
+type Keyword struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+type MoodTrendDay struct {
+	Date         string `json:"date"`
+	Mood         *int   `json:"mood"`
+	Energy       *int   `json:"energy"`
+	WordCount    int    `json:"wordCount"`
+	StreakLength int    `json:"streakLength"`
+}
+
+type MoodTrends struct {
+	AverageMood              *float64        `json:"averageMood"`
+	AverageEnergy            *float64        `json:"averageEnergy"`
+	MoodWordCountCorrelation *float64        `json:"moodWordCountCorrelation"`
+	MoodStreakCorrelation    *float64        `json:"moodStreakCorrelation"`
+	Days                     []*MoodTrendDay `json:"days"`
+}
+
+type NewAPIToken struct {
+	UserID        string          `json:"userID"`
+	Name          string          `json:"name"`
+	Scopes        []APITokenScope `json:"scopes"`
+	ExpiresInDays *int            `json:"expiresInDays"`
+}
+
+type NewCheckIn struct {
+	UserID   string          `json:"userID"`
+	Date     string          `json:"date"`
+	Mood     *int            `json:"mood"`
+	Energy   *int            `json:"energy"`
+	Trackers []*TrackerValue `json:"trackers"`
 }
 
 type NewEditor struct {
@@ -17,9 +96,19 @@ type NewEditor struct {
 }
 
 type NewEntry struct {
-	UserID    string `json:"userId"`
-	WordCount int    `json:"wordCount"`
-	Content   string `json:"content"`
+	UserID          string     `json:"userId"`
+	WordCount       int        `json:"wordCount"`
+	Content         string     `json:"content"`
+	Kind            *EntryKind `json:"kind"`
+	Title           *string    `json:"title"`
+	ClientEncrypted *bool      `json:"clientEncrypted"`
+}
+
+type NewPushSubscription struct {
+	UserID   string `json:"userID"`
+	Endpoint string `json:"endpoint"`
+	P256dh   string `json:"p256dh"`
+	Auth     string `json:"auth"`
 }
 
 type NewSubscription struct {
@@ -29,10 +118,15 @@ type NewSubscription struct {
 	Trial          bool   `json:"trial"`
 }
 
-type NewUser struct {
-	FirstName string  `json:"firstName"`
-	LastName  *string `json:"lastName"`
-	Email     string  `json:"email"`
+type NewWebhook struct {
+	UserID string         `json:"userID"`
+	URL    string         `json:"url"`
+	Events []WebhookEvent `json:"events"`
+}
+
+type PageInfo struct {
+	HasNextPage bool    `json:"hasNextPage"`
+	EndCursor   *string `json:"endCursor"`
 }
 
 type Plan struct {
@@ -46,9 +140,30 @@ type PreferredWritingTime struct {
 	Count int `json:"count"`
 }
 
-type SignedUpUser struct {
-	ID         string `json:"id"`
-	FirebaseID string `json:"firebaseID"`
+type RangeStats struct {
+	From               string         `json:"from"`
+	To                 string         `json:"to"`
+	Granularity        Granularity    `json:"granularity"`
+	Buckets            []*StatsBucket `json:"buckets"`
+	WordsWritten       int            `json:"wordsWritten"`
+	AverageEntryLength float64        `json:"averageEntryLength"`
+	GoalHitRate        float64        `json:"goalHitRate"`
+	ConsistencyScore   float64        `json:"consistencyScore"`
+	VocabularySize     *int           `json:"vocabularySize"`
+	MinutesToGoal      *float64       `json:"minutesToGoal"`
+}
+
+type ReminderSettings struct {
+	Time       *string          `json:"time"`
+	DaysOfWeek []int            `json:"daysOfWeek"`
+	Channel    *ReminderChannel `json:"channel"`
+	Enabled    *bool            `json:"enabled"`
+}
+
+type SignUp struct {
+	FirstName string  `json:"firstName"`
+	LastName  *string `json:"lastName"`
+	Timezone  *string `json:"timezone"`
 }
 
 type Stats struct {
@@ -59,12 +174,386 @@ type Stats struct {
 	PreferredDayOfWeek    int                     `json:"preferredDayOfWeek"`
 }
 
+type StatsBucket struct {
+	Start        string `json:"start"`
+	WordsWritten int    `json:"wordsWritten"`
+	Entries      int    `json:"entries"`
+	GoalsHit     int    `json:"goalsHit"`
+}
+
+type StreakConnection struct {
+	TotalCount int           `json:"totalCount"`
+	Edges      []*StreakEdge `json:"edges"`
+	PageInfo   *PageInfo     `json:"pageInfo"`
+}
+
+type StreakEdge struct {
+	Cursor string  `json:"cursor"`
+	Node   *Streak `json:"node"`
+}
+
+type StreakStatus struct {
+	DayCount         int     `json:"dayCount"`
+	LastGoalHitOn    *string `json:"lastGoalHitOn"`
+	RestDays         []int   `json:"restDays"`
+	FreezesRemaining int     `json:"freezesRemaining"`
+	FreezesNeeded    int     `json:"freezesNeeded"`
+	DaysAtRisk       int     `json:"daysAtRisk"`
+}
+
+type Tracker struct {
+	Name  string `json:"name"`
+	Value int    `json:"value"`
+}
+
+type TrackerValue struct {
+	Name  string `json:"name"`
+	Value int    `json:"value"`
+}
+
 type UpdatedUser struct {
-	ID         string  `json:"id"`
-	FirebaseID *string `json:"firebaseID"`
-	StripeID   *string `json:"stripeID"`
-	FirstName  *string `json:"firstName"`
-	LastName   *string `json:"lastName"`
-	Email      *string `json:"email"`
-	WordGoal   *int    `json:"wordGoal"`
+	ID                     string  `json:"id"`
+	FirstName              *string `json:"firstName"`
+	LastName               *string `json:"lastName"`
+	WordGoal               *int    `json:"wordGoal"`
+	Timezone               *string `json:"timezone"`
+	ClientEncryption       *bool   `json:"clientEncryption"`
+	ClientEncryptionParams *string `json:"clientEncryptionParams"`
+}
+
+type Viewer struct {
+	UserID string          `json:"userID"`
+	Scopes []APITokenScope `json:"scopes"`
+}
+
+type Webhook struct {
+	ID        string         `json:"id"`
+	UserID    string         `json:"userID"`
+	URL       string         `json:"url"`
+	Events    []WebhookEvent `json:"events"`
+	Secret    string         `json:"secret"`
+	CreatedAt string         `json:"createdAt"`
+	UpdatedAt string         `json:"updatedAt"`
+}
+
+type WebhookDelivery struct {
+	ID            string  `json:"id"`
+	WebhookID     string  `json:"webhookID"`
+	Event         string  `json:"event"`
+	Payload       string  `json:"payload"`
+	Attempts      int     `json:"attempts"`
+	StatusCode    *int    `json:"statusCode"`
+	Error         *string `json:"error"`
+	DeliveredAt   *string `json:"deliveredAt"`
+	NextAttemptAt *string `json:"nextAttemptAt"`
+	CreatedAt     string  `json:"createdAt"`
+}
+
+type APITokenScope string
+
+const (
+	APITokenScopeEntriesRead  APITokenScope = "ENTRIES_READ"
+	APITokenScopeEntriesWrite APITokenScope = "ENTRIES_WRITE"
+	APITokenScopeStatsRead    APITokenScope = "STATS_READ"
+)
+
+var AllAPITokenScope = []APITokenScope{
+	APITokenScopeEntriesRead,
+	APITokenScopeEntriesWrite,
+	APITokenScopeStatsRead,
+}
+
+func (e APITokenScope) IsValid() bool {
+	switch e {
+	case APITokenScopeEntriesRead, APITokenScopeEntriesWrite, APITokenScopeStatsRead:
+		return true
+	}
+	return false
+}
+
+func (e APITokenScope) String() string {
+	return string(e)
+}
+
+func (e *APITokenScope) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = APITokenScope(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid APITokenScope", str)
+	}
+	return nil
+}
+
+func (e APITokenScope) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type AchievementKind string
+
+const (
+	AchievementKindFirstEntry           AchievementKind = "FIRST_ENTRY"
+	AchievementKindThirtyDayStreak      AchievementKind = "THIRTY_DAY_STREAK"
+	AchievementKindHundredThousandWords AchievementKind = "HUNDRED_THOUSAND_WORDS"
+	AchievementKindLongestEntry         AchievementKind = "LONGEST_ENTRY"
+	AchievementKindEarlyBird            AchievementKind = "EARLY_BIRD"
+)
+
+var AllAchievementKind = []AchievementKind{
+	AchievementKindFirstEntry,
+	AchievementKindThirtyDayStreak,
+	AchievementKindHundredThousandWords,
+	AchievementKindLongestEntry,
+	AchievementKindEarlyBird,
+}
+
+func (e AchievementKind) IsValid() bool {
+	switch e {
+	case AchievementKindFirstEntry, AchievementKindThirtyDayStreak, AchievementKindHundredThousandWords, AchievementKindLongestEntry, AchievementKindEarlyBird:
+		return true
+	}
+	return false
+}
+
+func (e AchievementKind) String() string {
+	return string(e)
+}
+
+func (e *AchievementKind) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = AchievementKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid AchievementKind", str)
+	}
+	return nil
+}
+
+func (e AchievementKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type EntryKind string
+
+const (
+	EntryKindDaily  EntryKind = "DAILY"
+	EntryKindNote   EntryKind = "NOTE"
+	EntryKindDraft  EntryKind = "DRAFT"
+	EntryKindLetter EntryKind = "LETTER"
+)
+
+var AllEntryKind = []EntryKind{
+	EntryKindDaily,
+	EntryKindNote,
+	EntryKindDraft,
+	EntryKindLetter,
+}
+
+func (e EntryKind) IsValid() bool {
+	switch e {
+	case EntryKindDaily, EntryKindNote, EntryKindDraft, EntryKindLetter:
+		return true
+	}
+	return false
+}
+
+func (e EntryKind) String() string {
+	return string(e)
+}
+
+func (e *EntryKind) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = EntryKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid EntryKind", str)
+	}
+	return nil
+}
+
+func (e EntryKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type Granularity string
+
+const (
+	GranularityDay   Granularity = "DAY"
+	GranularityWeek  Granularity = "WEEK"
+	GranularityMonth Granularity = "MONTH"
+)
+
+var AllGranularity = []Granularity{
+	GranularityDay,
+	GranularityWeek,
+	GranularityMonth,
+}
+
+func (e Granularity) IsValid() bool {
+	switch e {
+	case GranularityDay, GranularityWeek, GranularityMonth:
+		return true
+	}
+	return false
+}
+
+func (e Granularity) String() string {
+	return string(e)
+}
+
+func (e *Granularity) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Granularity(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Granularity", str)
+	}
+	return nil
+}
+
+func (e Granularity) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type ReminderChannel string
+
+const (
+	ReminderChannelEmail ReminderChannel = "EMAIL"
+	ReminderChannelPush  ReminderChannel = "PUSH"
+)
+
+var AllReminderChannel = []ReminderChannel{
+	ReminderChannelEmail,
+	ReminderChannelPush,
+}
+
+func (e ReminderChannel) IsValid() bool {
+	switch e {
+	case ReminderChannelEmail, ReminderChannelPush:
+		return true
+	}
+	return false
+}
+
+func (e ReminderChannel) String() string {
+	return string(e)
+}
+
+func (e *ReminderChannel) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ReminderChannel(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ReminderChannel", str)
+	}
+	return nil
+}
+
+func (e ReminderChannel) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type TrendRange string
+
+const (
+	TrendRangeWeek    TrendRange = "WEEK"
+	TrendRangeMonth   TrendRange = "MONTH"
+	TrendRangeQuarter TrendRange = "QUARTER"
+	TrendRangeYear    TrendRange = "YEAR"
+)
+
+var AllTrendRange = []TrendRange{
+	TrendRangeWeek,
+	TrendRangeMonth,
+	TrendRangeQuarter,
+	TrendRangeYear,
+}
+
+func (e TrendRange) IsValid() bool {
+	switch e {
+	case TrendRangeWeek, TrendRangeMonth, TrendRangeQuarter, TrendRangeYear:
+		return true
+	}
+	return false
+}
+
+func (e TrendRange) String() string {
+	return string(e)
+}
+
+func (e *TrendRange) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = TrendRange(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid TrendRange", str)
+	}
+	return nil
+}
+
+func (e TrendRange) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type WebhookEvent string
+
+const (
+	WebhookEventEntryGoalHit      WebhookEvent = "ENTRY_GOAL_HIT"
+	WebhookEventStreakExtended    WebhookEvent = "STREAK_EXTENDED"
+	WebhookEventStreakBroken      WebhookEvent = "STREAK_BROKEN"
+	WebhookEventAchievementEarned WebhookEvent = "ACHIEVEMENT_EARNED"
+)
+
+var AllWebhookEvent = []WebhookEvent{
+	WebhookEventEntryGoalHit,
+	WebhookEventStreakExtended,
+	WebhookEventStreakBroken,
+	WebhookEventAchievementEarned,
+}
+
+func (e WebhookEvent) IsValid() bool {
+	switch e {
+	case WebhookEventEntryGoalHit, WebhookEventStreakExtended, WebhookEventStreakBroken, WebhookEventAchievementEarned:
+		return true
+	}
+	return false
+}
+
+func (e WebhookEvent) String() string {
+	return string(e)
+}
+
+func (e *WebhookEvent) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = WebhookEvent(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid WebhookEvent", str)
+	}
+	return nil
+}
+
+func (e WebhookEvent) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
 }
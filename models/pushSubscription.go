@@ -0,0 +1,11 @@
+package models
+
+type PushSubscription struct {
+	ID        string `json:"id"`
+	UserID    string `json:"userID"`
+	Endpoint  string `json:"endpoint"`
+	P256dh    string `json:"p256dh"`
+	Auth      string `json:"auth"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
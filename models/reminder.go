@@ -0,0 +1,13 @@
+package models
+
+type Reminder struct {
+	ID         string          `json:"id"`
+	UserID     string          `json:"userID"`
+	Time       string          `json:"time"`
+	DaysOfWeek []int           `json:"daysOfWeek"`
+	Channel    ReminderChannel `json:"channel"`
+	Enabled    bool            `json:"enabled"`
+	LastSentOn *string         `json:"lastSentOn"`
+	CreatedAt  string          `json:"createdAt"`
+	UpdatedAt  string          `json:"updatedAt"`
+}
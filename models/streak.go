@@ -5,6 +5,8 @@ type Streak struct {
 	UserID      string `json:"userId"`
 	DayCount    int    `json:"dayCount"`
 	LastEntryID string `json:"lastEntryId"`
+	StartDate   string `json:"startDate"`
+	EndDate     string `json:"endDate"`
 	CreatedAt   string `json:"createdAt"`
 	UpdatedAt   string `json:"updatedAt"`
 }
@@ -0,0 +1,10 @@
+package models
+
+type Tag struct {
+	ID         string `json:"id"`
+	UserID     string `json:"userID"`
+	Name       string `json:"name"`
+	EntryCount int    `json:"entryCount"`
+	CreatedAt  string `json:"createdAt"`
+	UpdatedAt  string `json:"updatedAt"`
+}
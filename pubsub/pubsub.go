@@ -0,0 +1,66 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+
+	"github.com/writewithwrabit/server/models"
+)
+
+// Broker fans out entry updates to subscribers watching that entry.
+// Updates only reach subscribers connected to the same server instance.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan *models.Entry]bool
+}
+
+func New() *Broker {
+	return &Broker{
+		subscribers: map[string]map[chan *models.Entry]bool{},
+	}
+}
+
+// Subscribe returns a channel of updates to the entry.
+// The channel is closed once the context is done.
+func (b *Broker) Subscribe(ctx context.Context, entryID string) <-chan *models.Entry {
+	updates := make(chan *models.Entry, 1)
+
+	b.mu.Lock()
+	if b.subscribers[entryID] == nil {
+		b.subscribers[entryID] = map[chan *models.Entry]bool{}
+	}
+	b.subscribers[entryID][updates] = true
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.mu.Lock()
+		delete(b.subscribers[entryID], updates)
+		if len(b.subscribers[entryID]) == 0 {
+			delete(b.subscribers, entryID)
+		}
+		close(updates)
+		b.mu.Unlock()
+	}()
+
+	return updates
+}
+
+// Publish sends the entry to everyone subscribed to it.
+// Slow subscribers miss updates rather than blocking the writer.
+func (b *Broker) Publish(entry *models.Entry) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for updates := range b.subscribers[entry.ID] {
+		select {
+		case updates <- entry:
+		default:
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/writewithwrabit/server/models"
+)
+
+func TestPublishReachesSubscribers(t *testing.T) {
+	broker := New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := broker.Subscribe(ctx, "1")
+	other := broker.Subscribe(ctx, "2")
+
+	broker.Publish(&models.Entry{ID: "1", Version: 2})
+
+	entry := <-updates
+	assert.Equal(t, 2, entry.Version)
+	assert.Empty(t, other)
+
+	cancel()
+	_, open := <-updates
+	assert.False(t, open)
+}
@@ -0,0 +1,95 @@
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// Records are sent as a single aes128gcm record so the size just needs
+// to fit the payload. 4096 is what browsers are guaranteed to accept.
+const recordSize = 4096
+
+// encrypt encrypts a payload for a push subscription as described in RFC 8291
+// using the aes128gcm content coding from RFC 8188. The ephemeral server key
+// and salt are passed in so they can be fixed in tests.
+// Output takes the form salt|rs|idlen|keyid|ciphertext where '|' indicates
+// concatenation and keyid is the server's public key.
+func encrypt(plaintext []byte, uaPublic []byte, authSecret []byte, asPrivate []byte, salt []byte) ([]byte, error) {
+	curve := elliptic.P256()
+
+	uaX, uaY := elliptic.Unmarshal(curve, uaPublic)
+	if uaX == nil {
+		return nil, errors.New("invalid subscription public key")
+	}
+
+	if len(salt) != 16 {
+		return nil, errors.New("salt must be 16 bytes")
+	}
+
+	asX, asY := curve.ScalarBaseMult(asPrivate)
+	asPublic := elliptic.Marshal(curve, asX, asY)
+
+	sharedX, _ := curve.ScalarMult(uaX, uaY, asPrivate)
+	ecdhSecret := padded(sharedX.Bytes(), 32)
+
+	// Combine the ECDH secret with the subscription's auth secret
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+
+	// Derive the content encryption key and nonce
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single record is terminated by the 0x02 delimiter
+	record := append(append([]byte{}, plaintext...), 0x02)
+	if len(record)+gcm.Overhead() > recordSize {
+		return nil, errors.New("payload too large")
+	}
+
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = append(header, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[16:20], recordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+// hkdf is HKDF-SHA-256 (RFC 5869) limited to a single block of output,
+// which is all Web Push needs.
+func hkdf(salt []byte, ikm []byte, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+
+	expand := hmac.New(sha256.New, prk)
+	expand.Write(info)
+	expand.Write([]byte{0x01})
+
+	return expand.Sum(nil)[:length]
+}
+
+// padded left pads big endian integer bytes to a fixed size
+func padded(b []byte, size int) []byte {
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+
+	return out
+}
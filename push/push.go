@@ -0,0 +1,184 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/elliptic"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/webhooks"
+)
+
+// How long push services should hold a message for an offline browser
+const ttl = 24 * time.Hour
+
+// ErrNotConfigured is returned when no VAPID key has been set
+var ErrNotConfigured = errors.New("push notifications are not configured")
+
+// ErrGone means the push service no longer knows the subscription
+var ErrGone = errors.New("push subscription has expired")
+
+// Message is the payload the service worker receives
+type Message struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url,omitempty"`
+}
+
+// Subscription is a browser's push endpoint and its encryption keys
+type Subscription struct {
+	ID       string
+	Endpoint string
+	P256dh   string
+	Auth     string
+}
+
+// Client sends push messages to every browser a user has subscribed
+type Client struct {
+	db         *sql.DB
+	vapid      *VAPID
+	httpClient *http.Client
+}
+
+// New creates a client using the VAPID_PRIVATE_KEY environment variable.
+// Without a key the client still works but every send returns ErrNotConfigured.
+// Endpoints come from browsers so messages are only sent to public addresses.
+func New(db *sql.DB) *Client {
+	client := &Client{
+		db:         db,
+		httpClient: webhooks.NewPublicClient(),
+	}
+
+	if privateKey := os.Getenv("VAPID_PRIVATE_KEY"); privateKey != "" {
+		vapid, err := NewVAPID(privateKey)
+		if err != nil {
+			log.Printf("invalid VAPID_PRIVATE_KEY: %v", err)
+		} else {
+			client.vapid = vapid
+		}
+	}
+
+	return client
+}
+
+// PublicKey is the application server key browsers subscribe with
+func (c *Client) PublicKey() string {
+	if c == nil || c.vapid == nil {
+		return ""
+	}
+
+	return c.vapid.PublicKey()
+}
+
+// Notify sends a message to all of a user's subscriptions.
+// Subscriptions the push service reports as gone are removed.
+func (c *Client) Notify(ctx context.Context, userID string, message Message) error {
+	if c == nil || c.vapid == nil {
+		return ErrNotConfigured
+	}
+
+	res := wrabitDB.LogAndQuery(c.db, "SELECT id, endpoint, p256dh, auth FROM push_subscriptions WHERE user_id = $1", userID)
+
+	var subscriptions []*Subscription
+	for res.Next() {
+		var subscription = new(Subscription)
+		if err := res.Scan(&subscription.ID, &subscription.Endpoint, &subscription.P256dh, &subscription.Auth); err != nil {
+			res.Close()
+			return err
+		}
+
+		subscriptions = append(subscriptions, subscription)
+	}
+	res.Close()
+
+	var lastErr error
+	for _, subscription := range subscriptions {
+		err := c.Send(ctx, subscription, message)
+		if err == ErrGone {
+			wrabitDB.LogAndExec(c.db, "DELETE FROM push_subscriptions WHERE id = $1", subscription.ID)
+		} else if err != nil {
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+// Send encrypts and delivers a message to a single subscription
+func (c *Client) Send(ctx context.Context, subscription *Subscription, message Message) error {
+	if c == nil || c.vapid == nil {
+		return ErrNotConfigured
+	}
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	uaPublic, err := decodeKey(subscription.P256dh)
+	if err != nil {
+		return err
+	}
+
+	authSecret, err := decodeKey(subscription.Auth)
+	if err != nil {
+		return err
+	}
+
+	// Every message gets a fresh key pair and salt
+	asPrivate, _, _, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+
+	body, err := encrypt(payload, uaPublic, authSecret, asPrivate, salt)
+	if err != nil {
+		return err
+	}
+
+	authorization, err := c.vapid.Authorization(subscription.Endpoint, time.Now())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", fmt.Sprintf("%d", int(ttl.Seconds())))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return ErrGone
+	}
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("push service responded with %s", resp.Status)
+	}
+
+	return nil
+}
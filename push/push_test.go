@@ -0,0 +1,119 @@
+package push
+
+import (
+	"context"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decode(t *testing.T, s string) []byte {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// Example from RFC 8291 section 5
+func TestEncrypt(t *testing.T) {
+	plaintext := []byte("When I grow up, I want to be a watermelon")
+	asPrivate := decode(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw")
+	uaPublic := decode(t, "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4")
+	authSecret := decode(t, "BTBZMqHH6r4Tts7J_aSIgg")
+	salt := decode(t, "DGv6ra1nlYgDCS1FRnbzlw")
+
+	body, err := encrypt(plaintext, uaPublic, authSecret, asPrivate, salt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN", base64.RawURLEncoding.EncodeToString(body))
+}
+
+func newTestClient(t *testing.T) *Client {
+	d, _, _, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vapid, err := NewVAPID(base64.RawURLEncoding.EncodeToString(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Client{vapid: vapid, httpClient: http.DefaultClient}
+}
+
+func newTestSubscription(t *testing.T, endpoint string) *Subscription {
+	curve := elliptic.P256()
+	_, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Subscription{
+		Endpoint: endpoint,
+		P256dh:   base64.RawURLEncoding.EncodeToString(elliptic.Marshal(curve, x, y)),
+		Auth:     "BTBZMqHH6r4Tts7J_aSIgg",
+	}
+}
+
+func TestSend(t *testing.T) {
+	client := newTestClient(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "aes128gcm", r.Header.Get("Content-Encoding"))
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "vapid t="))
+		assert.True(t, strings.HasSuffix(r.Header.Get("Authorization"), ", k="+client.vapid.PublicKey()))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	err := client.Send(context.Background(), newTestSubscription(t, server.URL), Message{Title: "Hi"})
+
+	assert.Nil(t, err)
+}
+
+func TestSendToExpiredSubscription(t *testing.T) {
+	client := newTestClient(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer server.Close()
+
+	err := client.Send(context.Background(), newTestSubscription(t, server.URL), Message{Title: "Hi"})
+
+	assert.Equal(t, ErrGone, err)
+}
+
+func TestSendIsOnlyDialedToPublicAddresses(t *testing.T) {
+	client := newTestClient(t)
+	client.httpClient = New(nil).httpClient
+
+	var received bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	err := client.Send(context.Background(), newTestSubscription(t, server.URL), Message{Title: "Hi"})
+
+	assert.NotNil(t, err)
+	assert.False(t, received)
+}
+
+func TestSendWithoutVAPID(t *testing.T) {
+	var client *Client
+
+	err := client.Send(context.Background(), &Subscription{}, Message{})
+
+	assert.Equal(t, ErrNotConfigured, err)
+}
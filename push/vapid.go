@@ -0,0 +1,86 @@
+package push
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+)
+
+// Contact for push services in case our messages cause problems
+const subject = "mailto:hello@writewithwrabit.com"
+
+// VAPID identifies the application server to push services (RFC 8292)
+type VAPID struct {
+	key *ecdsa.PrivateKey
+}
+
+// NewVAPID loads a VAPID key pair from the base64url encoded private key.
+// This is the same format the web-push tooling generates.
+func NewVAPID(privateKey string) (*VAPID, error) {
+	d, err := decodeKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(d) != 32 {
+		return nil, errors.New("VAPID private key must be 32 bytes")
+	}
+
+	curve := elliptic.P256()
+	key := new(ecdsa.PrivateKey)
+	key.Curve = curve
+	key.D = new(big.Int).SetBytes(d)
+	key.X, key.Y = curve.ScalarBaseMult(d)
+
+	return &VAPID{key: key}, nil
+}
+
+// PublicKey is the base64url encoded application server key
+// browsers need when subscribing
+func (v *VAPID) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(elliptic.Marshal(v.key.Curve, v.key.X, v.key.Y))
+}
+
+// Authorization builds the Authorization header for a push endpoint
+func (v *VAPID) Authorization(endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(12 * time.Hour).Unix(),
+		"sub": subject,
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+
+	r, s, err := ecdsa.Sign(rand.Reader, v.key, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	signature := append(padded(r.Bytes(), 32), padded(s.Bytes(), 32)...)
+	token := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	return fmt.Sprintf("vapid t=%s, k=%s", token, v.PublicKey()), nil
+}
+
+// decodeKey accepts base64url keys with or without padding
+func decodeKey(key string) ([]byte, error) {
+	for len(key)%4 != 0 {
+		key += "="
+	}
+
+	return base64.URLEncoding.DecodeString(key)
+}
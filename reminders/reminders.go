@@ -0,0 +1,289 @@
+package reminders
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/mail"
+	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/push"
+	"github.com/writewithwrabit/server/streaks"
+)
+
+// DefaultTime is used when a user has no writing history to pick a time from
+const DefaultTime = "19:00"
+
+// Local hour streak warnings are sent at
+const warningHour = 20
+
+// How long after the reminder time we still consider it due.
+// Cron runs more often than this so a single missed run doesn't drop a reminder.
+const window = time.Hour
+
+// Recipient is a user with a reminder that is due
+type Recipient struct {
+	UserID    string
+	FirstName string
+	Email     string
+}
+
+// Deliverer sends a reminder over a single channel
+type Deliverer interface {
+	Deliver(ctx context.Context, recipient Recipient) error
+}
+
+// Scheduler finds due reminders and hands them to the deliverer for their channel
+type Scheduler struct {
+	db         *sql.DB
+	deliverers map[models.ReminderChannel]Deliverer
+}
+
+// New creates a scheduler that delivers email reminders.
+// Other channels can be added with Register.
+func New(db *sql.DB) *Scheduler {
+	s := &Scheduler{
+		db:         db,
+		deliverers: map[models.ReminderChannel]Deliverer{},
+	}
+
+	s.Register(models.ReminderChannelEmail, EmailDeliverer{})
+
+	return s
+}
+
+// Register sets the deliverer used for a channel
+func (s *Scheduler) Register(channel models.ReminderChannel, deliverer Deliverer) {
+	s.deliverers[channel] = deliverer
+}
+
+type dueReminder struct {
+	id         string
+	recipient  Recipient
+	time       string
+	daysOfWeek []int64
+	channel    models.ReminderChannel
+	timezone   string
+	lastSentOn *string
+}
+
+// Run sends every reminder that is due at the given time.
+// Users that already hit their goal today are skipped.
+func (s *Scheduler) Run(ctx context.Context, now time.Time) error {
+	res := wrabitDB.LogAndQuery(s.db, "SELECT r.id, r.user_id, r.time, r.days_of_week, r.channel, to_char(r.last_sent_on, 'YYYY-MM-DD'), u.first_name, u.email, u.timezone FROM reminders r JOIN users u ON u.firebase_id = r.user_id WHERE r.enabled = true")
+
+	var reminders []*dueReminder
+	for res.Next() {
+		var reminder = new(dueReminder)
+		if err := res.Scan(&reminder.id, &reminder.recipient.UserID, &reminder.time, pq.Array(&reminder.daysOfWeek), &reminder.channel, &reminder.lastSentOn, &reminder.recipient.FirstName, &reminder.recipient.Email, &reminder.timezone); err != nil {
+			res.Close()
+			return err
+		}
+
+		reminders = append(reminders, reminder)
+	}
+	res.Close()
+
+	for _, reminder := range reminders {
+		local := now.In(Location(reminder.timezone))
+		if !isDue(reminder, local) {
+			continue
+		}
+
+		hit, err := GoalHitToday(s.db, reminder.recipient.UserID, local)
+		if err != nil {
+			log.Printf("failed to check goal for reminder %s: %v", reminder.id, err)
+			continue
+		} else if hit {
+			continue
+		}
+
+		deliverer, ok := s.deliverers[reminder.channel]
+		if !ok {
+			log.Printf("no deliverer registered for %s reminders", reminder.channel)
+			continue
+		}
+
+		// Claim the reminder for today so overlapping runs don't send it twice
+		today := local.Format("2006-01-02")
+		claim := wrabitDB.LogAndQueryRow(s.db, "UPDATE reminders SET last_sent_on = $1 WHERE id = $2 AND (last_sent_on IS NULL OR last_sent_on < $1) RETURNING id", today, reminder.id)
+		if err := claim.Scan(&reminder.id); err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if err := deliverer.Deliver(ctx, reminder.recipient); err != nil {
+			log.Printf("failed to deliver reminder %s: %v", reminder.id, err)
+
+			// Hand the claim back so a later run inside the window tries again
+			wrabitDB.LogAndExec(s.db, "UPDATE reminders SET last_sent_on = $1 WHERE id = $2 AND last_sent_on = $3", reminder.lastSentOn, reminder.id, today)
+		}
+	}
+
+	return nil
+}
+
+func isDue(reminder *dueReminder, local time.Time) bool {
+	onDay := false
+	for _, day := range reminder.daysOfWeek {
+		if time.Weekday(day) == local.Weekday() {
+			onDay = true
+		}
+	}
+	if !onDay {
+		return false
+	}
+
+	at, err := time.Parse("15:04", reminder.time)
+	if err != nil {
+		return false
+	}
+
+	remindAt := time.Date(local.Year(), local.Month(), local.Day(), at.Hour(), at.Minute(), 0, 0, local.Location())
+	since := local.Sub(remindAt)
+
+	return since >= 0 && since < window
+}
+
+// Location loads a user's timezone, falling back to UTC for unknown zones
+func Location(timezone string) *time.Location {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// GoalHitToday checks if the user hit their goal with a daily entry on the
+// local day of the given time. Notes don't count toward goals.
+func GoalHitToday(db *sql.DB, userID string, local time.Time) (bool, error) {
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	end := start.AddDate(0, 0, 1)
+
+	var count int
+	res := wrabitDB.LogAndQueryRow(db, "SELECT count(*) FROM entries WHERE user_id = $1 AND kind = 'DAILY' AND goal_hit = true AND deleted_at IS NULL AND created_at >= $2 AND created_at < $3", userID, start.UTC(), end.UTC())
+	if err := res.Scan(&count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// PreferredTime is the hour the user writes at most often in their timezone
+func PreferredTime(db *sql.DB, userID string, timezone string) string {
+	var hour int
+	res := wrabitDB.LogAndQueryRow(db, "SELECT date_part('hour', updated_at AT TIME ZONE $2) as hour FROM entries WHERE user_id = $1 AND word_count > 0 AND deleted_at IS NULL GROUP BY 1 ORDER BY count(*) DESC LIMIT 1", userID, timezone)
+	err := res.Scan(&hour)
+	if err == sql.ErrNoRows {
+		return DefaultTime
+	} else if err != nil {
+		panic(err)
+	}
+
+	return fmt.Sprintf("%02d:00", hour)
+}
+
+// EmailDeliverer sends reminders through Mailgun
+type EmailDeliverer struct{}
+
+func (EmailDeliverer) Deliver(ctx context.Context, recipient Recipient) error {
+	content := fmt.Sprintf(`Hey %s! 👋<br><br>
+
+  You haven't hit your writing goal yet today. A few words are all it takes to keep your habit going.<br><br>
+
+  Be well,<br>
+  Team Wrabit 🐇
+  `, recipient.FirstName)
+
+	return mail.Send(ctx, recipient.Email, "Time to write!", content)
+}
+
+// PushDeliverer sends reminders to the user's subscribed browsers
+type PushDeliverer struct {
+	Client *push.Client
+}
+
+func (d PushDeliverer) Deliver(ctx context.Context, recipient Recipient) error {
+	return d.Client.Notify(ctx, recipient.UserID, push.Message{
+		Title: "Time to write!",
+		Body:  "You haven't hit your writing goal yet today.",
+		URL:   "/",
+	})
+}
+
+// WarnStreaksAtRisk pushes a warning in the evening to users whose streak
+// will be lost if they don't hit their goal today.
+// It is meant to run hourly so each timezone is warned once.
+func WarnStreaksAtRisk(db *sql.DB, client *push.Client) func(ctx context.Context, now time.Time) error {
+	return func(ctx context.Context, now time.Time) error {
+		// Rest days and freezes can keep a streak going for a while after it was last extended
+		since := now.AddDate(0, 0, -(streaks.MaxFreezes + streaks.MaxRestDays + 2))
+		res := wrabitDB.LogAndQuery(db, "SELECT DISTINCT ON (s.user_id) s.user_id, s.day_count, s.updated_at, (SELECT max(f.day) FROM frozen_days f WHERE f.streak_id = s.id), u.timezone, u.rest_days, u.streak_freezes FROM streaks s JOIN users u ON u.firebase_id = s.user_id WHERE s.updated_at >= $1 AND EXISTS (SELECT 1 FROM push_subscriptions p WHERE p.user_id = s.user_id) ORDER BY s.user_id, s.updated_at DESC", since)
+
+		type atRisk struct {
+			userID    string
+			dayCount  int
+			updatedAt time.Time
+			// lastFrozen is the last day a freeze was spent on for the streak
+			lastFrozen sql.NullTime
+			timezone   string
+			restDays   []int
+			freezes    int
+		}
+
+		var candidates []*atRisk
+		for res.Next() {
+			var streak = new(atRisk)
+			var restDays []int64
+			if err := res.Scan(&streak.userID, &streak.dayCount, &streak.updatedAt, &streak.lastFrozen, &streak.timezone, pq.Array(&restDays), &streak.freezes); err != nil {
+				res.Close()
+				return err
+			}
+
+			for _, day := range restDays {
+				streak.restDays = append(streak.restDays, int(day))
+			}
+
+			candidates = append(candidates, streak)
+		}
+		res.Close()
+
+		for _, streak := range candidates {
+			local := now.In(Location(streak.timezone))
+			if local.Hour() != warningHour {
+				continue
+			}
+
+			last := streaks.Covered(streaks.Day(streak.updatedAt, local.Location()), streak.lastFrozen)
+			today := streaks.Day(local, local.Location())
+			if !streaks.AtRisk(last, today, streak.restDays, streak.freezes) {
+				continue
+			}
+
+			hit, err := GoalHitToday(db, streak.userID, local)
+			if err != nil {
+				log.Printf("failed to check goal for %s: %v", streak.userID, err)
+				continue
+			} else if hit {
+				continue
+			}
+
+			err = client.Notify(ctx, streak.userID, push.Message{
+				Title: "Your streak is at risk!",
+				Body:  fmt.Sprintf("Write today to keep your %d day streak going.", streak.dayCount),
+				URL:   "/",
+			})
+			if err != nil {
+				log.Printf("failed to warn %s about their streak: %v", streak.userID, err)
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,86 @@
+package reminders
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/writewithwrabit/server/models"
+)
+
+func TestIsDue(t *testing.T) {
+	reminder := &dueReminder{
+		time:       "19:00",
+		daysOfWeek: []int64{1, 3, 5},
+	}
+
+	loc := Location("America/Toronto")
+
+	// Monday
+	assert.True(t, isDue(reminder, time.Date(2020, 3, 2, 19, 0, 0, 0, loc)))
+	assert.True(t, isDue(reminder, time.Date(2020, 3, 2, 19, 45, 0, 0, loc)))
+	assert.False(t, isDue(reminder, time.Date(2020, 3, 2, 18, 59, 0, 0, loc)))
+	assert.False(t, isDue(reminder, time.Date(2020, 3, 2, 20, 0, 0, 0, loc)))
+
+	// Tuesday
+	assert.False(t, isDue(reminder, time.Date(2020, 3, 3, 19, 0, 0, 0, loc)))
+}
+
+func TestLocationFallsBackToUTC(t *testing.T) {
+	assert.Equal(t, time.UTC, Location("Not/AZone"))
+	assert.Equal(t, "America/Toronto", Location("America/Toronto").String())
+}
+
+type failingDeliverer struct {
+	sent []Recipient
+}
+
+func (d *failingDeliverer) Deliver(ctx context.Context, recipient Recipient) error {
+	d.sent = append(d.sent, recipient)
+	return errors.New("mailgun is down")
+}
+
+func TestRunReleasesUndeliveredReminders(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	deliverer := &failingDeliverer{}
+	scheduler := New(db)
+	scheduler.Register(models.ReminderChannelEmail, deliverer)
+
+	// Monday at 19:15 in UTC
+	now := time.Date(2020, 3, 2, 19, 15, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT r.id, r.user_id, r.time, r.days_of_week, r.channel, to_char\\(r.last_sent_on, 'YYYY-MM-DD'\\), u.first_name, u.email, u.timezone FROM reminders r").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "time", "days_of_week", "channel", "last_sent_on", "first_name", "email", "timezone"}).
+			AddRow("1", "broken", "19:00", "{1}", "EMAIL", nil, "Ann", "ann@example.com", "UTC").
+			AddRow("2", "abcdefg", "19:00", "{1}", "EMAIL", "2020-02-28", "Bea", "bea@example.com", "UTC"))
+
+	// A user whose goal can't be checked doesn't stop the others
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM entries").
+		WithArgs("broken", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnError(errors.New("connection reset"))
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM entries WHERE user_id \\= \\$1 AND kind \\= 'DAILY' AND goal_hit \\= true").
+		WithArgs("abcdefg", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("UPDATE reminders SET last_sent_on \\= \\$1 WHERE id \\= \\$2 AND \\(last_sent_on IS NULL OR last_sent_on < \\$1\\) RETURNING id").
+		WithArgs("2020-03-02", "2").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("2"))
+
+	// The failed send puts back the day it was last sent
+	mock.ExpectExec("UPDATE reminders SET last_sent_on \\= \\$1 WHERE id \\= \\$2 AND last_sent_on \\= \\$3").
+		WithArgs("2020-02-28", "2", "2020-03-02").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = scheduler.Run(context.Background(), now)
+
+	assert.Nil(t, err)
+	assert.Len(t, deliverer.sent, 1)
+	assert.Equal(t, "abcdefg", deliverer.sent[0].UserID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,153 @@
+package resolvers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/writewithwrabit/server/accounts"
+	"github.com/writewithwrabit/server/auth"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/mail"
+	"github.com/writewithwrabit/server/models"
+)
+
+// SignUp sets up the account for the Firebase user making the request.
+// The email comes from their verified token rather than the input.
+func (r *mutationResolver) SignUp(ctx context.Context, input models.SignUp) (*models.User, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return &models.User{}, fmt.Errorf("Access denied")
+	}
+
+	email, _ := user.Claims["email"].(string)
+	if email == "" {
+		return &models.User{}, fmt.Errorf("An email address is required to sign up")
+	}
+
+	// Anyone can claim an address in Firebase until it has been verified
+	if verified, _ := user.Claims["email_verified"].(bool); !verified {
+		return &models.User{}, fmt.Errorf("Verify your email address before signing up")
+	}
+
+	if input.Timezone != nil {
+		if _, err := time.LoadLocation(*input.Timezone); err != nil {
+			return &models.User{}, fmt.Errorf("Unknown timezone %s", *input.Timezone)
+		}
+	}
+
+	profile := accounts.Profile{
+		FirstName: input.FirstName,
+		LastName:  input.LastName,
+		Timezone:  input.Timezone,
+	}
+
+	signedUp, err := r.signup.Run(ctx, user.Subject, email, profile)
+	if err != nil {
+		log.Printf("failed to sign up %s: %v", user.Subject, err)
+		return &models.User{}, fmt.Errorf("Unable to finish signing up, please try again")
+	}
+
+	return signedUp, nil
+}
+
+func (r *mutationResolver) RequestEmailChange(ctx context.Context, userID string, email string) (bool, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return false, fmt.Errorf("Access denied")
+	}
+
+	if err := r.emails.Request(ctx, userID, email); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ConfirmEmailChange doesn't need a signed in user, the link is proof enough
+func (r *mutationResolver) ConfirmEmailChange(ctx context.Context, token string) (*models.User, error) {
+	userID, err := r.emails.Confirm(ctx, token)
+	if err != nil {
+		return &models.User{}, err
+	}
+
+	return (&queryResolver{r.Resolver}).UserByFirebaseID(ctx, &userID)
+}
+
+func (r *queryResolver) AccountDeletion(ctx context.Context, userID string) (*models.AccountDeletion, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return nil, fmt.Errorf("Access denied")
+	}
+
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT id, user_id, purge_after, completed_at, created_at, updated_at FROM account_deletions WHERE user_id = $1", userID)
+
+	var deletion = new(models.AccountDeletion)
+	err := res.Scan(&deletion.ID, &deletion.UserID, &deletion.PurgeAfter, &deletion.CompletedAt, &deletion.CreatedAt, &deletion.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		panic(err)
+	}
+
+	return deletion, nil
+}
+
+func (r *mutationResolver) DeleteAccount(ctx context.Context, userID string) (*models.AccountDeletion, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return &models.AccountDeletion{}, fmt.Errorf("Access denied")
+	}
+
+	var email string
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT email FROM users WHERE firebase_id = $1", userID)
+	if err := res.Scan(&email); err != nil {
+		panic(err)
+	}
+
+	purgeAfter := time.Now().Add(accounts.GracePeriod)
+
+	// Asking twice keeps the original schedule
+	deletion := &models.AccountDeletion{UserID: userID}
+	res = wrabitDB.LogAndQueryRow(r.db, "INSERT INTO account_deletions (user_id, email, purge_after) VALUES ($1, $2, $3) ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id RETURNING id, purge_after, completed_at, created_at, updated_at", userID, email, purgeAfter)
+	if err := res.Scan(&deletion.ID, &deletion.PurgeAfter, &deletion.CompletedAt, &deletion.CreatedAt, &deletion.UpdatedAt); err != nil {
+		panic(err)
+	}
+
+	content := fmt.Sprintf(`Hey there,<br><br>
+
+  We received your request to delete your Wrabit account. Your account and everything you wrote will be permanently deleted on %s.<br><br>
+
+  Changed your mind? Sign in before then and cancel the deletion from your settings.<br><br>
+
+  Be well,<br>
+  Team Wrabit 🐇
+  `, purgeAfter.Format("January 2, 2006"))
+
+	if err := mail.Send(context.Background(), email, "Your account is scheduled for deletion", content); err != nil {
+		fmt.Println(err)
+	}
+
+	return deletion, nil
+}
+
+func (r *mutationResolver) CancelAccountDeletion(ctx context.Context, userID string) (*models.AccountDeletion, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return &models.AccountDeletion{}, fmt.Errorf("Access denied")
+	}
+
+	res := wrabitDB.LogAndQueryRow(r.db, "DELETE FROM account_deletions WHERE user_id = $1 AND completed_at IS NULL RETURNING id, user_id, purge_after, completed_at, created_at, updated_at", userID)
+
+	var deletion = new(models.AccountDeletion)
+	err := res.Scan(&deletion.ID, &deletion.UserID, &deletion.PurgeAfter, &deletion.CompletedAt, &deletion.CreatedAt, &deletion.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return &models.AccountDeletion{}, fmt.Errorf("No pending deletion")
+	} else if err != nil {
+		panic(err)
+	}
+
+	return deletion, nil
+}
@@ -0,0 +1,28 @@
+package resolvers
+
+import (
+	"context"
+	"testing"
+
+	firebase "firebase.google.com/go/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/writewithwrabit/server/auth"
+	"github.com/writewithwrabit/server/models"
+)
+
+func TestSignUpRequiresVerifiedEmail(t *testing.T) {
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{},
+	}
+
+	for _, claims := range []map[string]interface{}{
+		{"email": "jane@example.com", "email_verified": false},
+		{"email": "jane@example.com"},
+	} {
+		ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg", Claims: claims})
+
+		_, err := mutResolver.SignUp(ctx, models.SignUp{FirstName: "Jane"})
+
+		assert.EqualError(t, err, "Verify your email address before signing up")
+	}
+}
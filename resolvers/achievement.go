@@ -0,0 +1,31 @@
+package resolvers
+
+import (
+	"context"
+
+	"github.com/writewithwrabit/server/achievements"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/models"
+)
+
+func (r *userResolver) Achievements(ctx context.Context, obj *models.User) ([]*models.Achievement, error) {
+	earned := []*models.Achievement{}
+	if obj.FirebaseID == nil {
+		return earned, nil
+	}
+
+	res := wrabitDB.LogAndQuery(r.db, "SELECT id, user_id, kind, entry_id, created_at FROM achievements WHERE user_id = $1 ORDER BY created_at", *obj.FirebaseID)
+	defer res.Close()
+
+	for res.Next() {
+		var achievement = new(models.Achievement)
+		if err := res.Scan(&achievement.ID, &achievement.UserID, &achievement.Kind, &achievement.EntryID, &achievement.EarnedAt); err != nil {
+			panic(err)
+		}
+
+		achievements.Describe(achievement)
+		earned = append(earned, achievement)
+	}
+
+	return earned, nil
+}
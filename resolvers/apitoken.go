@@ -0,0 +1,251 @@
+package resolvers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/lib/pq"
+	"github.com/writewithwrabit/server/auth"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/models"
+)
+
+// How many active API tokens a user can have at once
+const maxAPITokens = 20
+
+const apiTokenColumns = "id, user_id, name, prefix, scopes, last_used_at, expires_at, revoked_at, created_at"
+
+// The root fields an API token can use and the scope each one needs.
+// Anything missing, like account settings and billing, needs the app.
+var apiTokenFields = map[string]models.APITokenScope{
+	"entries":         models.APITokenScopeEntriesRead,
+	"entriesByUserID": models.APITokenScopeEntriesRead,
+	"dailyEntry":      models.APITokenScopeEntriesRead,
+	"trashedEntries":  models.APITokenScopeEntriesRead,
+	"tags":            models.APITokenScopeEntriesRead,
+	"checkIns":        models.APITokenScopeEntriesRead,
+	"entryUpdated":    models.APITokenScopeEntriesRead,
+	"createEntry":     models.APITokenScopeEntriesWrite,
+	"updateEntry":     models.APITokenScopeEntriesWrite,
+	"deleteEntry":     models.APITokenScopeEntriesWrite,
+	"restoreEntry":    models.APITokenScopeEntriesWrite,
+	"addEntryTags":    models.APITokenScopeEntriesWrite,
+	"removeEntryTags": models.APITokenScopeEntriesWrite,
+	"recordCheckIn":   models.APITokenScopeEntriesWrite,
+	"deleteCheckIn":   models.APITokenScopeEntriesWrite,
+	"stats":           models.APITokenScopeStatsRead,
+	"statsRange":      models.APITokenScopeStatsRead,
+	"wordGoal":        models.APITokenScopeStatsRead,
+	"moodTrends":      models.APITokenScopeStatsRead,
+	"streakStatus":    models.APITokenScopeStatsRead,
+	"streaks":         models.APITokenScopeStatsRead,
+	"writingCalendar": models.APITokenScopeStatsRead,
+	"monthlyInsights": models.APITokenScopeStatsRead,
+}
+
+// Fields of objects below the root that API tokens can read, for objects that
+// can be reached from more than one scope. Entries and streaks lead to their
+// owner, whose email, billing and encryption settings need the app.
+var apiTokenObjectFields = map[string]map[string]bool{
+	"User": {
+		"id":            true,
+		"firebaseID":    true,
+		"firstName":     true,
+		"lastName":      true,
+		"wordGoal":      true,
+		"timezone":      true,
+		"createdAt":     true,
+		"updatedAt":     true,
+		"currentStreak": true,
+		"achievements":  true,
+	},
+}
+
+// RequireScopes keeps requests made with an API token to the fields its scopes allow.
+// Users signed in through the app can use everything.
+func RequireScopes(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	rctx := graphql.GetResolverContext(ctx)
+	if rctx.Object != "Query" && rctx.Object != "Mutation" && rctx.Object != "Subscription" {
+		if err := checkObjectField(ctx, rctx.Object, rctx.Field.Name); err != nil {
+			return nil, err
+		}
+
+		return next(ctx)
+	}
+
+	if err := CheckScope(ctx, rctx.Field.Name); err != nil {
+		return nil, err
+	}
+
+	return next(ctx)
+}
+
+// CheckScope makes sure the request can use a root field.
+// Other APIs call it with the field they stand in for.
+func CheckScope(ctx context.Context, field string) error {
+	user := auth.ForContext(ctx)
+	if !auth.IsAPIToken(user) {
+		return nil
+	}
+
+	// Every token can find out who it belongs to
+	if field == "viewer" {
+		return nil
+	}
+
+	scope, ok := apiTokenFields[field]
+	if !ok || !auth.HasScope(user, string(scope)) {
+		return fmt.Errorf("This API token can't use %s", field)
+	}
+
+	return nil
+}
+
+// checkObjectField makes sure an API token can read a field below the root.
+// Objects without a list of fields are covered by the root field's scope.
+func checkObjectField(ctx context.Context, object string, field string) error {
+	fields, ok := apiTokenObjectFields[object]
+	if !ok || fields[field] || !auth.IsAPIToken(auth.ForContext(ctx)) {
+		return nil
+	}
+
+	return fmt.Errorf("This API token can't use %s.%s", object, field)
+}
+
+// Viewer tells clients who they are signed in as.
+// Scopes are only set for API tokens, which can't do everything the app can.
+func (r *queryResolver) Viewer(ctx context.Context) (*models.Viewer, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return &models.Viewer{}, fmt.Errorf("Access denied")
+	}
+
+	viewer := &models.Viewer{UserID: user.Subject}
+	if auth.IsAPIToken(user) {
+		scopes, _ := user.Claims["scopes"].([]string)
+		for _, scope := range scopes {
+			viewer.Scopes = append(viewer.Scopes, models.APITokenScope(scope))
+		}
+	}
+
+	return viewer, nil
+}
+
+func (r *queryResolver) APITokens(ctx context.Context, userID string) ([]*models.APIToken, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return []*models.APIToken{}, fmt.Errorf("Access denied")
+	}
+
+	res := wrabitDB.LogAndQuery(r.db, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC", userID)
+	defer res.Close()
+
+	tokens := []*models.APIToken{}
+	for res.Next() {
+		var token = new(models.APIToken)
+		if err := scanAPIToken(res, token); err != nil {
+			panic(err)
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+// CreateAPIToken returns the token itself this one time. Only its hash is kept.
+func (r *mutationResolver) CreateAPIToken(ctx context.Context, input models.NewAPIToken) (*models.CreatedAPIToken, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != input.UserID {
+		return &models.CreatedAPIToken{}, fmt.Errorf("Access denied")
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return &models.CreatedAPIToken{}, fmt.Errorf("API tokens need a name")
+	}
+
+	if len(input.Scopes) == 0 {
+		return &models.CreatedAPIToken{}, fmt.Errorf("API tokens need at least one scope")
+	}
+
+	var expiresAt *time.Time
+	if input.ExpiresInDays != nil {
+		if *input.ExpiresInDays < 1 {
+			return &models.CreatedAPIToken{}, fmt.Errorf("API tokens must last at least a day")
+		}
+
+		expires := time.Now().AddDate(0, 0, *input.ExpiresInDays)
+		expiresAt = &expires
+	}
+
+	var active int
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT count(*) FROM api_tokens WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())", input.UserID)
+	if err := res.Scan(&active); err != nil {
+		panic(err)
+	}
+
+	if active >= maxAPITokens {
+		return &models.CreatedAPIToken{}, fmt.Errorf("You can only have %d API tokens, revoke one first", maxAPITokens)
+	}
+
+	secret, err := auth.NewAPIToken()
+	if err != nil {
+		panic(err)
+	}
+
+	var scopes []string
+	for _, scope := range input.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	// Enough of the token to recognise it in a list
+	prefix := secret[:len(auth.APITokenPrefix)+4]
+
+	token := new(models.APIToken)
+	res = wrabitDB.LogAndQueryRow(r.db, "INSERT INTO api_tokens (user_id, name, prefix, token_hash, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING "+apiTokenColumns, input.UserID, name, prefix, auth.HashAPIToken(secret), pq.Array(scopes), expiresAt)
+	if err := scanAPIToken(res, token); err != nil {
+		panic(err)
+	}
+
+	return &models.CreatedAPIToken{
+		Token:    secret,
+		APIToken: token,
+	}, nil
+}
+
+func (r *mutationResolver) RevokeAPIToken(ctx context.Context, userID string, id string) (*models.APIToken, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return &models.APIToken{}, fmt.Errorf("Access denied")
+	}
+
+	token := new(models.APIToken)
+	res := wrabitDB.LogAndQueryRow(r.db, "UPDATE api_tokens SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1 AND user_id = $2 RETURNING "+apiTokenColumns, id, userID)
+	err := scanAPIToken(res, token)
+	if err == sql.ErrNoRows {
+		return &models.APIToken{}, fmt.Errorf("API token not found")
+	} else if err != nil {
+		panic(err)
+	}
+
+	return token, nil
+}
+
+func scanAPIToken(row scanner, token *models.APIToken) error {
+	var scopes []string
+	if err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.Prefix, pq.Array(&scopes), &token.LastUsedAt, &token.ExpiresAt, &token.RevokedAt, &token.CreatedAt); err != nil {
+		return err
+	}
+
+	token.Scopes = []models.APITokenScope{}
+	for _, scope := range scopes {
+		token.Scopes = append(token.Scopes, models.APITokenScope(scope))
+	}
+
+	return nil
+}
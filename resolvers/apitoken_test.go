@@ -0,0 +1,82 @@
+package resolvers
+
+import (
+	"context"
+	"testing"
+
+	firebase "firebase.google.com/go/auth"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/ast"
+	"github.com/writewithwrabit/server/auth"
+)
+
+func rootField(ctx context.Context, object string, name string) context.Context {
+	return graphql.WithResolverContext(ctx, &graphql.ResolverContext{
+		Object: object,
+		Field:  graphql.CollectedField{Field: &ast.Field{Name: name}},
+	})
+}
+
+func TestRequireScopes(t *testing.T) {
+	next := func(ctx context.Context) (interface{}, error) {
+		return "resolved", nil
+	}
+
+	apiToken := &firebase.Token{
+		Issuer:  auth.APITokenIssuer,
+		Subject: "abcdefg",
+		Claims:  map[string]interface{}{"scopes": []string{"ENTRIES_WRITE"}},
+	}
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, apiToken)
+
+	res, err := RequireScopes(rootField(ctx, "Mutation", "createEntry"), next)
+	assert.Nil(t, err)
+	assert.Equal(t, "resolved", res)
+
+	_, err = RequireScopes(rootField(ctx, "Query", "entriesByUserID"), next)
+	assert.NotNil(t, err, "scopes that weren't granted are refused")
+
+	_, err = RequireScopes(rootField(ctx, "Mutation", "createAPIToken"), next)
+	assert.NotNil(t, err, "API tokens can't manage the account")
+
+	_, err = RequireScopes(rootField(ctx, "Query", "viewer"), next)
+	assert.Nil(t, err, "every token can see who it belongs to")
+
+	// Fields below the root are covered by the root's scope
+	_, err = RequireScopes(rootField(ctx, "Entry", "tags"), next)
+	assert.Nil(t, err)
+
+	appUser := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+	_, err = RequireScopes(rootField(appUser, "Mutation", "createAPIToken"), next)
+	assert.Nil(t, err)
+}
+
+func TestRequireScopesBelowTheRoot(t *testing.T) {
+	next := func(ctx context.Context) (interface{}, error) {
+		return "resolved", nil
+	}
+
+	apiToken := &firebase.Token{
+		Issuer:  auth.APITokenIssuer,
+		Subject: "abcdefg",
+		Claims:  map[string]interface{}{"scopes": []string{"ENTRIES_READ"}},
+	}
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, apiToken)
+
+	// entriesByUserID { User { ... } } reaches the entry's owner
+	_, err := RequireScopes(rootField(ctx, "Entry", "User"), next)
+	assert.Nil(t, err)
+
+	_, err = RequireScopes(rootField(ctx, "User", "firstName"), next)
+	assert.Nil(t, err)
+
+	for _, field := range []string{"email", "stripeID", "StripeSubscription", "clientEncryptionParams"} {
+		_, err = RequireScopes(rootField(ctx, "User", field), next)
+		assert.EqualError(t, err, "This API token can't use User."+field)
+	}
+
+	appUser := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+	_, err = RequireScopes(rootField(appUser, "User", "StripeSubscription"), next)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,413 @@
+package resolvers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/writewithwrabit/server/auth"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/keystore"
+	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/reminders"
+	"github.com/writewithwrabit/server/streaks"
+)
+
+const checkInColumns = "id, user_id, entry_id, to_char(date, 'YYYY-MM-DD'), mood, energy, trackers, created_at, updated_at"
+
+// Days of history each trend range covers
+var trendDays = map[models.TrendRange]int{
+	models.TrendRangeWeek:    7,
+	models.TrendRangeMonth:   30,
+	models.TrendRangeQuarter: 90,
+	models.TrendRangeYear:    365,
+}
+
+func (r *queryResolver) CheckIns(ctx context.Context, userID string, startDate string, endDate string) ([]*models.CheckIn, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return []*models.CheckIn{}, fmt.Errorf("Access denied")
+	}
+
+	key := r.checkInKey(userID)
+	res := wrabitDB.LogAndQuery(r.db, "SELECT "+checkInColumns+" FROM check_ins WHERE user_id = $1 AND date >= $2 AND date <= $3 ORDER BY date", userID, startDate, endDate)
+	defer res.Close()
+
+	checkIns := []*models.CheckIn{}
+	for res.Next() {
+		var checkIn = new(models.CheckIn)
+		if err := scanCheckIn(res, checkIn, key); err != nil {
+			panic(err)
+		}
+
+		checkIns = append(checkIns, checkIn)
+	}
+
+	return checkIns, nil
+}
+
+func (r *entryResolver) CheckIn(ctx context.Context, obj *models.Entry) (*models.CheckIn, error) {
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT "+checkInColumns+" FROM check_ins WHERE entry_id = $1", obj.ID)
+
+	var checkIn = new(models.CheckIn)
+	err := scanCheckIn(res, checkIn, r.checkInKey(obj.UserID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		panic(err)
+	}
+
+	return checkIn, nil
+}
+
+func (r *mutationResolver) RecordCheckIn(ctx context.Context, input models.NewCheckIn) (*models.CheckIn, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != input.UserID {
+		return &models.CheckIn{}, fmt.Errorf("Access denied")
+	}
+
+	loc := r.userLocation(input.UserID)
+	day, err := time.ParseInLocation("2006-01-02", input.Date, loc)
+	if err != nil {
+		return &models.CheckIn{}, fmt.Errorf("Date must be formatted as YYYY-MM-DD")
+	}
+
+	if input.Mood != nil && (*input.Mood < 1 || *input.Mood > 5) {
+		return &models.CheckIn{}, fmt.Errorf("Mood must be between 1 and 5")
+	}
+
+	if input.Energy != nil && (*input.Energy < 1 || *input.Energy > 5) {
+		return &models.CheckIn{}, fmt.Errorf("Energy must be between 1 and 5")
+	}
+
+	// Moods and tracker names are sealed with the user's key, they say as much as an entry
+	key, err := r.keys.UserKey(input.UserID)
+	if err != nil {
+		panic(err)
+	}
+
+	var mood *string
+	if input.Mood != nil {
+		sealed := keystore.Seal(strconv.Itoa(*input.Mood), key)
+		mood = &sealed
+	}
+
+	// Trackers are only replaced when they are sent
+	var trackers *string
+	if input.Trackers != nil {
+		values := []*models.Tracker{}
+		for _, tracker := range input.Trackers {
+			name := strings.TrimSpace(tracker.Name)
+			if name == "" {
+				return &models.CheckIn{}, fmt.Errorf("Trackers must have a name")
+			}
+
+			values = append(values, &models.Tracker{Name: keystore.Seal(name, key), Value: tracker.Value})
+		}
+
+		encoded, err := json.Marshal(values)
+		if err != nil {
+			panic(err)
+		}
+
+		trackersJSON := string(encoded)
+		trackers = &trackersJSON
+	}
+
+	// Attach the check-in to the daily entry written that day, if there is one
+	var entryID *string
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT id FROM entries WHERE user_id = $1 AND kind = 'DAILY' AND deleted_at IS NULL AND created_at >= $2 AND created_at < $3 ORDER BY created_at DESC LIMIT 1", input.UserID, day.UTC(), day.AddDate(0, 0, 1).UTC())
+	if err := res.Scan(&entryID); err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+
+	res = wrabitDB.LogAndQueryRow(r.db, "INSERT INTO check_ins (user_id, entry_id, date, mood, energy, trackers) VALUES ($1, $2, $3, $4, $5, COALESCE($6::jsonb, '[]')) ON CONFLICT (user_id, date) DO UPDATE SET entry_id = COALESCE(EXCLUDED.entry_id, check_ins.entry_id), mood = COALESCE(EXCLUDED.mood, check_ins.mood), energy = COALESCE(EXCLUDED.energy, check_ins.energy), trackers = COALESCE($6::jsonb, check_ins.trackers) RETURNING "+checkInColumns, input.UserID, entryID, input.Date, mood, input.Energy, trackers)
+
+	var checkIn = new(models.CheckIn)
+	if err := scanCheckIn(res, checkIn, key); err != nil {
+		panic(err)
+	}
+
+	return checkIn, nil
+}
+
+func (r *mutationResolver) DeleteCheckIn(ctx context.Context, userID string, date string) (*models.CheckIn, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return &models.CheckIn{}, fmt.Errorf("Access denied")
+	}
+
+	res := wrabitDB.LogAndQueryRow(r.db, "DELETE FROM check_ins WHERE user_id = $1 AND date = $2 RETURNING "+checkInColumns, userID, date)
+
+	var checkIn = new(models.CheckIn)
+	err := scanCheckIn(res, checkIn, r.checkInKey(userID))
+	if err == sql.ErrNoRows {
+		return &models.CheckIn{}, fmt.Errorf("No check-in on %s", date)
+	} else if err != nil {
+		panic(err)
+	}
+
+	return checkIn, nil
+}
+
+func (r *queryResolver) MoodTrends(ctx context.Context, userID string, trendRange models.TrendRange) (*models.MoodTrends, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return &models.MoodTrends{}, fmt.Errorf("Access denied")
+	}
+
+	settings := r.streakSettings(userID)
+	loc := settings.location
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	start := today.AddDate(0, 0, 1-trendDays[trendRange])
+
+	var days []*models.MoodTrendDay
+	byDate := map[string]*models.MoodTrendDay{}
+	for day := start; !day.After(today); day = day.AddDate(0, 0, 1) {
+		trendDay := &models.MoodTrendDay{Date: day.Format("2006-01-02")}
+		days = append(days, trendDay)
+		byDate[trendDay.Date] = trendDay
+	}
+
+	key := r.checkInKey(userID)
+	res := wrabitDB.LogAndQuery(r.db, "SELECT to_char(date, 'YYYY-MM-DD'), mood, energy FROM check_ins WHERE user_id = $1 AND date >= $2", userID, start.Format("2006-01-02"))
+	for res.Next() {
+		var date string
+		var mood sql.NullString
+		var energy *int
+		if err := res.Scan(&date, &mood, &energy); err != nil {
+			res.Close()
+			panic(err)
+		}
+
+		if day, ok := byDate[date]; ok {
+			day.Mood = openMood(mood, key)
+			day.Energy = energy
+		}
+	}
+	res.Close()
+
+	// Goal history only needs to go back as far as the longest streak could
+	// reach into the range, so streaks running into it have their full length
+	var longest int
+	row := wrabitDB.LogAndQueryRow(r.db, "SELECT COALESCE(max(updated_at::date - created_at::date), 0) FROM streaks WHERE user_id = $1", userID)
+	if err := row.Scan(&longest); err != nil {
+		panic(err)
+	}
+	historyStart := start.AddDate(0, 0, -longest-1)
+
+	// Words and goals are grouped by the user's local day so they line up with check-ins
+	history := &goalHistory{frozen: map[string]bool{}, restDays: settings.restDays}
+	res = wrabitDB.LogAndQuery(r.db, "SELECT to_char(created_at AT TIME ZONE $2, 'YYYY-MM-DD'), sum(word_count), bool_or(goal_hit) FROM entries WHERE user_id = $1 AND deleted_at IS NULL AND created_at >= $3 GROUP BY 1 ORDER BY 1", userID, loc.String(), historyStart.UTC())
+	for res.Next() {
+		var date string
+		var wordCount int
+		var hit bool
+		if err := res.Scan(&date, &wordCount, &hit); err != nil {
+			res.Close()
+			panic(err)
+		}
+
+		if hit {
+			day, _ := time.ParseInLocation("2006-01-02", date, loc)
+			history.hits = append(history.hits, day)
+		}
+
+		if day, ok := byDate[date]; ok {
+			day.WordCount = wordCount
+		}
+	}
+	res.Close()
+
+	res = wrabitDB.LogAndQuery(r.db, "SELECT to_char(day, 'YYYY-MM-DD') FROM frozen_days WHERE user_id = $1 AND day >= $2", userID, historyStart.Format("2006-01-02"))
+	for res.Next() {
+		var date string
+		if err := res.Scan(&date); err != nil {
+			res.Close()
+			panic(err)
+		}
+
+		history.frozen[date] = true
+	}
+	res.Close()
+
+	for _, day := range days {
+		date, _ := time.ParseInLocation("2006-01-02", day.Date, loc)
+		day.StreakLength = history.streakLength(date)
+	}
+
+	return moodTrends(days), nil
+}
+
+// goalHistory is what decides how long a streak was on any past day
+type goalHistory struct {
+	hits     []time.Time // days the goal was hit, oldest first
+	frozen   map[string]bool
+	restDays []int
+}
+
+// streakLength is the streak the user had going into the day.
+// A day where the goal hasn't been hit yet carries the streak from before it,
+// the same way rest and frozen days carry a streak.
+func (h *goalHistory) streakLength(day time.Time) int {
+	i := sort.Search(len(h.hits), func(i int) bool { return h.hits[i].After(day) }) - 1
+
+	length := 0
+	for reach := day; i >= 0 && h.continues(h.hits[i], reach); i-- {
+		length++
+		reach = h.hits[i]
+	}
+
+	return length
+}
+
+// continues checks if a goal hit on last carries on to reach, with every day
+// missed in between frozen
+func (h *goalHistory) continues(last time.Time, reach time.Time) bool {
+	frozen := 0
+	for _, day := range streaks.Missed(last, reach, h.restDays) {
+		if h.frozen[day.Format("2006-01-02")] {
+			frozen++
+		}
+	}
+
+	_, ok := streaks.Spend(last, reach, h.restDays, frozen)
+	return ok
+}
+
+// moodTrends averages the check-ins and correlates mood with writing over the days that have one
+func moodTrends(days []*models.MoodTrendDay) *models.MoodTrends {
+	trends := &models.MoodTrends{Days: days}
+
+	var moods, energies, wordCounts, streaks []float64
+	for _, day := range days {
+		if day.Energy != nil {
+			energies = append(energies, float64(*day.Energy))
+		}
+
+		if day.Mood == nil {
+			continue
+		}
+
+		moods = append(moods, float64(*day.Mood))
+		wordCounts = append(wordCounts, float64(day.WordCount))
+		streaks = append(streaks, float64(day.StreakLength))
+	}
+
+	trends.AverageMood = mean(moods)
+	trends.AverageEnergy = mean(energies)
+	trends.MoodWordCountCorrelation = correlation(moods, wordCounts)
+	trends.MoodStreakCorrelation = correlation(moods, streaks)
+
+	return trends
+}
+
+func mean(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+
+	result := sum / float64(len(values))
+	return &result
+}
+
+// correlation is the Pearson correlation coefficient of two series.
+// It is nil when there are too few points or a series never changes.
+func correlation(xs []float64, ys []float64) *float64 {
+	if len(xs) < 3 || len(xs) != len(ys) {
+		return nil
+	}
+
+	meanX, meanY := *mean(xs), *mean(ys)
+
+	var covariance, varianceX, varianceY float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		covariance += dx * dy
+		varianceX += dx * dx
+		varianceY += dy * dy
+	}
+
+	if varianceX == 0 || varianceY == 0 {
+		return nil
+	}
+
+	result := covariance / math.Sqrt(varianceX*varianceY)
+	return &result
+}
+
+// userLocation loads the timezone the user writes in
+func (r *Resolver) userLocation(userID string) *time.Location {
+	var timezone string
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT timezone FROM users WHERE firebase_id = $1", userID)
+	if err := res.Scan(&timezone); err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+
+	return reminders.Location(timezone)
+}
+
+// checkInKey is the key check-ins are sealed with, nil if nothing has been sealed for the user yet
+func (r *Resolver) checkInKey(userID string) *[32]byte {
+	key, err := r.keys.FindUserKey(userID)
+	if err == keystore.ErrNoKey {
+		return nil
+	} else if err != nil {
+		panic(err)
+	}
+
+	return key
+}
+
+// openMood decrypts a sealed mood. Moods recorded before sealing are plain numbers and come back as they are.
+func openMood(mood sql.NullString, key *[32]byte) *int {
+	if !mood.Valid {
+		return nil
+	}
+
+	value := mood.String
+	if key != nil {
+		value = keystore.Open(value, key)
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return nil
+	}
+
+	return &number
+}
+
+func scanCheckIn(row scanner, checkIn *models.CheckIn, key *[32]byte) error {
+	var mood sql.NullString
+	var trackers []byte
+	if err := row.Scan(&checkIn.ID, &checkIn.UserID, &checkIn.EntryID, &checkIn.Date, &mood, &checkIn.Energy, &trackers, &checkIn.CreatedAt, &checkIn.UpdatedAt); err != nil {
+		return err
+	}
+
+	checkIn.Mood = openMood(mood, key)
+	checkIn.Trackers = []*models.Tracker{}
+	if err := json.Unmarshal(trackers, &checkIn.Trackers); err != nil {
+		return err
+	}
+
+	if key != nil {
+		for _, tracker := range checkIn.Trackers {
+			tracker.Name = keystore.Open(tracker.Name, key)
+		}
+	}
+
+	return nil
+}
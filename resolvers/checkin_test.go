@@ -0,0 +1,214 @@
+package resolvers
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	firebase "firebase.google.com/go/auth"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/writewithwrabit/server/auth"
+	cryptopasta "github.com/writewithwrabit/server/cryptopasta"
+	"github.com/writewithwrabit/server/keystore"
+	"github.com/writewithwrabit/server/models"
+)
+
+func TestStreakLengthCarriesIntoUnwrittenDay(t *testing.T) {
+	day := func(date string) time.Time {
+		d, _ := time.Parse("2006-01-02", date)
+		return d
+	}
+
+	history := &goalHistory{
+		hits:   []time.Time{day("2020-03-01"), day("2020-03-02"), day("2020-03-03"), day("2020-03-05")},
+		frozen: map[string]bool{},
+	}
+
+	assert.Equal(t, 3, history.streakLength(day("2020-03-03")))
+	assert.Equal(t, 3, history.streakLength(day("2020-03-04")))
+	assert.Equal(t, 1, history.streakLength(day("2020-03-05")))
+	assert.Equal(t, 0, history.streakLength(day("2020-03-08")))
+}
+
+func TestStreakLengthSkipsRestAndFrozenDays(t *testing.T) {
+	day := func(date string) time.Time {
+		d, _ := time.Parse("2006-01-02", date)
+		return d
+	}
+
+	// 2020-03-01 is a Sunday
+	history := &goalHistory{
+		hits:     []time.Time{day("2020-02-28"), day("2020-02-29"), day("2020-03-02"), day("2020-03-04")},
+		frozen:   map[string]bool{"2020-03-03": true},
+		restDays: []int{0},
+	}
+
+	assert.Equal(t, 3, history.streakLength(day("2020-03-02")))
+	assert.Equal(t, 4, history.streakLength(day("2020-03-04")))
+	assert.Equal(t, 4, history.streakLength(day("2020-03-05")))
+
+	// Without the freeze the streak starts over
+	history.frozen = map[string]bool{}
+	assert.Equal(t, 1, history.streakLength(day("2020-03-04")))
+}
+
+func TestMoodTrendsCorrelateMoodWithWriting(t *testing.T) {
+	mood := func(m int) *int { return &m }
+
+	trends := moodTrends([]*models.MoodTrendDay{
+		{Date: "2020-03-01", Mood: mood(2), WordCount: 100, StreakLength: 4},
+		{Date: "2020-03-02", Mood: mood(3), WordCount: 200, StreakLength: 4},
+		{Date: "2020-03-03", WordCount: 5000, StreakLength: 4},
+		{Date: "2020-03-04", Mood: mood(4), WordCount: 300, StreakLength: 4},
+	})
+
+	assert.Equal(t, 3.0, *trends.AverageMood)
+	assert.Nil(t, trends.AverageEnergy)
+	assert.InDelta(t, 1.0, *trends.MoodWordCountCorrelation, 0.0001)
+	// A streak that never changes can't be correlated with anything
+	assert.Nil(t, trends.MoodStreakCorrelation)
+	assert.Len(t, trends.Days, 4)
+}
+
+func TestRecordCheckInSealsMoodAndTrackers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(db, "")},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+	key := cryptopasta.NewEncryptionKey()
+
+	mock.ExpectQuery("SELECT timezone FROM users WHERE firebase_id \\= \\$1").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone"}).AddRow("UTC"))
+	mock.ExpectQuery("SELECT wrapped_key FROM encryption_keys WHERE user_id \\= \\$1 AND user_key").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"wrapped_key"}).AddRow(wrappedKey(t, key)))
+	mock.ExpectQuery("SELECT id FROM entries WHERE user_id \\= \\$1 AND kind \\= 'DAILY'").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	mood, trackers := &capture{}, &capture{}
+	mock.ExpectQuery("INSERT INTO check_ins \\(user_id, entry_id, date, mood, energy, trackers\\)").
+		WithArgs("abcdefg", nil, "2020-03-01", mood, nil, trackers).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "entry_id", "date", "mood", "energy", "trackers", "created_at", "updated_at"}).
+			AddRow("1", "abcdefg", nil, "2020-03-01", keystore.Seal("4", key), nil, `[{"name": "`+keystore.Seal("Slept well", key)+`", "value": 1}]`, "2020-03-01", "2020-03-01"))
+
+	four := 4
+	checkIn, err := mutResolver.RecordCheckIn(ctx, models.NewCheckIn{
+		UserID:   "abcdefg",
+		Date:     "2020-03-01",
+		Mood:     &four,
+		Trackers: []*models.TrackerValue{{Name: "Slept well", Value: 1}},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 4, *checkIn.Mood)
+	assert.Equal(t, []*models.Tracker{{Name: "Slept well", Value: 1}}, checkIn.Trackers)
+
+	// Neither is stored in the clear
+	assert.Equal(t, "4", keystore.Open(mood.value.(string), key))
+	assert.NotEqual(t, "4", mood.value)
+	assert.NotContains(t, trackers.value, "Slept well")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCheckInsFromBeforeSealing(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(db, "")},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	mock.ExpectQuery("SELECT wrapped_key FROM encryption_keys WHERE user_id \\= \\$1 AND user_key").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"wrapped_key"}))
+	mock.ExpectQuery("SELECT (.+) FROM check_ins WHERE user_id \\= \\$1").
+		WithArgs("abcdefg", "2020-03-01", "2020-03-31").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "entry_id", "date", "mood", "energy", "trackers", "created_at", "updated_at"}).
+			AddRow("1", "abcdefg", nil, "2020-03-01", "3", 2, `[{"name": "Ran", "value": 1}]`, "2020-03-01", "2020-03-01"))
+
+	checkIns, err := queryResolver.CheckIns(ctx, "abcdefg", "2020-03-01", "2020-03-31")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, *checkIns[0].Mood)
+	assert.Equal(t, "Ran", checkIns[0].Trackers[0].Name)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMoodTrendsOnlyLoadsHistoryStreaksCanReach(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(db, "")},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	start := today.AddDate(0, 0, -6)
+	historyStart := start.AddDate(0, 0, -11)
+
+	mock.ExpectQuery("SELECT timezone, rest_days, streak_freezes FROM users").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone", "rest_days", "streak_freezes"}).AddRow("UTC", "{}", 0))
+	mock.ExpectQuery("SELECT wrapped_key FROM encryption_keys WHERE user_id \\= \\$1 AND user_key").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"wrapped_key"}))
+	mock.ExpectQuery("SELECT to_char\\(date, 'YYYY-MM-DD'\\), mood, energy FROM check_ins").
+		WithArgs("abcdefg", start.Format("2006-01-02")).
+		WillReturnRows(sqlmock.NewRows([]string{"date", "mood", "energy"}).AddRow(start.Format("2006-01-02"), "4", nil))
+	mock.ExpectQuery("SELECT COALESCE\\(max\\(updated_at::date - created_at::date\\), 0\\) FROM streaks").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(10))
+	mock.ExpectQuery("SELECT (.+) FROM entries WHERE user_id \\= \\$1 AND deleted_at IS NULL AND created_at >= \\$3").
+		WithArgs("abcdefg", "UTC", historyStart).
+		WillReturnRows(sqlmock.NewRows([]string{"date", "sum", "bool_or"}).
+			AddRow(start.AddDate(0, 0, -2).Format("2006-01-02"), 500, true).
+			AddRow(start.AddDate(0, 0, -1).Format("2006-01-02"), 500, false).
+			AddRow(start.Format("2006-01-02"), 800, true))
+	mock.ExpectQuery("SELECT to_char\\(day, 'YYYY-MM-DD'\\) FROM frozen_days").
+		WithArgs("abcdefg", historyStart.Format("2006-01-02")).
+		WillReturnRows(sqlmock.NewRows([]string{"day"}).AddRow(start.AddDate(0, 0, -1).Format("2006-01-02")))
+
+	trends, err := queryResolver.MoodTrends(ctx, "abcdefg", models.TrendRangeWeek)
+
+	assert.Nil(t, err)
+	assert.Len(t, trends.Days, 7)
+	assert.Equal(t, 4, *trends.Days[0].Mood)
+	assert.Equal(t, 800, trends.Days[0].WordCount)
+	// The frozen day before the range keeps the streak going
+	assert.Equal(t, 2, trends.Days[0].StreakLength)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// captures an argument so it can be handed back in a later row
+type capture struct {
+	value driver.Value
+}
+
+func (c *capture) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
@@ -0,0 +1,83 @@
+package resolvers
+
+import (
+	"github.com/writewithwrabit/server/keystore"
+	"github.com/writewithwrabit/server/models"
+)
+
+// entryKey is the entry's own key, created if the entry doesn't have one yet.
+// Entries written before per-entry keys move to their own key here.
+func (r *Resolver) entryKey(entry *models.Entry) *[32]byte {
+	var key *[32]byte
+	if entry.KeyID != nil {
+		var err error
+		key, err = r.keys.Get(*entry.KeyID)
+		if err != nil {
+			entry.KeyID = nil
+		}
+	}
+
+	if entry.KeyID == nil {
+		var keyID string
+		keyID, key = r.keys.Create(entry.UserID)
+		entry.KeyID = &keyID
+	}
+
+	return key
+}
+
+// sealEntry encrypts an entry's content and title with the entry's key,
+// returning the key for anything else kept with the entry
+func (r *Resolver) sealEntry(entry *models.Entry, content string, title *string) (string, *string, *[32]byte) {
+	key := r.entryKey(entry)
+
+	if title == nil {
+		return keystore.Seal(content, key), nil, key
+	}
+
+	sealedTitle := keystore.Seal(*title, key)
+	return keystore.Seal(content, key), &sealedTitle, key
+}
+
+// openEntries decrypts entry content in place.
+// Content whose key has been destroyed can never be read again so it is blanked.
+// Client encrypted content is passed through for the client to decrypt.
+func (r *Resolver) openEntries(entries ...*models.Entry) {
+	var ids []string
+	for _, entry := range entries {
+		if entry.KeyID != nil {
+			ids = append(ids, *entry.KeyID)
+		}
+	}
+
+	keys, err := r.keys.GetMany(ids)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, entry := range entries {
+		if entry.ClientEncrypted {
+			continue
+		}
+
+		// Entries without a key were encrypted with the global key
+		key := r.keys.Master()
+		if entry.KeyID != nil {
+			var ok bool
+			if key, ok = keys[*entry.KeyID]; !ok {
+				entry.Content = ""
+				entry.Title = nil
+				continue
+			}
+		}
+
+		if entry.Content != "" {
+			entry.Content = keystore.Open(entry.Content, key)
+		}
+
+		if entry.Title != nil {
+			title := keystore.Open(*entry.Title, key)
+			entry.Title = &title
+		}
+	}
+}
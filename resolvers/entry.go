@@ -3,100 +3,131 @@ package resolvers
 import (
 	"context"
 	"database/sql"
-	"encoding/hex"
 	"fmt"
 	"os"
-	"strconv"
 	"time"
 
 	stripe "github.com/stripe/stripe-go"
 	"github.com/stripe/stripe-go/sub"
+	"github.com/vektah/gqlparser/gqlerror"
 	"github.com/writewithwrabit/server/auth"
-	cryptopasta "github.com/writewithwrabit/server/cryptopasta"
 	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/keystore"
 	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/push"
+	"github.com/writewithwrabit/server/reminders"
+	"github.com/writewithwrabit/server/stats"
+	"github.com/writewithwrabit/server/streaks"
+	"github.com/writewithwrabit/server/trash"
 )
 
+// Columns scanned by scanEntry
+const entryColumns = "id, user_id, word_count, content, created_at, updated_at, goal_hit, version, key_id, client_encrypted, kind, title"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEntry(row scanner, entry *models.Entry) error {
+	return row.Scan(entryFields(entry)...)
+}
+
+// entryFields are the destinations for entryColumns
+func entryFields(entry *models.Entry) []interface{} {
+	return []interface{}{&entry.ID, &entry.UserID, &entry.WordCount, &entry.Content, &entry.CreatedAt, &entry.UpdatedAt, &entry.GoalHit, &entry.Version, &entry.KeyID, &entry.ClientEncrypted, &entry.Kind, &entry.Title}
+}
+
 func (r *queryResolver) Entries(ctx context.Context, id *string) ([]*models.Entry, error) {
 	if user := auth.ForContext(ctx); user == nil {
 		return []*models.Entry{}, fmt.Errorf("Access denied")
 	}
 
-	key := [32]byte{}
-	keyString := os.Getenv("ENCRYPTION_KEY")
-	copy(key[:], keyString)
-
 	var entries []*models.Entry
 
 	if id == nil {
-		res := wrabitDB.LogAndQuery(r.db, "SELECT * FROM entries")
+		res := wrabitDB.LogAndQuery(r.db, "SELECT "+entryColumns+" FROM entries WHERE deleted_at IS NULL")
 		defer res.Close()
 		for res.Next() {
 			var entry = new(models.Entry)
-			if err := res.Scan(&entry.ID, &entry.UserID, &entry.WordCount, &entry.Content, &entry.CreatedAt, &entry.UpdatedAt); err != nil {
+			if err := scanEntry(res, entry); err != nil {
 				panic(err)
 			}
 
-			decodedContent, err := hex.DecodeString(entry.Content)
-			content, err := cryptopasta.Decrypt(decodedContent, &key)
-			if err == nil {
-				entry.Content = string(content)
-			}
-
 			entries = append(entries, entry)
 		}
 	} else {
-		res := wrabitDB.LogAndQueryRow(r.db, "SELECT * FROM entries WHERE id = $1", id)
+		res := wrabitDB.LogAndQueryRow(r.db, "SELECT "+entryColumns+" FROM entries WHERE id = $1 AND deleted_at IS NULL", id)
 
 		var entry = new(models.Entry)
-		if err := res.Scan(&entry.ID, &entry.UserID, &entry.WordCount, &entry.Content, &entry.CreatedAt, &entry.UpdatedAt, &entry.GoalHit); err != nil {
+		if err := scanEntry(res, entry); err != nil {
 			panic(err)
 		}
 
 		entries = append(entries, entry)
 	}
 
+	r.openEntries(entries...)
+
 	return entries, nil
 }
 
-func (r *queryResolver) EntriesByUserID(ctx context.Context, userID string, startDate *string, endDate *string) ([]*models.Entry, error) {
-	if user := auth.ForContext(ctx); user == nil {
+func (r *queryResolver) EntriesByUserID(ctx context.Context, userID string, startDate *string, endDate *string, tag *string, kind *models.EntryKind) ([]*models.Entry, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
 		return []*models.Entry{}, fmt.Errorf("Access denied")
 	}
 
-	key := [32]byte{}
-	keyString := os.Getenv("ENCRYPTION_KEY")
-	copy(key[:], keyString)
-
 	var entries []*models.Entry
 
-	var res *sql.Rows
-	if startDate != nil && endDate == nil {
-		res = wrabitDB.LogAndQuery(r.db, "SELECT * FROM entries WHERE user_id = $1 AND created_at >= $2 AND word_count > 0 ORDER BY created_at DESC", userID, startDate)
-	} else if startDate == nil && endDate != nil {
-		res = wrabitDB.LogAndQuery(r.db, "SELECT * FROM entries WHERE user_id = $1 AND created_at <= $2 AND word_count > 0 ORDER BY created_at DESC", userID, endDate)
-	} else if startDate != nil && endDate != nil {
-		res = wrabitDB.LogAndQuery(r.db, "SELECT * FROM entries WHERE user_id = $1 AND created_at >= $2 AND word_count > 0 AND created_at <= $3 ORDER BY created_at DESC", userID, startDate, endDate)
-	} else {
-		res = wrabitDB.LogAndQuery(r.db, "SELECT * FROM entries WHERE user_id = $1 AND word_count > 0 ORDER BY created_at DESC", userID)
+	query := "SELECT " + entryColumns + " FROM entries WHERE user_id = $1 AND word_count > 0 AND deleted_at IS NULL"
+	args := []interface{}{userID}
+
+	if startDate != nil {
+		args = append(args, startDate)
+		query += fmt.Sprintf(" AND created_at >= $%d", len(args))
+	}
+
+	if endDate != nil {
+		args = append(args, endDate)
+		query += fmt.Sprintf(" AND created_at <= $%d", len(args))
 	}
 
+	if tag != nil {
+		// Only the owner's key can hash the name
+		if user.Subject != userID {
+			return []*models.Entry{}, fmt.Errorf("Access denied")
+		}
+
+		key, err := r.keys.FindUserKey(userID)
+		if err == keystore.ErrNoKey {
+			return entries, nil
+		} else if err != nil {
+			panic(err)
+		}
+
+		args = append(args, tagHash(key, *tag))
+		query += fmt.Sprintf(" AND id IN (SELECT et.entry_id FROM entry_tags et JOIN tags t ON t.id = et.tag_id WHERE t.user_id = $1 AND t.name_hash = $%d)", len(args))
+	}
+
+	if kind != nil {
+		args = append(args, kind)
+		query += fmt.Sprintf(" AND kind = $%d", len(args))
+	}
+
+	res := wrabitDB.LogAndQuery(r.db, query+" ORDER BY created_at DESC", args...)
+
 	defer res.Close()
 	for res.Next() {
 		var entry = new(models.Entry)
-		if err := res.Scan(&entry.ID, &entry.UserID, &entry.WordCount, &entry.Content, &entry.CreatedAt, &entry.UpdatedAt, &entry.GoalHit); err != nil {
+		if err := scanEntry(res, entry); err != nil {
 			panic(err)
 		}
 
-		decodedContent, err := hex.DecodeString(entry.Content)
-		content, err := cryptopasta.Decrypt(decodedContent, &key)
-		if err == nil {
-			entry.Content = string(content)
-		}
-
 		entries = append(entries, entry)
 	}
 
+	r.openEntries(entries...)
+
 	return entries, nil
 }
 
@@ -105,53 +136,123 @@ func (r *queryResolver) DailyEntry(ctx context.Context, userID string, date stri
 		return &models.Entry{}, fmt.Errorf("Access denied")
 	}
 
-	key := [32]byte{}
-	keyString := os.Getenv("ENCRYPTION_KEY")
-	copy(key[:], keyString)
+	// Daily entries are only created under the user's lock so two devices can't both create one
+	tx, err := r.db.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := streaks.Lock(tx, userID); err != nil {
+		panic(err)
+	}
 
-	res := wrabitDB.LogAndQueryRow(r.db, "SELECT * FROM entries WHERE user_id = $1 AND created_at >= $2 ORDER BY created_at DESC", userID, date)
+	// Other kinds of entries can be written on the same day but there is only one daily entry
+	res := wrabitDB.LogAndQueryRowTx(tx, "SELECT "+entryColumns+" FROM entries WHERE user_id = $1 AND kind = 'DAILY' AND created_at >= $2 AND deleted_at IS NULL ORDER BY created_at DESC", userID, date)
 
 	var entry = new(models.Entry)
-	err := res.Scan(&entry.ID, &entry.UserID, &entry.WordCount, &entry.Content, &entry.CreatedAt, &entry.UpdatedAt, &entry.GoalHit)
+	err = scanEntry(res, entry)
 	if err != nil && err != sql.ErrNoRows {
 		panic(err)
 	}
 
 	if err == sql.ErrNoRows {
-		res := wrabitDB.LogAndQueryRow(r.db, "INSERT INTO entries (user_id, content, word_count, created_at) VALUES ($1, $2, $3, $4) RETURNING id", userID, "", 0, date)
-		if err := res.Scan(&entry.ID); err != nil {
+		entry.Kind = models.EntryKindDaily
+		res := wrabitDB.LogAndQueryRowTx(tx, "INSERT INTO entries (user_id, content, word_count, created_at, kind) VALUES ($1, $2, $3, $4, $5) RETURNING id, version", userID, "", 0, date, entry.Kind)
+		if err := res.Scan(&entry.ID, &entry.Version); err != nil {
 			panic(err)
 		}
-	} else {
-		decodedContent, err := hex.DecodeString(entry.Content)
-		content, err := cryptopasta.Decrypt(decodedContent, &key)
-		if err == nil {
-			entry.Content = string(content)
-		}
+
+		commit(tx)
+		return entry, nil
 	}
 
+	commit(tx)
+	r.openEntries(entry)
+
 	return entry, nil
 }
 
 func (r *mutationResolver) CreateEntry(ctx context.Context, input models.NewEntry) (*models.Entry, error) {
-	if user := auth.ForContext(ctx); user == nil {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != input.UserID {
 		return &models.Entry{}, fmt.Errorf("Access denied")
 	}
 
 	entry := &models.Entry{
-		ID:        "",
-		UserID:    input.UserID,
-		Content:   input.Content,
-		WordCount: input.WordCount,
+		ID:              "",
+		UserID:          input.UserID,
+		Kind:            models.EntryKindDaily,
+		Title:           input.Title,
+		Content:         input.Content,
+		WordCount:       input.WordCount,
+		ClientEncrypted: input.ClientEncrypted != nil && *input.ClientEncrypted,
 	}
 
-	res := wrabitDB.LogAndExec(r.db, "INSERT INTO entries (user_id, content, word_count) VALUES ($1, $2, $3) RETURNING id", entry.UserID, entry.Content, entry.WordCount)
-	id, err := res.LastInsertId()
+	if input.Kind != nil {
+		entry.Kind = *input.Kind
+	}
+
+	if entry.Kind == models.EntryKindDaily && entry.Title != nil {
+		return &models.Entry{}, fmt.Errorf("Daily entries can't have a title")
+	}
+
+	var clientEncryption bool
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT client_encryption FROM users WHERE firebase_id = $1", entry.UserID)
+	if err := res.Scan(&clientEncryption); err != nil {
+		panic(err)
+	}
+
+	// Never accept plaintext from a user who opted into client encryption
+	if clientEncryption && !entry.ClientEncrypted {
+		return &models.Entry{}, fmt.Errorf("Entry must be encrypted before saving")
+	}
+
+	tx, err := r.db.Begin()
 	if err != nil {
 		panic(err)
 	}
+	defer tx.Rollback()
 
-	entry.ID = strconv.FormatInt(id, 10)
+	// There is only one daily entry per local day. Checking for it under the
+	// user's lock means a second device gets the entry the first one created.
+	if entry.Kind == models.EntryKindDaily {
+		timezone, err := streaks.Lock(tx, entry.UserID)
+		if err != nil {
+			panic(err)
+		}
+
+		day := streaks.Day(time.Now(), reminders.Location(timezone))
+		res = wrabitDB.LogAndQueryRowTx(tx, "SELECT "+entryColumns+" FROM entries WHERE user_id = $1 AND kind = 'DAILY' AND deleted_at IS NULL AND created_at >= $2 AND created_at < $3 ORDER BY created_at DESC LIMIT 1", entry.UserID, day.UTC(), day.AddDate(0, 0, 1).UTC())
+
+		var existing = new(models.Entry)
+		err = scanEntry(res, existing)
+		if err == nil {
+			commit(tx)
+			r.openEntries(existing)
+			return existing, nil
+		} else if err != sql.ErrNoRows {
+			panic(err)
+		}
+	}
+
+	content, title := input.Content, input.Title
+	var analysis string
+	if !entry.ClientEncrypted {
+		var key *[32]byte
+		content, title, key = r.sealEntry(entry, input.Content, input.Title)
+		analysis = keystore.Seal(analyze(input.Content), key)
+	}
+
+	res = wrabitDB.LogAndQueryRowTx(tx, "INSERT INTO entries (user_id, content, word_count, kind, title, key_id, client_encrypted) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, version, created_at, updated_at", entry.UserID, content, entry.WordCount, entry.Kind, title, entry.KeyID, entry.ClientEncrypted)
+	if err := res.Scan(&entry.ID, &entry.Version, &entry.CreatedAt, &entry.UpdatedAt); err != nil {
+		panic(err)
+	}
+
+	commit(tx)
+
+	stats.Refresh(r.db, entry.UserID, entry.CreatedAt)
+	r.saveInsights(entry, analysis)
 
 	return entry, nil
 }
@@ -162,61 +263,121 @@ func (r *mutationResolver) UpdateEntry(ctx context.Context, id string, input mod
 		return &models.Entry{}, fmt.Errorf("Access denied")
 	}
 
-	key := [32]byte{}
-	keyString := os.Getenv("ENCRYPTION_KEY")
-	copy(key[:], keyString)
+	entry := &models.Entry{
+		ID:              id,
+		UserID:          input.UserID,
+		Title:           input.Title,
+		Content:         input.Content,
+		WordCount:       input.WordCount,
+		GoalHit:         input.GoalHit,
+		ClientEncrypted: input.ClientEncrypted != nil && *input.ClientEncrypted,
+	}
 
-	// Encrypt the content for the database
-	// but return the unencrypted content to the client
-	content, err := cryptopasta.Encrypt([]byte(input.Content), &key)
-	if err != nil {
+	var clientEncryption, wasHit bool
+	var analyzed analyzedInsights
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT e.key_id, e.kind, e.goal_hit, u.client_encryption, i.word_count, i.key_id FROM entries e JOIN users u ON u.firebase_id = e.user_id LEFT JOIN entry_insights i ON i.entry_id = e.id WHERE e.id = $1 AND e.user_id = $2 AND e.deleted_at IS NULL", entry.ID, entry.UserID)
+	if err := res.Scan(&entry.KeyID, &entry.Kind, &wasHit, &clientEncryption, &analyzed.wordCount, &analyzed.keyID); err != nil {
 		panic(err)
 	}
 
-	entry := &models.Entry{
-		ID:        id,
-		UserID:    input.UserID,
-		Content:   input.Content,
-		WordCount: input.WordCount,
-		GoalHit:   input.GoalHit,
+	// Only daily entries count toward goals and streaks
+	if entry.Kind != models.EntryKindDaily {
+		entry.GoalHit = false
+	} else if entry.Title != nil {
+		return &models.Entry{}, fmt.Errorf("Daily entries can't have a title")
 	}
 
-	res := wrabitDB.LogAndQueryRow(r.db, "UPDATE entries SET content = $1, word_count = $2, goal_hit = $3 WHERE id = $4 AND user_id = $5 RETURNING id", hex.EncodeToString(content), entry.WordCount, entry.GoalHit, entry.ID, entry.UserID)
-	if err := res.Scan(&entry.ID); err != nil {
-		panic(err)
+	// Never accept plaintext from a user who opted into client encryption
+	if clientEncryption && !entry.ClientEncrypted {
+		return &models.Entry{}, fmt.Errorf("Entry must be encrypted before saving")
 	}
 
-	// TODO: Move this logic into a sane place
-	if input.GoalHit {
-		// Get the latest streak for the user
-		res := wrabitDB.LogAndQueryRow(r.db, "SELECT ID, user_id, day_count, last_entry_id FROM streaks WHERE user_id = $1 AND updated_at >= $2::timestamp - INTERVAL '1 DAY' ORDER BY created_at DESC LIMIT 1", entry.UserID, date)
+	var content, analysis string
+	var title *string
+	var key *[32]byte
+	var oldKeyID *string
+	for {
+		readKeyID := entry.KeyID
+		if entry.ClientEncrypted {
+			// The client holds the key so there is nothing for the server to keep.
+			// The old key is only destroyed once the entry no longer needs it.
+			oldKeyID, entry.KeyID = entry.KeyID, nil
+
+			content, title = input.Content, input.Title
+		} else {
+			// Encrypt the content for the database
+			// but return the unencrypted content to the client
+			content, title, key = r.sealEntry(entry, input.Content, input.Title)
+		}
 
-		var streak = new(models.Streak)
-		err := res.Scan(&streak.ID, &streak.UserID, &streak.DayCount, &streak.LastEntryID)
-		if err != nil && err != sql.ErrNoRows {
+		// Only write over the version the client last saw so concurrent
+		// devices can't silently clobber each other, and only swap the key
+		// if no other save has swapped it first
+		res = wrabitDB.LogAndQueryRow(r.db, "UPDATE entries SET content = $1, word_count = $2, goal_hit = $3, key_id = $4, client_encrypted = $5, title = $6, version = version + 1, started_at = COALESCE(started_at, CASE WHEN $2 > 0 THEN NOW() END), goal_hit_at = CASE WHEN $3 THEN COALESCE(goal_hit_at, NOW()) END WHERE id = $7 AND user_id = $8 AND deleted_at IS NULL AND ($9::int IS NULL OR version = $9) AND key_id IS NOT DISTINCT FROM $10::int RETURNING id, version, created_at, updated_at", content, entry.WordCount, entry.GoalHit, entry.KeyID, entry.ClientEncrypted, title, entry.ID, entry.UserID, input.Version, readKeyID)
+		err := res.Scan(&entry.ID, &entry.Version, &entry.CreatedAt, &entry.UpdatedAt)
+		if err == nil {
+			break
+		} else if err != sql.ErrNoRows {
 			panic(err)
 		}
-		newStreakCount := streak.DayCount + 1
 
-		// If no streak exists, create one
-		if err == sql.ErrNoRows {
-			res := wrabitDB.LogAndQueryRow(r.db, "INSERT INTO streaks (user_id, day_count, last_entry_id) VALUES ($1, $2, $3) RETURNING id", entry.UserID, 1, entry.ID)
-			if err := res.Scan(&entry.ID); err != nil {
-				panic(err)
-			}
-		} else if streak != nil && streak.LastEntryID != entry.ID {
-			res := wrabitDB.LogAndQueryRow(r.db, "UPDATE streaks SET last_entry_id = $1, day_count = $2 WHERE id = $3 AND user_id = $4 RETURNING id", entry.ID, newStreakCount, streak.ID, entry.UserID)
-			if err := res.Scan(&entry.ID); err != nil {
-				panic(err)
-			}
+		// A key made for this save was never used
+		if entry.KeyID != nil && (readKeyID == nil || *readKeyID != *entry.KeyID) {
+			r.keys.Destroy(*entry.KeyID, "unused")
 		}
 
+		if input.Version != nil {
+			return r.entryConflict(entry.ID, entry.UserID)
+		}
+
+		// Without a version to check, seal it again with the key the other save left
+		res = wrabitDB.LogAndQueryRow(r.db, "SELECT key_id FROM entries WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL", entry.ID, entry.UserID)
+		if err := res.Scan(&entry.KeyID); err != nil {
+			panic(err)
+		}
+	}
+
+	if oldKeyID != nil {
+		r.keys.Destroy(*oldKeyID, "entry moved to client encryption")
+	}
+
+	// Saves come in as the user types, only a real change is worth analysing again
+	if !entry.ClientEncrypted && analyzed.stale(entry) {
+		analysis = keystore.Seal(analyze(input.Content), key)
+	}
+
+	stats.Refresh(r.db, entry.UserID, entry.CreatedAt)
+	r.saveInsights(entry, analysis)
+
+	// Let the user's other devices know about the new content
+	published := *entry
+	r.broker.Publish(&published)
+
+	newStreakCount, extended := 0, false
+	if entry.GoalHit {
+		newStreakCount, extended = r.extendStreak(entry, date)
+	} else if wasHit {
+		// Taking back a goal takes back the streak day and donation it earned.
+		// The save stands if that fails, `admin streaks recompute` catches up.
+		if err := r.takeBackGoal(entry.UserID, entry.ID); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	go r.evaluateAchievements(entry.UserID, entry.ID, newStreakCount)
+
+	if entry.GoalHit {
 		// The streak is not valid for a donation
 		// return early to save network/DB calls
-		if newStreakCount%7 != 0 {
+		if newStreakCount == 0 || newStreakCount%7 != 0 {
 			return entry, nil
 		}
 
+		// Celebrate the milestone the first time the streak reaches it
+		if extended {
+			go r.celebrateStreak(entry.UserID, newStreakCount)
+		}
+
 		// Add donation if sequired
 		res = wrabitDB.LogAndQueryRow(r.db, "SELECT stripe_subscription_id FROM users WHERE firebase_id = $1", entry.UserID)
 
@@ -255,17 +416,23 @@ func (r *mutationResolver) UpdateEntry(ctx context.Context, id string, input mod
 		}
 
 		// Check to see if a donation has been made for the specific entry
-		res = wrabitDB.LogAndQueryRow(r.db, "SELECT id FROM donations WHERE user_id = $1 AND entry_id = $2 LIMIT 1", entry.UserID, entry.ID)
+		res = wrabitDB.LogAndQueryRow(r.db, "SELECT id, voided_at IS NOT NULL FROM donations WHERE user_id = $1 AND entry_id = $2 LIMIT 1", entry.UserID, entry.ID)
 		var donation = &models.Donation{}
-		err = res.Scan(&donation.ID)
+		var voided bool
+		err = res.Scan(&donation.ID, &voided)
 		if err != nil && err != sql.ErrNoRows {
 			panic(err)
 		}
 
+		// The goal was taken back and has been hit again
+		if voided {
+			wrabitDB.LogAndExec(r.db, "UPDATE donations SET voided_at = NULL WHERE id = $1", donation.ID)
+		}
+
 		// No donation has been made
 		if err == sql.ErrNoRows {
 			res = wrabitDB.LogAndQueryRow(r.db, "INSERT INTO donations (user_id, amount, entry_id) VALUES ($1, $2, $3) RETURNING id", entry.UserID, 1, entry.ID)
-			if err := res.Scan(&entry.ID); err != nil {
+			if err := res.Scan(&donation.ID); err != nil {
 				fmt.Println(err)
 				return entry, nil
 			}
@@ -275,23 +442,290 @@ func (r *mutationResolver) UpdateEntry(ctx context.Context, id string, input mod
 	return entry, nil
 }
 
+// DeleteEntry moves an entry to the trash, where it can be restored until it is purged
 func (r *mutationResolver) DeleteEntry(ctx context.Context, id string) (*models.Entry, error) {
 	user := auth.ForContext(ctx)
-	fmt.Println(user)
 	if user == nil {
 		return &models.Entry{}, fmt.Errorf("Access denied")
 	}
 
+	tx, err := r.db.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	// Saves on other devices wait until the streaks have been unwound
+	if _, err := streaks.Lock(tx, user.Subject); err != nil {
+		panic(err)
+	}
+
 	var entry = &models.Entry{}
-	res := wrabitDB.LogAndExec(r.db, "DELETE FROM entries WHERE user_id = $1 AND id = $2", user.Subject, id)
-	count, err := res.RowsAffected()
-	if err == nil && count == 1 {
-		entry.ID = id
+	res := wrabitDB.LogAndQueryRowTx(tx, "UPDATE entries SET deleted_at = NOW() WHERE user_id = $1 AND id = $2 AND deleted_at IS NULL RETURNING id, goal_hit, created_at, deleted_at", user.Subject, id)
+	err = res.Scan(&entry.ID, &entry.GoalHit, &entry.CreatedAt, &entry.DeletedAt)
+	if err == sql.ErrNoRows {
+		return entry, nil
+	} else if err != nil {
+		panic(err)
+	}
+
+	if entry.GoalHit {
+		if err := unwindGoal(tx, user.Subject, entry.ID); err != nil {
+			fmt.Println(err)
+			return &models.Entry{}, fmt.Errorf("Entry couldn't be deleted, try again")
+		}
+	}
+
+	commit(tx)
+
+	stats.Refresh(r.db, user.Subject, entry.CreatedAt)
+
+	return entry, nil
+}
+
+func (r *mutationResolver) RestoreEntry(ctx context.Context, id string) (*models.Entry, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return &models.Entry{}, fmt.Errorf("Access denied")
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	timezone, err := streaks.Lock(tx, user.Subject)
+	if err != nil {
+		panic(err)
+	}
+
+	var entry = new(models.Entry)
+	res := wrabitDB.LogAndQueryRowTx(tx, "SELECT "+entryColumns+", deleted_at FROM entries WHERE id = $1 AND user_id = $2 AND deleted_at > $3 FOR UPDATE", id, user.Subject, time.Now().Add(-trash.Retention))
+	err = res.Scan(append(entryFields(entry), &entry.DeletedAt)...)
+	if err == sql.ErrNoRows {
+		return &models.Entry{}, fmt.Errorf("Entry not found in the trash")
+	} else if err != nil {
+		panic(err)
+	}
+
+	// There is only one daily entry a day. One opened since the deletion
+	// can make way as long as nothing was written in it.
+	if entry.Kind == models.EntryKindDaily {
+		if err := r.clearDailyEntry(tx, entry, reminders.Location(timezone)); err != nil {
+			return &models.Entry{}, err
+		}
+	}
+
+	if _, err := wrabitDB.LogAndExecTx(tx, "UPDATE entries SET deleted_at = NULL WHERE id = $1 AND user_id = $2", entry.ID, user.Subject); err != nil {
+		panic(err)
+	}
+
+	if entry.GoalHit {
+		// Bring back the donations the deletion voided, it voided them at the same time
+		if _, err := wrabitDB.LogAndExecTx(tx, "UPDATE donations SET voided_at = NULL WHERE user_id = $1 AND entry_id = $2 AND voided_at = $3", user.Subject, entry.ID, entry.DeletedAt); err != nil {
+			panic(err)
+		}
+
+		if _, err := streaks.RecomputeTx(tx, user.Subject, true); err != nil {
+			fmt.Println(err)
+			return &models.Entry{}, fmt.Errorf("Entry couldn't be restored, try again")
+		}
 	}
 
+	commit(tx)
+	entry.DeletedAt = nil
+
+	stats.Refresh(r.db, user.Subject, entry.CreatedAt)
+
+	r.openEntries(entry)
+
 	return entry, nil
 }
 
+// clearDailyEntry makes way for a daily entry being restored by erasing an
+// empty one opened on the same day, the same way the trash is purged.
+// It fails if something has been written for that day since.
+func (r *mutationResolver) clearDailyEntry(tx *sql.Tx, entry *models.Entry, location *time.Location) error {
+	createdAt, err := time.Parse(time.RFC3339Nano, entry.CreatedAt)
+	if err != nil {
+		panic(err)
+	}
+
+	day := streaks.Day(createdAt, location)
+
+	rows, err := wrabitDB.LogAndQueryTx(tx, "SELECT id, key_id, word_count FROM entries WHERE user_id = $1 AND kind = 'DAILY' AND deleted_at IS NULL AND created_at >= $2 AND created_at < $3 FOR UPDATE", entry.UserID, day.UTC(), day.AddDate(0, 0, 1).UTC())
+	if err != nil {
+		panic(err)
+	}
+
+	var ids, keyIDs []string
+	written := false
+	for rows.Next() {
+		var id string
+		var keyID sql.NullString
+		var wordCount int
+		if err := rows.Scan(&id, &keyID, &wordCount); err != nil {
+			rows.Close()
+			panic(err)
+		}
+
+		written = written || wordCount > 0
+		ids = append(ids, id)
+		if keyID.Valid {
+			keyIDs = append(keyIDs, keyID.String)
+		}
+	}
+	rows.Close()
+
+	if written {
+		return fmt.Errorf("Another entry has been written for that day")
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := trash.Erase(tx, r.keys, ids, keyIDs, "empty entry replaced by a restored one"); err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+func (r *queryResolver) TrashedEntries(ctx context.Context, userID string) ([]*models.Entry, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return []*models.Entry{}, fmt.Errorf("Access denied")
+	}
+
+	entries := []*models.Entry{}
+
+	res := wrabitDB.LogAndQuery(r.db, "SELECT "+entryColumns+", deleted_at FROM entries WHERE user_id = $1 AND deleted_at > $2 ORDER BY deleted_at DESC", userID, time.Now().Add(-trash.Retention))
+	defer res.Close()
+	for res.Next() {
+		var entry = new(models.Entry)
+		if err := res.Scan(append(entryFields(entry), &entry.DeletedAt)...); err != nil {
+			panic(err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	r.openEntries(entries...)
+
+	return entries, nil
+}
+
+// takeBackGoal unwinds the goal an entry hit before it was saved short of it
+func (r *mutationResolver) takeBackGoal(userID string, entryID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := unwindGoal(tx, userID, entryID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// unwindGoal takes back what hitting the goal with an entry earned once the
+// entry is deleted or falls short of the goal. Paid donations are kept.
+// The streaks are rebuilt under the user's lock as part of tx.
+func unwindGoal(tx *sql.Tx, userID string, entryID string) error {
+	if _, err := wrabitDB.LogAndExecTx(tx, "UPDATE donations SET voided_at = NOW() WHERE user_id = $1 AND entry_id = $2 AND paid IS NOT TRUE AND voided_at IS NULL", userID, entryID); err != nil {
+		return err
+	}
+
+	_, err := streaks.RecomputeTx(tx, userID, true)
+
+	return err
+}
+
+func (r *mutationResolver) celebrateStreak(userID string, dayCount int) {
+	err := r.push.Notify(context.Background(), userID, push.Message{
+		Title: "Streak milestone! 🎉",
+		Body:  fmt.Sprintf("You've hit your goal %d days in a row.", dayCount),
+		URL:   "/",
+	})
+	if err != nil && err != push.ErrNotConfigured {
+		fmt.Println(err)
+	}
+}
+
+// evaluateAchievements awards anything the saved entry earned
+func (r *mutationResolver) evaluateAchievements(userID string, entryID string, streakLength int) {
+	if _, err := r.achievements.Evaluate(context.Background(), userID, entryID, streakLength); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// entryConflict builds the error returned when an update was based on a stale version.
+// The server's copy is included so the client can merge.
+func (r *mutationResolver) entryConflict(id string, userID string) (*models.Entry, error) {
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT "+entryColumns+" FROM entries WHERE id = $1 AND user_id = $2", id, userID)
+
+	var entry = new(models.Entry)
+	if err := scanEntry(res, entry); err != nil {
+		panic(err)
+	}
+
+	r.openEntries(entry)
+
+	return &models.Entry{}, &gqlerror.Error{
+		Message: "Entry has been updated on another device",
+		Extensions: map[string]interface{}{
+			"code":  "CONFLICT",
+			"entry": entry,
+		},
+	}
+}
+
+type subscriptionResolver struct{ *Resolver }
+
+func (r *subscriptionResolver) EntryUpdated(ctx context.Context, entryID string) (<-chan *models.Entry, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, fmt.Errorf("Access denied")
+	}
+
+	var userID string
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT user_id FROM entries WHERE id = $1", entryID)
+	err := res.Scan(&userID)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	if err == sql.ErrNoRows || userID != user.Subject {
+		return nil, fmt.Errorf("Access denied")
+	}
+
+	updates := r.broker.Subscribe(ctx, entryID)
+
+	// The token was only checked when the socket opened, stop sending once it
+	// has expired. API tokens without an expiry are left at zero.
+	live := make(chan *models.Entry, 1)
+	go func() {
+		defer close(live)
+
+		for entry := range updates {
+			if user.Expires != 0 && time.Now().Unix() >= user.Expires {
+				return
+			}
+
+			select {
+			case live <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return live, nil
+}
+
 type entryResolver struct{ *Resolver }
 
 func (r *entryResolver) User(ctx context.Context, obj *models.Entry) (*models.User, error) {
@@ -2,13 +2,19 @@ package resolvers
 
 import (
 	"context"
+	"encoding/hex"
 	"testing"
+	"time"
 
 	firebase "firebase.google.com/go/auth"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/gqlerror"
 	"github.com/writewithwrabit/server/auth"
+	cryptopasta "github.com/writewithwrabit/server/cryptopasta"
+	"github.com/writewithwrabit/server/keystore"
 	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/pubsub"
 )
 
 func TestDeleteEntryWithoutUser(t *testing.T) {
@@ -19,7 +25,8 @@ func TestDeleteEntryWithoutUser(t *testing.T) {
 	defer db.Close()
 
 	resolver := &Resolver{
-		db: db,
+		db:   db,
+		keys: keystore.New(db, ""),
 	}
 	mutResolver := &mutationResolver{
 		Resolver: resolver,
@@ -42,7 +49,8 @@ func TestDeleteEntry(t *testing.T) {
 	defer db.Close()
 
 	resolver := &Resolver{
-		db: db,
+		db:   db,
+		keys: keystore.New(db, ""),
 	}
 	mutResolver := &mutationResolver{
 		Resolver: resolver,
@@ -55,13 +63,28 @@ func TestDeleteEntry(t *testing.T) {
 	c := context.Background()
 	ctx := context.WithValue(c, auth.UserCtxKey, token)
 
-	result := sqlmock.NewResult(1, 1)
-	mock.ExpectExec("DELETE FROM entries WHERE user_id \\= \\$1 AND id \\= \\$2").
-		WithArgs("abcdefg", "1").WillReturnResult(result)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT timezone FROM users WHERE firebase_id \\= \\$1 FOR UPDATE").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone"}).AddRow("UTC"))
+	mock.ExpectQuery("UPDATE entries SET deleted_at \\= NOW\\(\\) WHERE user_id \\= \\$1 AND id \\= \\$2 AND deleted_at IS NULL").
+		WithArgs("abcdefg", "1").WillReturnRows(sqlmock.NewRows([]string{"id", "goal_hit", "created_at", "deleted_at"}).AddRow("1", true, "2020-01-01T00:00:00Z", "2020-01-02T00:00:00Z"))
+
+	// The goal it hit no longer counts
+	mock.ExpectExec("UPDATE donations SET voided_at \\= NOW\\(\\) WHERE user_id \\= \\$1 AND entry_id \\= \\$2 AND paid IS NOT TRUE").
+		WithArgs("abcdefg", "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	expectStreakRebuild(mock, 1)
+	mock.ExpectExec("DELETE FROM frozen_days").WithArgs("abcdefg").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM streaks").WithArgs("abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET streak_freezes").WithArgs(0, "abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectExec("INSERT INTO daily_user_stats").
+		WithArgs("abcdefg", "2020-01-01T00:00:00Z").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	res, err := mutResolver.DeleteEntry(ctx, "1")
 
 	assert.Equal(t, res.ID, "1")
+	assert.Equal(t, "2020-01-02T00:00:00Z", *res.DeletedAt)
 	assert.Empty(t, err)
 
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -69,6 +92,177 @@ func TestDeleteEntry(t *testing.T) {
 	}
 }
 
+// expectStreakRebuild has a user with no goals hit left but a saved streak of the given length
+func expectStreakRebuild(mock sqlmock.Sqlmock, dayCount int) {
+	mock.ExpectQuery("SELECT timezone FROM users WHERE firebase_id \\= \\$1 FOR UPDATE").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone"}).AddRow("UTC"))
+	mock.ExpectQuery("SELECT rest_days, (.+) FROM users").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"rest_days", "today"}).AddRow("{}", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)))
+	mock.ExpectQuery("SELECT e.id, (.+) FROM entries e").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"id", "day"}))
+	mock.ExpectQuery("SELECT streak_freezes FROM users").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"streak_freezes"}).AddRow(0))
+	mock.ExpectQuery("SELECT s.id, (.+) FROM streaks s").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"id", "day_count", "last_entry_id", "start", "end"}).
+		AddRow("3", dayCount, "1", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	mock.ExpectQuery("SELECT streak_id, day FROM frozen_days").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"streak_id", "day"}))
+}
+
+// expectTrashedEntry locks the user and finds their entry in the trash
+func expectTrashedEntry(mock sqlmock.Sqlmock, timezone string, goalHit bool) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT timezone FROM users WHERE firebase_id \\= \\$1 FOR UPDATE").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone"}).AddRow(timezone))
+
+	rows := sqlmock.NewRows([]string{"id", "user_id", "word_count", "content", "created_at", "updated_at", "goal_hit", "version", "key_id", "client_encrypted", "kind", "title", "deleted_at"}).
+		AddRow("1", "abcdefg", 20, "Dear diary", "2020-01-01T05:00:00Z", "2020-01-01T05:00:00Z", goalHit, 3, nil, false, "DAILY", nil, "2020-01-02T00:00:00Z")
+	mock.ExpectQuery("SELECT (.+), deleted_at FROM entries WHERE id \\= \\$1 AND user_id \\= \\$2 AND deleted_at > \\$3 FOR UPDATE").
+		WithArgs("1", "abcdefg", sqlmock.AnyArg()).WillReturnRows(rows)
+}
+
+func TestRestoreEntryMakesWayForEmptyDailyEntry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	keysDB, keysMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer keysDB.Close()
+
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(keysDB, "")},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	expectTrashedEntry(mock, "America/Toronto", false)
+
+	toronto, _ := time.LoadLocation("America/Toronto")
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, toronto)
+
+	mock.ExpectQuery("SELECT id, key_id, word_count FROM entries WHERE user_id \\= \\$1 AND kind \\= 'DAILY' AND deleted_at IS NULL (.+) FOR UPDATE").
+		WithArgs("abcdefg", day.UTC(), day.AddDate(0, 0, 1).UTC()).WillReturnRows(sqlmock.NewRows([]string{"id", "key_id", "word_count"}).AddRow("2", "7", 0))
+
+	// The empty entry is erased along with its key, tags, insight and links
+	mock.ExpectExec("UPDATE check_ins SET entry_id \\= NULL").WithArgs("{\"2\"}").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE achievements SET entry_id \\= NULL").WithArgs("{\"2\"}").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE donations SET entry_id \\= NULL").WithArgs("{\"2\"}").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM entry_tags").WithArgs("{\"2\"}").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM entry_insights").WithArgs("{\"2\"}").WillReturnResult(sqlmock.NewResult(0, 1))
+	keysMock.ExpectBegin()
+	keysMock.ExpectQuery("UPDATE encryption_keys SET wrapped_key \\= NULL").WithArgs("{\"7\"}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("7", "abcdefg"))
+	keysMock.ExpectExec("INSERT INTO key_audit").WithArgs("7", "abcdefg", "empty entry replaced by a restored one").WillReturnResult(sqlmock.NewResult(1, 1))
+	keysMock.ExpectCommit()
+	mock.ExpectExec("DELETE FROM entries WHERE id \\= ANY").WithArgs("{\"2\"}").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectExec("UPDATE entries SET deleted_at \\= NULL WHERE id \\= \\$1 AND user_id \\= \\$2").
+		WithArgs("1", "abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("INSERT INTO daily_user_stats").
+		WithArgs("abcdefg", "2020-01-01T05:00:00Z").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	res, err := mutResolver.RestoreEntry(ctx, "1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Dear diary", res.Content)
+	assert.Nil(t, res.DeletedAt)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+	if err := keysMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled key expectations: %s", err)
+	}
+}
+
+func TestRestoreEntryKeepsWrittenDailyEntry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(db, "")},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	expectTrashedEntry(mock, "UTC", true)
+	mock.ExpectQuery("SELECT id, key_id, word_count FROM entries").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "key_id", "word_count"}).AddRow("2", "7", 0).AddRow("3", "8", 40))
+	mock.ExpectRollback()
+
+	_, err = mutResolver.RestoreEntry(ctx, "1")
+
+	assert.Equal(t, "Another entry has been written for that day", err.Error())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRestoreEntryBringsBackVoidedDonation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(db, "")},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	expectTrashedEntry(mock, "UTC", true)
+	mock.ExpectQuery("SELECT id, key_id, word_count FROM entries").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "key_id", "word_count"}))
+	mock.ExpectExec("UPDATE entries SET deleted_at \\= NULL").
+		WithArgs("1", "abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Only the donation voided when the entry was deleted
+	mock.ExpectExec("UPDATE donations SET voided_at \\= NULL WHERE user_id \\= \\$1 AND entry_id \\= \\$2 AND voided_at \\= \\$3").
+		WithArgs("abcdefg", "1", "2020-01-02T00:00:00Z").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// The goal counts again
+	mock.ExpectQuery("SELECT timezone FROM users WHERE firebase_id \\= \\$1 FOR UPDATE").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone"}).AddRow("UTC"))
+	mock.ExpectQuery("SELECT rest_days, (.+) FROM users").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"rest_days", "today"}).AddRow("{}", time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)))
+	mock.ExpectQuery("SELECT e.id, (.+) FROM entries e").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"id", "day"}).AddRow("1", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	mock.ExpectQuery("SELECT streak_freezes FROM users").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"streak_freezes"}).AddRow(0))
+	mock.ExpectQuery("SELECT s.id, (.+) FROM streaks s").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"id", "day_count", "last_entry_id", "start", "end"}))
+	mock.ExpectQuery("SELECT streak_id, day FROM frozen_days").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"streak_id", "day"}))
+	mock.ExpectExec("DELETE FROM frozen_days").WithArgs("abcdefg").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM streaks").WithArgs("abcdefg").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO streaks").
+		WithArgs("abcdefg", 1, "1", "2020-01-01", "2020-01-01", "UTC").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("4"))
+	mock.ExpectExec("UPDATE users SET streak_freezes").WithArgs(0, "abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectExec("INSERT INTO daily_user_stats").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	res, err := mutResolver.RestoreEntry(ctx, "1")
+
+	assert.Nil(t, err)
+	assert.True(t, res.GoalHit)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestCreateEntry(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -77,7 +271,8 @@ func TestCreateEntry(t *testing.T) {
 	defer db.Close()
 
 	resolver := &Resolver{
-		db: db,
+		db:   db,
+		keys: keystore.New(db, ""),
 	}
 	mutResolver := &mutationResolver{
 		Resolver: resolver,
@@ -90,9 +285,23 @@ func TestCreateEntry(t *testing.T) {
 	c := context.Background()
 	ctx := context.WithValue(c, auth.UserCtxKey, token)
 
-	result := sqlmock.NewResult(1, 1)
-	mock.ExpectExec("INSERT INTO entries \\(user_id, content, word_count\\) VALUES \\(\\$1, \\$2, \\$3\\) RETURNING id").
-		WithArgs("abcdefg", "a great entry", 1000).WillReturnResult(result)
+	mock.ExpectQuery("SELECT client_encryption FROM users WHERE firebase_id \\= \\$1").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"client_encryption"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT timezone FROM users WHERE firebase_id \\= \\$1 FOR UPDATE").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone"}).AddRow("UTC"))
+	mock.ExpectQuery("SELECT (.+) FROM entries WHERE user_id \\= \\$1 AND kind \\= 'DAILY' AND deleted_at IS NULL AND created_at >= \\$2 AND created_at < \\$3").
+		WithArgs("abcdefg", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("INSERT INTO encryption_keys \\(user_id, wrapped_key\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("5"))
+	mock.ExpectQuery("INSERT INTO entries \\(user_id, content, word_count, kind, title, key_id, client_encrypted\\) VALUES \\(\\$1, \\$2, \\$3, \\$4, \\$5, \\$6, \\$7\\) RETURNING id").
+		WithArgs("abcdefg", sqlmock.AnyArg(), 1000, models.EntryKindDaily, nil, "5", false).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "created_at", "updated_at"}).AddRow("1", 1, "2020-01-01", "2020-01-01"))
+	mock.ExpectCommit()
+	mock.ExpectExec("INSERT INTO daily_user_stats").
+		WithArgs("abcdefg", "2020-01-01").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO entry_insights \\(entry_id, user_id, analysis, key_id, word_count\\)").
+		WithArgs("1", "abcdefg", sqlmock.AnyArg(), "5", 1000).WillReturnResult(sqlmock.NewResult(0, 1))
 
 	var entry = models.NewEntry{
 		UserID:    "abcdefg",
@@ -103,9 +312,325 @@ func TestCreateEntry(t *testing.T) {
 	res, err := mutResolver.CreateEntry(ctx, entry)
 
 	assert.Equal(t, res.ID, "1")
+	assert.Equal(t, "a great entry", res.Content)
+	assert.Equal(t, models.EntryKindDaily, res.Kind)
 	assert.Empty(t, err)
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+func TestCreateEntryReturnsTodaysDailyEntry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(db, "")},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	mock.ExpectQuery("SELECT client_encryption FROM users WHERE firebase_id \\= \\$1").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"client_encryption"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT timezone FROM users WHERE firebase_id \\= \\$1 FOR UPDATE").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone"}).AddRow("America/Toronto"))
+
+	// The day starts at midnight where the user is
+	toronto, _ := time.LoadLocation("America/Toronto")
+	now := time.Now().In(toronto)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, toronto)
+
+	// Another device created it first
+	rows := sqlmock.NewRows([]string{"id", "user_id", "word_count", "content", "created_at", "updated_at", "goal_hit", "version", "key_id", "client_encrypted", "kind", "title"}).
+		AddRow("1", "abcdefg", 20, "ciphertext", "2020-01-01", "2020-01-01", false, 2, nil, true, "DAILY", nil)
+	mock.ExpectQuery("SELECT (.+) FROM entries WHERE user_id \\= \\$1 AND kind \\= 'DAILY' AND deleted_at IS NULL AND created_at >= \\$2 AND created_at < \\$3").
+		WithArgs("abcdefg", today.UTC(), today.AddDate(0, 0, 1).UTC()).WillReturnRows(rows)
+	mock.ExpectCommit()
+
+	res, err := mutResolver.CreateEntry(ctx, models.NewEntry{
+		UserID:    "abcdefg",
+		Content:   "a great entry",
+		WordCount: 1000,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1", res.ID)
+	assert.Equal(t, 2, res.Version)
+
+	// No key or second entry was created
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateEntryWithStaleVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	resolver := &Resolver{
+		db:   db,
+		keys: keystore.New(db, ""),
+	}
+	mutResolver := &mutationResolver{
+		Resolver: resolver,
+	}
+
+	token := &firebase.Token{
+		Subject: "abcdefg",
+	}
+
+	c := context.Background()
+	ctx := context.WithValue(c, auth.UserCtxKey, token)
+
+	mock.ExpectQuery("SELECT e.key_id, e.kind, e.goal_hit, u.client_encryption, (.+) FROM entries e JOIN users u ON u.firebase_id \\= e.user_id (.+) WHERE e.id \\= \\$1 AND e.user_id \\= \\$2").
+		WithArgs("1", "abcdefg").WillReturnRows(sqlmock.NewRows([]string{"key_id", "kind", "goal_hit", "client_encryption", "word_count", "key_id"}).AddRow(nil, "DAILY", false, false, nil, nil))
+	mock.ExpectQuery("INSERT INTO encryption_keys \\(user_id, wrapped_key\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("5"))
+	mock.ExpectQuery("UPDATE entries SET content \\= \\$1, word_count \\= \\$2, goal_hit \\= \\$3, key_id \\= \\$4, client_encrypted \\= \\$5, title \\= \\$6, version \\= version \\+ 1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version"}))
+
+	// The key made for the lost save is never used
+	mock.ExpectQuery("UPDATE encryption_keys SET wrapped_key \\= NULL").WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("5", "abcdefg"))
+	mock.ExpectExec("INSERT INTO key_audit").WithArgs("5", "abcdefg", "unused").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rows := sqlmock.NewRows([]string{"id", "user_id", "word_count", "content", "created_at", "updated_at", "goal_hit", "version", "key_id", "client_encrypted", "kind", "title"}).
+		AddRow("1", "abcdefg", 20, "from my phone", "2020-01-01", "2020-01-01", false, 3, nil, false, "DAILY", nil)
+	mock.ExpectQuery("SELECT (.+) FROM entries WHERE id \\= \\$1 AND user_id \\= \\$2").
+		WithArgs("1", "abcdefg").WillReturnRows(rows)
+
+	version := 2
+	var entry = models.ExistingEntry{
+		UserID:    "abcdefg",
+		Content:   "from my laptop",
+		WordCount: 30,
+		Version:   &version,
+	}
+
+	res, err := mutResolver.UpdateEntry(ctx, "1", entry, "2020-01-01")
+
+	assert.Empty(t, res)
+
+	gqlErr := err.(*gqlerror.Error)
+	assert.Equal(t, "Entry has been updated on another device", gqlErr.Message)
+	assert.Equal(t, "CONFLICT", gqlErr.Extensions["code"])
+	assert.Equal(t, 3, gqlErr.Extensions["entry"].(*models.Entry).Version)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// wrappedKey wraps a key with an empty master key
+func wrappedKey(t *testing.T, key *[32]byte) string {
+	wrapped, err := cryptopasta.Encrypt(key[:], &[32]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hex.EncodeToString(wrapped)
+}
+
+func TestUpdateEntryUsesKeyFromConcurrentFirstSave(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(db, ""), broker: pubsub.New()},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	mock.ExpectQuery("SELECT e.key_id, e.kind, e.goal_hit, u.client_encryption, (.+) FROM entries e").
+		WithArgs("1", "abcdefg").WillReturnRows(sqlmock.NewRows([]string{"key_id", "kind", "goal_hit", "client_encryption", "word_count", "key_id"}).AddRow(nil, "DAILY", false, false, nil, nil))
+	mock.ExpectQuery("INSERT INTO encryption_keys \\(user_id, wrapped_key\\)").
+		WithArgs("abcdefg", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("5"))
+	mock.ExpectQuery("UPDATE entries SET content \\= \\$1").
+		WithArgs(sqlmock.AnyArg(), 30, false, "5", false, nil, "1", "abcdefg", nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "created_at", "updated_at"}))
+
+	// Another save gave the entry its key first
+	mock.ExpectQuery("UPDATE encryption_keys SET wrapped_key \\= NULL").WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("5", "abcdefg"))
+	mock.ExpectExec("INSERT INTO key_audit").WithArgs("5", "abcdefg", "unused").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT key_id FROM entries WHERE id \\= \\$1 AND user_id \\= \\$2 AND deleted_at IS NULL").
+		WithArgs("1", "abcdefg").WillReturnRows(sqlmock.NewRows([]string{"key_id"}).AddRow("6"))
+	mock.ExpectQuery("SELECT id, wrapped_key FROM encryption_keys").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "wrapped_key"}).AddRow("6", wrappedKey(t, cryptopasta.NewEncryptionKey())))
+	mock.ExpectQuery("UPDATE entries SET content \\= \\$1").
+		WithArgs(sqlmock.AnyArg(), 30, false, "6", false, nil, "1", "abcdefg", nil, "6").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "created_at", "updated_at"}).AddRow("1", 2, "2020-01-01", "2020-01-02"))
+
+	mock.ExpectExec("INSERT INTO daily_user_stats").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO entry_insights").WithArgs("1", "abcdefg", sqlmock.AnyArg(), "6", 30).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	res, err := mutResolver.UpdateEntry(ctx, "1", models.ExistingEntry{
+		UserID:    "abcdefg",
+		Content:   "from my laptop",
+		WordCount: 30,
+	}, "2020-01-01")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "6", *res.KeyID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateEntryMovesToClientEncryption(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	broker := pubsub.New()
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(db, ""), broker: broker},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+	updates := broker.Subscribe(ctx, "1")
+
+	mock.ExpectQuery("SELECT e.key_id, e.kind, e.goal_hit, u.client_encryption, (.+) FROM entries e").
+		WithArgs("1", "abcdefg").WillReturnRows(sqlmock.NewRows([]string{"key_id", "kind", "goal_hit", "client_encryption", "word_count", "key_id"}).AddRow("5", "DAILY", false, true, 20, "5"))
+	mock.ExpectQuery("UPDATE entries SET content \\= \\$1, word_count \\= \\$2, goal_hit \\= \\$3, key_id \\= \\$4, client_encrypted \\= \\$5").
+		WithArgs("ciphertext", 30, false, nil, true, nil, "1", "abcdefg", 2, "5").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "created_at", "updated_at"}).AddRow("1", 3, "2020-01-01", "2020-01-02"))
+
+	// The server's key goes only once the entry has been saved without it
+	mock.ExpectQuery("UPDATE encryption_keys SET wrapped_key \\= NULL, destroyed_at \\= NOW\\(\\) WHERE id \\= \\$1").
+		WithArgs("5").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("5", "abcdefg"))
+	mock.ExpectExec("INSERT INTO key_audit").WithArgs("5", "abcdefg", "entry moved to client encryption").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectExec("INSERT INTO daily_user_stats").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM entry_insights WHERE entry_id \\= \\$1").WithArgs("1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	version := 2
+	clientEncrypted := true
+	res, err := mutResolver.UpdateEntry(ctx, "1", models.ExistingEntry{
+		UserID:          "abcdefg",
+		Content:         "ciphertext",
+		WordCount:       30,
+		Version:         &version,
+		ClientEncrypted: &clientEncrypted,
+	}, "2020-01-01")
+
+	assert.Nil(t, err)
+	assert.Nil(t, res.KeyID)
+	assert.Equal(t, "ciphertext", res.Content)
+
+	// Other devices get the whole entry, timestamps included
+	published := <-updates
+	assert.Equal(t, 3, published.Version)
+	assert.Equal(t, "2020-01-01", published.CreatedAt)
+	assert.Equal(t, "2020-01-02", published.UpdatedAt)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateEntryKeepsKeyWhenMoveToClientEncryptionIsStale(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(db, "01234567890123456789012345678901")},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	mock.ExpectQuery("SELECT e.key_id, e.kind, e.goal_hit, u.client_encryption, (.+) FROM entries e").
+		WithArgs("1", "abcdefg").WillReturnRows(sqlmock.NewRows([]string{"key_id", "kind", "goal_hit", "client_encryption", "word_count", "key_id"}).AddRow("5", "DAILY", false, true, 20, "5"))
+	mock.ExpectQuery("UPDATE entries SET content \\= \\$1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "created_at", "updated_at"}))
+
+	// Another device saved first, its copy is still sealed with the server's key
+	rows := sqlmock.NewRows([]string{"id", "user_id", "word_count", "content", "created_at", "updated_at", "goal_hit", "version", "key_id", "client_encrypted", "kind", "title"}).
+		AddRow("1", "abcdefg", 20, "sealed", "2020-01-01", "2020-01-01", false, 3, "5", false, "DAILY", nil)
+	mock.ExpectQuery("SELECT (.+) FROM entries WHERE id \\= \\$1 AND user_id \\= \\$2").
+		WithArgs("1", "abcdefg").WillReturnRows(rows)
+	mock.ExpectQuery("SELECT id, wrapped_key FROM encryption_keys").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "wrapped_key"}))
+
+	version := 2
+	clientEncrypted := true
+	_, err = mutResolver.UpdateEntry(ctx, "1", models.ExistingEntry{
+		UserID:          "abcdefg",
+		Content:         "ciphertext",
+		WordCount:       30,
+		Version:         &version,
+		ClientEncrypted: &clientEncrypted,
+	}, "2020-01-01")
+
+	assert.Equal(t, "CONFLICT", err.(*gqlerror.Error).Extensions["code"])
+
+	// No key was destroyed
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestEntryUpdatedEndsWhenTokenExpires(t *testing.T) {
+	tests := []struct {
+		name  string
+		token *firebase.Token
+		live  bool
+	}{
+		{"valid token", &firebase.Token{Subject: "abcdefg", Expires: time.Now().Add(time.Hour).Unix()}, true},
+		{"expired token", &firebase.Token{Subject: "abcdefg", Expires: time.Now().Add(-time.Minute).Unix()}, false},
+		{"API token that never expires", &firebase.Token{Subject: "abcdefg", Issuer: auth.APITokenIssuer, Claims: map[string]interface{}{"scopes": []string{"ENTRIES_READ"}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			broker := pubsub.New()
+			subResolver := &subscriptionResolver{
+				Resolver: &Resolver{db: db, broker: broker},
+			}
+
+			ctx, cancel := context.WithCancel(context.WithValue(context.Background(), auth.UserCtxKey, tt.token))
+			defer cancel()
+
+			mock.ExpectQuery("SELECT user_id FROM entries WHERE id \\= \\$1").
+				WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("abcdefg"))
+
+			updates, err := subResolver.EntryUpdated(ctx, "1")
+			assert.Nil(t, err)
+
+			broker.Publish(&models.Entry{ID: "1", Version: 2})
+
+			entry, open := <-updates
+			assert.Equal(t, tt.live, open)
+			if tt.live {
+				assert.Equal(t, 2, entry.Version)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package resolvers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/writewithwrabit/server/auth"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/insights"
+	"github.com/writewithwrabit/server/keystore"
+	"github.com/writewithwrabit/server/models"
+)
+
+func (r *queryResolver) MonthlyInsights(ctx context.Context, userID string, month string) (*models.InsightSummary, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return &models.InsightSummary{}, fmt.Errorf("Access denied")
+	}
+
+	start, err := time.ParseInLocation("2006-01", month, r.userLocation(userID))
+	if err != nil {
+		return &models.InsightSummary{}, fmt.Errorf("Month must be formatted as YYYY-MM")
+	}
+
+	res := wrabitDB.LogAndQuery(r.db, "SELECT i.analysis, i.key_id FROM entry_insights i JOIN entries e ON e.id = i.entry_id WHERE i.user_id = $1 AND NOT e.client_encrypted AND e.deleted_at IS NULL AND e.created_at >= $2 AND e.created_at < $3", userID, start, start.AddDate(0, 1, 0))
+
+	type sealedAnalysis struct {
+		analysis string
+		keyID    *string
+	}
+
+	var sealed []sealedAnalysis
+	var ids []string
+	for res.Next() {
+		var row sealedAnalysis
+		if err := res.Scan(&row.analysis, &row.keyID); err != nil {
+			res.Close()
+			panic(err)
+		}
+
+		sealed = append(sealed, row)
+		if row.keyID != nil {
+			ids = append(ids, *row.keyID)
+		}
+	}
+	res.Close()
+
+	keys, err := r.keys.GetMany(ids)
+	if err != nil {
+		panic(err)
+	}
+
+	var analyses []*insights.Analysis
+	for _, row := range sealed {
+		// Insights go with their entry once its key is destroyed
+		if row.keyID == nil {
+			continue
+		}
+
+		key, ok := keys[*row.keyID]
+		if !ok {
+			continue
+		}
+
+		analysis := new(insights.Analysis)
+		if err := json.Unmarshal([]byte(keystore.Open(row.analysis, key)), analysis); err != nil {
+			continue
+		}
+
+		analyses = append(analyses, analysis)
+	}
+
+	return insights.Summarize(month, analyses), nil
+}
+
+// analyze runs the insights pipeline on an entry's plaintext
+func analyze(content string) string {
+	analysis, err := json.Marshal(insights.Analyze(content))
+	if err != nil {
+		panic(err)
+	}
+
+	return string(analysis)
+}
+
+// reanalyzeWords is how far an entry's word count has to move before its
+// insights are worked out again
+const reanalyzeWords = 50
+
+// analyzedInsights describes the insights already kept for an entry
+type analyzedInsights struct {
+	wordCount *int
+	keyID     *string
+}
+
+// stale checks if the insights no longer describe the entry, or are sealed
+// with a key the entry no longer has
+func (a analyzedInsights) stale(entry *models.Entry) bool {
+	if a.wordCount == nil || a.keyID == nil || entry.KeyID == nil || *a.keyID != *entry.KeyID {
+		return true
+	}
+
+	change := entry.WordCount - *a.wordCount
+	return change >= reanalyzeWords || change <= -reanalyzeWords
+}
+
+// saveInsights keeps an entry's sealed insights. The server can't read client
+// encrypted entries so any insights from before the switch are dropped.
+// Without new insights the ones already kept stay as they are.
+func (r *Resolver) saveInsights(entry *models.Entry, sealed string) {
+	if entry.ClientEncrypted {
+		wrabitDB.LogAndExec(r.db, "DELETE FROM entry_insights WHERE entry_id = $1", entry.ID)
+		return
+	}
+
+	if sealed == "" {
+		return
+	}
+
+	wrabitDB.LogAndExec(r.db, "INSERT INTO entry_insights (entry_id, user_id, analysis, key_id, word_count) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (entry_id) DO UPDATE SET analysis = EXCLUDED.analysis, key_id = EXCLUDED.key_id, word_count = EXCLUDED.word_count", entry.ID, entry.UserID, sealed, entry.KeyID, entry.WordCount)
+}
@@ -0,0 +1,117 @@
+package resolvers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	firebase "firebase.google.com/go/auth"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/writewithwrabit/server/auth"
+	cryptopasta "github.com/writewithwrabit/server/cryptopasta"
+	"github.com/writewithwrabit/server/keystore"
+	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/pubsub"
+)
+
+func TestMonthlyInsights(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(db, "")},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+	key := cryptopasta.NewEncryptionKey()
+
+	mock.ExpectQuery("SELECT timezone FROM users WHERE firebase_id \\= \\$1").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone"}).AddRow("UTC"))
+
+	// Client encrypted and trashed entries are left out by the query, entries
+	// whose keys are gone or never had one are left out after it
+	mock.ExpectQuery("SELECT i.analysis, i.key_id FROM entry_insights i JOIN entries e ON e.id \\= i.entry_id WHERE i.user_id \\= \\$1 AND NOT e.client_encrypted AND e.deleted_at IS NULL AND e.created_at >= \\$2 AND e.created_at < \\$3").
+		WithArgs("abcdefg", time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)).
+		WillReturnRows(sqlmock.NewRows([]string{"analysis", "key_id"}).
+			AddRow(keystore.Seal(analyze("Gardening again today. The garden is full of tomatoes."), key), "5").
+			AddRow(keystore.Seal(analyze("Destroyed with its entry."), key), "6").
+			AddRow(analyze("Written before entries had keys."), nil))
+	mock.ExpectQuery("SELECT id, wrapped_key FROM encryption_keys").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "wrapped_key"}).AddRow("5", wrappedKey(t, key)))
+
+	summary, err := queryResolver.MonthlyInsights(ctx, "abcdefg", "2020-03")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "2020-03", summary.Month)
+	assert.Equal(t, 1, summary.EntriesAnalyzed)
+	assert.NotNil(t, summary.AverageSentiment)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMonthlyInsightsOfAnotherUser(t *testing.T) {
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	_, err := queryResolver.MonthlyInsights(ctx, "someoneelse", "2020-03")
+
+	assert.EqualError(t, err, "Access denied")
+}
+
+func TestUpdateEntryKeepsInsightsForSmallEdits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(db, ""), broker: pubsub.New()},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	// The insights were worked out at 20 words
+	mock.ExpectQuery("SELECT e.key_id, e.kind, e.goal_hit, u.client_encryption, i.word_count, i.key_id FROM entries e").
+		WithArgs("1", "abcdefg").WillReturnRows(sqlmock.NewRows([]string{"key_id", "kind", "goal_hit", "client_encryption", "word_count", "key_id"}).AddRow("5", "DAILY", false, false, 20, "5"))
+	mock.ExpectQuery("SELECT id, wrapped_key FROM encryption_keys").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "wrapped_key"}).AddRow("5", wrappedKey(t, cryptopasta.NewEncryptionKey())))
+	mock.ExpectQuery("UPDATE entries SET content \\= \\$1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "created_at", "updated_at"}).AddRow("1", 2, "2020-01-01", "2020-01-02"))
+	mock.ExpectExec("INSERT INTO daily_user_stats").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	res, err := mutResolver.UpdateEntry(ctx, "1", models.ExistingEntry{
+		UserID:    "abcdefg",
+		Content:   "a few more words",
+		WordCount: 30,
+	}, "2020-01-01")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "a few more words", res.Content)
+
+	// Nothing was written to entry_insights
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestInsightsGoStale(t *testing.T) {
+	wordCount, keyID, otherKeyID := 100, "5", "6"
+	analyzed := analyzedInsights{wordCount: &wordCount, keyID: &keyID}
+
+	assert.False(t, analyzed.stale(&models.Entry{KeyID: &keyID, WordCount: 149}))
+	assert.False(t, analyzed.stale(&models.Entry{KeyID: &keyID, WordCount: 51}))
+	assert.True(t, analyzed.stale(&models.Entry{KeyID: &keyID, WordCount: 150}))
+	assert.True(t, analyzed.stale(&models.Entry{KeyID: &keyID, WordCount: 50}))
+	assert.True(t, analyzed.stale(&models.Entry{KeyID: &otherKeyID, WordCount: 100}))
+	assert.True(t, analyzedInsights{}.stale(&models.Entry{KeyID: &keyID, WordCount: 100}))
+}
@@ -0,0 +1,65 @@
+package resolvers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/writewithwrabit/server/auth"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/webhooks"
+)
+
+func (r *queryResolver) VapidPublicKey(ctx context.Context) (string, error) {
+	return r.push.PublicKey(), nil
+}
+
+func (r *mutationResolver) RegisterPushSubscription(ctx context.Context, input models.NewPushSubscription) (*models.PushSubscription, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != input.UserID {
+		return &models.PushSubscription{}, fmt.Errorf("Access denied")
+	}
+
+	// The server posts to the endpoint, so it can't point inside our network
+	if err := webhooks.PublicURL(input.Endpoint, "Push endpoint"); err != nil {
+		return &models.PushSubscription{}, err
+	}
+
+	subscription := &models.PushSubscription{
+		UserID:   input.UserID,
+		Endpoint: input.Endpoint,
+		P256dh:   input.P256dh,
+		Auth:     input.Auth,
+	}
+
+	// Browsers can rotate keys for the same endpoint so replace them.
+	// Another account only takes the endpoint over from the same browser,
+	// which is the only place its auth secret is known outside the server.
+	res := wrabitDB.LogAndQueryRow(r.db, "INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth) VALUES ($1, $2, $3, $4) ON CONFLICT (endpoint) DO UPDATE SET user_id = EXCLUDED.user_id, p256dh = EXCLUDED.p256dh, auth = EXCLUDED.auth WHERE push_subscriptions.user_id = EXCLUDED.user_id OR (push_subscriptions.p256dh = EXCLUDED.p256dh AND push_subscriptions.auth = EXCLUDED.auth) RETURNING id, created_at, updated_at", subscription.UserID, subscription.Endpoint, subscription.P256dh, subscription.Auth)
+	err := res.Scan(&subscription.ID, &subscription.CreatedAt, &subscription.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return &models.PushSubscription{}, fmt.Errorf("Push subscription belongs to another account")
+	} else if err != nil {
+		panic(err)
+	}
+
+	return subscription, nil
+}
+
+func (r *mutationResolver) RemovePushSubscription(ctx context.Context, endpoint string) (*models.PushSubscription, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return &models.PushSubscription{}, fmt.Errorf("Access denied")
+	}
+
+	var subscription = &models.PushSubscription{}
+	res := wrabitDB.LogAndExec(r.db, "DELETE FROM push_subscriptions WHERE user_id = $1 AND endpoint = $2", user.Subject, endpoint)
+	count, err := res.RowsAffected()
+	if err == nil && count == 1 {
+		subscription.UserID = user.Subject
+		subscription.Endpoint = endpoint
+	}
+
+	return subscription, nil
+}
@@ -0,0 +1,101 @@
+package resolvers
+
+import (
+	"context"
+	"testing"
+
+	firebase "firebase.google.com/go/auth"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/writewithwrabit/server/auth"
+	"github.com/writewithwrabit/server/models"
+)
+
+func TestRegisterPushSubscription(t *testing.T) {
+	tests := []struct {
+		name  string
+		taken bool
+		err   string
+	}{
+		{"new or reassigned endpoint", false, ""},
+		{"endpoint of another account", true, "Push subscription belongs to another account"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			mutResolver := &mutationResolver{
+				Resolver: &Resolver{db: db},
+			}
+
+			ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+			// The upsert leaves a conflicting row alone unless it is the user's or has the same keys
+			rows := sqlmock.NewRows([]string{"id", "created_at", "updated_at"})
+			if !tt.taken {
+				rows.AddRow("3", "2020-01-01", "2020-01-01")
+			}
+			mock.ExpectQuery("INSERT INTO push_subscriptions (.+) ON CONFLICT \\(endpoint\\) DO UPDATE SET (.+) WHERE push_subscriptions.user_id \\= EXCLUDED.user_id OR \\(push_subscriptions.p256dh \\= EXCLUDED.p256dh AND push_subscriptions.auth \\= EXCLUDED.auth\\)").
+				WithArgs("abcdefg", "https://93.184.216.34/push/1", "key", "secret").WillReturnRows(rows)
+
+			subscription, err := mutResolver.RegisterPushSubscription(ctx, models.NewPushSubscription{
+				UserID:   "abcdefg",
+				Endpoint: "https://93.184.216.34/push/1",
+				P256dh:   "key",
+				Auth:     "secret",
+			})
+
+			if tt.err == "" {
+				assert.Nil(t, err)
+				assert.Equal(t, "3", subscription.ID)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestRegisterPushSubscriptionToPrivateEndpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	for endpoint, message := range map[string]string{
+		"http://93.184.216.34/push/1":                 "Push endpoint must use https",
+		"https://169.254.169.254/computeMetadata/v1/": "Push endpoint must be a public address",
+		"https://127.0.0.1:8080/push/1":               "Push endpoint must be a public address",
+		"https://[::ffff:10.0.0.1]/push/1":            "Push endpoint must be a public address",
+		"push.example.com/1":                          "Push endpoint must be an absolute URL",
+	} {
+		_, err := mutResolver.RegisterPushSubscription(ctx, models.NewPushSubscription{
+			UserID:   "abcdefg",
+			Endpoint: endpoint,
+			P256dh:   "key",
+			Auth:     "secret",
+		})
+
+		assert.EqualError(t, err, message, endpoint)
+	}
+
+	// Nothing is stored
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,111 @@
+package resolvers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/writewithwrabit/server/auth"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/reminders"
+)
+
+func (r *queryResolver) Reminder(ctx context.Context, userID string) (*models.Reminder, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return nil, fmt.Errorf("Access denied")
+	}
+
+	reminder, err := r.reminderByUserID(userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return reminder, nil
+}
+
+func (r *mutationResolver) UpdateReminder(ctx context.Context, userID string, input models.ReminderSettings) (*models.Reminder, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return &models.Reminder{}, fmt.Errorf("Access denied")
+	}
+
+	reminder, err := r.reminderByUserID(userID)
+	if err == sql.ErrNoRows {
+		// Default to the hour the user usually writes at
+		var timezone string
+		res := wrabitDB.LogAndQueryRow(r.db, "SELECT timezone FROM users WHERE firebase_id = $1", userID)
+		if err := res.Scan(&timezone); err != nil {
+			panic(err)
+		}
+
+		reminder = &models.Reminder{
+			UserID:     userID,
+			Time:       reminders.PreferredTime(r.db, userID, timezone),
+			DaysOfWeek: []int{0, 1, 2, 3, 4, 5, 6},
+			Channel:    models.ReminderChannelEmail,
+			Enabled:    true,
+		}
+	}
+
+	if input.Time != nil {
+		if _, err := time.Parse("15:04", *input.Time); err != nil {
+			return &models.Reminder{}, fmt.Errorf("Time must be formatted as HH:MM")
+		}
+
+		reminder.Time = *input.Time
+	}
+
+	if input.DaysOfWeek != nil {
+		for _, day := range input.DaysOfWeek {
+			if day < 0 || day > 6 {
+				return &models.Reminder{}, fmt.Errorf("Days of week must be between 0 (Sunday) and 6 (Saturday)")
+			}
+		}
+
+		reminder.DaysOfWeek = input.DaysOfWeek
+	}
+
+	if input.Channel != nil {
+		reminder.Channel = *input.Channel
+	}
+
+	if input.Enabled != nil {
+		reminder.Enabled = *input.Enabled
+	}
+
+	days := make([]int64, len(reminder.DaysOfWeek))
+	for i, day := range reminder.DaysOfWeek {
+		days[i] = int64(day)
+	}
+
+	res := wrabitDB.LogAndQueryRow(r.db, "INSERT INTO reminders (user_id, time, days_of_week, channel, enabled) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (user_id) DO UPDATE SET time = EXCLUDED.time, days_of_week = EXCLUDED.days_of_week, channel = EXCLUDED.channel, enabled = EXCLUDED.enabled RETURNING id, created_at, updated_at", reminder.UserID, reminder.Time, pq.Array(days), reminder.Channel, reminder.Enabled)
+	if err := res.Scan(&reminder.ID, &reminder.CreatedAt, &reminder.UpdatedAt); err != nil {
+		panic(err)
+	}
+
+	return reminder, nil
+}
+
+func (r *Resolver) reminderByUserID(userID string) (*models.Reminder, error) {
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT id, user_id, time, days_of_week, channel, enabled, last_sent_on, created_at, updated_at FROM reminders WHERE user_id = $1", userID)
+
+	var reminder = new(models.Reminder)
+	var days []int64
+	err := res.Scan(&reminder.ID, &reminder.UserID, &reminder.Time, pq.Array(&days), &reminder.Channel, &reminder.Enabled, &reminder.LastSentOn, &reminder.CreatedAt, &reminder.UpdatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+
+	for _, day := range days {
+		reminder.DaysOfWeek = append(reminder.DaysOfWeek, int(day))
+	}
+
+	return reminder, nil
+}
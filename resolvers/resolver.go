@@ -9,27 +9,51 @@ import (
 	"os"
 	"time"
 
-	"github.com/mailgun/mailgun-go/v3"
 	stripe "github.com/stripe/stripe-go"
 	"github.com/stripe/stripe-go/card"
-	"github.com/stripe/stripe-go/customer"
 	"github.com/stripe/stripe-go/sub"
+	"github.com/writewithwrabit/server/accounts"
+	"github.com/writewithwrabit/server/achievements"
 	"github.com/writewithwrabit/server/auth"
 	wrabitDB "github.com/writewithwrabit/server/db"
 	"github.com/writewithwrabit/server/graph/generated"
+	"github.com/writewithwrabit/server/keystore"
 	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/pubsub"
+	"github.com/writewithwrabit/server/push"
+	"github.com/writewithwrabit/server/stats"
+	"github.com/writewithwrabit/server/webhooks"
 )
 
+// How long global stats are cached for
+const globalStatsTTL = 15 * time.Minute
+
 type Resolver struct {
-	db      *sql.DB
-	editors []*models.Editor
-	entries []*models.Entry
+	db           *sql.DB
+	push         *push.Client
+	broker       *pubsub.Broker
+	keys         *keystore.Store
+	achievements *achievements.Engine
+	globalStats  *stats.Cache
+	signup       *accounts.Signup
+	emails       *accounts.EmailChanges
+	webhooks     *webhooks.Dispatcher
+	editors      []*models.Editor
+	entries      []*models.Entry
 }
 
-func New(db *sql.DB) generated.Config {
+func New(db *sql.DB, push *push.Client, keys *keystore.Store, achievements *achievements.Engine, emails *accounts.EmailChanges, webhooks *webhooks.Dispatcher) generated.Config {
 	return generated.Config{
 		Resolvers: &Resolver{
-			db: db,
+			db:           db,
+			push:         push,
+			broker:       pubsub.New(),
+			keys:         keys,
+			achievements: achievements,
+			globalStats:  stats.NewCache(globalStatsTTL),
+			signup:       accounts.NewSignup(db, accounts.StripeCustomers{}),
+			emails:       emails,
+			webhooks:     webhooks,
 		},
 	}
 }
@@ -42,6 +66,10 @@ func (r *Resolver) Query() generated.QueryResolver {
 	return &queryResolver{r}
 }
 
+func (r *Resolver) Subscription() generated.SubscriptionResolver {
+	return &subscriptionResolver{r}
+}
+
 func (r *Resolver) Editor() generated.EditorResolver {
 	return &editorResolver{r}
 }
@@ -64,42 +92,12 @@ func (r *Resolver) StripeSubscription() generated.StripeSubscriptionResolver {
 
 type mutationResolver struct{ *Resolver }
 
-func (r *mutationResolver) CreateUser(ctx context.Context, input models.NewUser) (*models.User, error) {
-	// Initialize Stripe
-	stripe.Key = os.Getenv("STRIPE_KEY")
-
-	user := &models.User{
-		FirstName: input.FirstName,
-		LastName:  input.LastName,
-		Email:     input.Email,
-	}
-
-	params := &stripe.CustomerParams{
-		Name:  stripe.String(user.FirstName),
-		Email: stripe.String(user.Email),
-	}
-	cus, err := customer.New(params)
-	if err != nil {
-		panic(err)
-	}
-
-	// Add the Stripe ID so that it returns
-	user.StripeID = &cus.ID
-
-	res := wrabitDB.LogAndQueryRow(r.db, "INSERT INTO users (first_name, last_name, email, stripe_id) VALUES ($1, $2, $3, $4) RETURNING id", user.FirstName, user.LastName, user.Email, cus.ID)
-	if err := res.Scan(&user.ID); err != nil {
-		panic(err)
-	}
-
-	return user, nil
-}
-
 func (r *mutationResolver) UpdateUser(ctx context.Context, input models.UpdatedUser) (*models.User, error) {
-	res := wrabitDB.LogAndQueryRow(r.db, "SELECT id, firebase_id, stripe_id, first_name, last_name, email, word_goal FROM users WHERE id = $1", input.ID)
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT id, firebase_id, stripe_id, first_name, last_name, email, word_goal, timezone, client_encryption, client_encryption_params FROM users WHERE id = $1", input.ID)
 
 	// TODO: Figure out why createdAt and updatedAt didn't work on this query
 	var user models.User
-	if err := res.Scan(&user.ID, &user.FirebaseID, &user.StripeID, &user.FirstName, &user.LastName, &user.Email, &user.WordGoal); err != nil {
+	if err := res.Scan(&user.ID, &user.FirebaseID, &user.StripeID, &user.FirstName, &user.LastName, &user.Email, &user.WordGoal, &user.Timezone, &user.ClientEncryption, &user.ClientEncryptionParams); err != nil {
 		panic(err)
 	}
 
@@ -108,16 +106,6 @@ func (r *mutationResolver) UpdateUser(ctx context.Context, input models.UpdatedU
 		return &models.User{}, fmt.Errorf("Access denied")
 	}
 
-	firebaseID := user.FirebaseID
-	if input.FirebaseID != nil {
-		firebaseID = input.FirebaseID
-	}
-
-	stripeID := user.StripeID
-	if input.StripeID != nil {
-		stripeID = input.StripeID
-	}
-
 	firstName := user.FirstName
 	if input.FirstName != nil {
 		firstName = *input.FirstName
@@ -128,78 +116,46 @@ func (r *mutationResolver) UpdateUser(ctx context.Context, input models.UpdatedU
 		lastName = input.LastName
 	}
 
-	email := user.Email
-	if input.Email != nil {
-		email = *input.Email
-	}
-
 	wordGoal := user.WordGoal
 	if input.WordGoal != nil {
 		wordGoal = *input.WordGoal
 	}
 
-	user = models.User{
-		ID:         input.ID,
-		FirebaseID: firebaseID,
-		StripeID:   stripeID,
-		FirstName:  firstName,
-		LastName:   lastName,
-		Email:      email,
-		WordGoal:   wordGoal,
-	}
+	timezone := user.Timezone
+	if input.Timezone != nil {
+		if _, err := time.LoadLocation(*input.Timezone); err != nil {
+			return &models.User{}, fmt.Errorf("Unknown timezone %s", *input.Timezone)
+		}
 
-	res = wrabitDB.LogAndQueryRow(r.db, "UPDATE users SET firebase_id = $1, stripe_id = $2, first_name = $3, last_name = $4, email = $5, word_goal = $6 WHERE id = $7 RETURNING id", user.FirebaseID, user.StripeID, user.FirstName, user.LastName, user.Email, user.WordGoal, user.ID)
-	if err := res.Scan(&user.ID); err != nil {
-		panic(err)
+		timezone = *input.Timezone
 	}
 
-	return &user, nil
-}
-
-func (r *mutationResolver) CompleteUserSignup(ctx context.Context, input models.SignedUpUser) (*models.User, error) {
-	res := wrabitDB.LogAndQueryRow(r.db, "SELECT id, firebase_id, stripe_id, first_name, last_name, email, word_goal FROM users WHERE id = $1", input.ID)
-	var user models.User
-	if err := res.Scan(&user.ID, &user.FirebaseID, &user.StripeID, &user.FirstName, &user.LastName, &user.Email, &user.WordGoal); err != nil {
-		panic(err)
+	clientEncryption := user.ClientEncryption
+	if input.ClientEncryption != nil {
+		clientEncryption = *input.ClientEncryption
 	}
 
-	res = wrabitDB.LogAndQueryRow(r.db, "UPDATE users SET firebase_id = $1 WHERE id = $2 RETURNING id", input.FirebaseID, input.ID)
-	if err := res.Scan(&user.ID); err != nil {
-		panic(err)
+	clientEncryptionParams := user.ClientEncryptionParams
+	if input.ClientEncryptionParams != nil {
+		clientEncryptionParams = input.ClientEncryptionParams
 	}
 
-	// Initialize Mailgun
-	mgKey := os.Getenv("MAILGUN_KEY")
-	mg := mailgun.NewMailgun("mg.writewithwrabit.com", mgKey)
-
-	sender := "Team Wrabit <hello@writewithwrabit.com>"
-	subject := "Welcome to your writing journey!"
-	body := ""
-	recipient := user.Email
-
-	message := mg.NewMessage(sender, subject, body, recipient)
-	message.SetTemplate("app-template")
-	message.AddTemplateVariable("content", `Hey there! 👋<br><br>
-  
-  We hope you're ready to build a daily writing habit. It might not be easy but it's definitely rewarding!
-  We have a few tips to help you get started.<br><br>
-
-  1. <b>Don't think too much.</b> Let whatever needs to come out, come out.<br>
-  2. <b>Don't feel to bad if you miss a day.</b> At Wrabit we start small and every word counts.<br>
-  3. <b>Have fun! 🎉</b> Building a habit is hard so we want it to be as enjoyable as possible.<br><br>
-
-  If there is anything we can do to support you, feel free to reach out. You can respond directly to this email! Our platform is new but we have lots planned. Thanks for being apart of <em>our</em> journey.<br><br>
-
-  Be well,<br>
-  Team Wrabit 🐇
-  `)
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
+	user = models.User{
+		ID:         input.ID,
+		FirebaseID: user.FirebaseID,
+		StripeID:   user.StripeID,
+		FirstName:  firstName,
+		LastName:   lastName,
+		Email:      user.Email,
+		WordGoal:   wordGoal,
+		Timezone:   timezone,
 
-	_, _, err := mg.Send(ctx, message)
+		ClientEncryption:       clientEncryption,
+		ClientEncryptionParams: clientEncryptionParams,
+	}
 
-	if err != nil {
+	res = wrabitDB.LogAndQueryRow(r.db, "UPDATE users SET first_name = $1, last_name = $2, word_goal = $3, timezone = $4, client_encryption = $5, client_encryption_params = $6 WHERE id = $7 RETURNING id", user.FirstName, user.LastName, user.WordGoal, user.Timezone, user.ClientEncryption, user.ClientEncryptionParams, user.ID)
+	if err := res.Scan(&user.ID); err != nil {
 		panic(err)
 	}
 
@@ -295,10 +251,10 @@ func (r *queryResolver) User(ctx context.Context, id *string) (*models.User, err
 		return &models.User{}, fmt.Errorf("Access denied")
 	}
 
-	res := wrabitDB.LogAndQueryRow(r.db, "SELECT id, firebase_id, stripe_id, first_name, last_name, email, word_goal, stripe_subscription_id FROM users WHERE id = $1", id)
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT id, firebase_id, stripe_id, first_name, last_name, email, word_goal, timezone, client_encryption, client_encryption_params, stripe_subscription_id FROM users WHERE id = $1", id)
 
 	var user models.User
-	if err := res.Scan(&user.ID, &user.FirebaseID, &user.StripeID, &user.FirstName, &user.LastName, &user.Email, &user.WordGoal, &user.StripeSubscriptionID); err != nil {
+	if err := res.Scan(&user.ID, &user.FirebaseID, &user.StripeID, &user.FirstName, &user.LastName, &user.Email, &user.WordGoal, &user.Timezone, &user.ClientEncryption, &user.ClientEncryptionParams, &user.StripeSubscriptionID); err != nil {
 		panic(err)
 	}
 
@@ -306,10 +262,10 @@ func (r *queryResolver) User(ctx context.Context, id *string) (*models.User, err
 }
 
 func (r *queryResolver) UserByFirebaseID(ctx context.Context, firebaseID *string) (*models.User, error) {
-	res := wrabitDB.LogAndQueryRow(r.db, "SELECT id, firebase_id, stripe_id, first_name, last_name, email, word_goal, stripe_subscription_id, created_at FROM users WHERE firebase_id = $1", firebaseID)
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT id, firebase_id, stripe_id, first_name, last_name, email, word_goal, timezone, client_encryption, client_encryption_params, stripe_subscription_id, created_at FROM users WHERE firebase_id = $1", firebaseID)
 
 	var user models.User
-	if err := res.Scan(&user.ID, &user.FirebaseID, &user.StripeID, &user.FirstName, &user.LastName, &user.Email, &user.WordGoal, &user.StripeSubscriptionID, &user.CreatedAt); err != nil {
+	if err := res.Scan(&user.ID, &user.FirebaseID, &user.StripeID, &user.FirstName, &user.LastName, &user.Email, &user.WordGoal, &user.Timezone, &user.ClientEncryption, &user.ClientEncryptionParams, &user.StripeSubscriptionID, &user.CreatedAt); err != nil {
 		panic(err)
 	}
 
@@ -378,7 +334,7 @@ func (r *queryResolver) WordGoal(ctx context.Context, userID string, date string
 
 	// Figure out when the last entry was written
 	// Is 0 if they wrote within 24 hours
-	res = wrabitDB.LogAndQueryRow(r.db, "SELECT date_part('day', $1 - created_at::timestamp) As day_since_last_entry, id FROM entries WHERE user_id = $2 AND goal_hit = true ORDER BY created_at DESC LIMIT 1;", date, userID)
+	res = wrabitDB.LogAndQueryRow(r.db, "SELECT date_part('day', $1 - created_at::timestamp) As day_since_last_entry, id FROM entries WHERE user_id = $2 AND goal_hit = true AND deleted_at IS NULL ORDER BY created_at DESC LIMIT 1;", date, userID)
 	err = res.Scan(&daySinceLastWrote, &entryID)
 	if err != nil && err != sql.ErrNoRows {
 		panic(err)
@@ -412,68 +368,37 @@ func (r *queryResolver) Stats(ctx context.Context, global bool) (*models.Stats,
 		return &models.Stats{}, fmt.Errorf("Access denied")
 	}
 
-	var stats = new(models.Stats)
-
-	var res = new(sql.Row)
-	wordsWrittenQuery := "SELECT sum(word_count) as words_written FROM entries"
+	// Everyone sees the same global stats so they don't need to be fresh
 	if global {
-		res = wrabitDB.LogAndQueryRow(r.db, wordsWrittenQuery)
-	} else {
-		wordsWrittenQuery = wordsWrittenQuery + " WHERE user_id = $1"
-		res = wrabitDB.LogAndQueryRow(r.db, wordsWrittenQuery, user.Subject)
-	}
-	err := res.Scan(&stats.WordsWritten)
-	if err != nil && err != sql.ErrNoRows {
-		panic(err)
+		return r.globalStats.Get(func() *models.Stats {
+			return r.rolledUpStats(nil)
+		}), nil
 	}
 
-	longestEntryQuery := "SELECT max(word_count) as longest_entry FROM entries"
-	if global {
-		res = wrabitDB.LogAndQueryRow(r.db, longestEntryQuery)
-	} else {
-		longestEntryQuery = longestEntryQuery + " WHERE user_id = $1"
-		res = wrabitDB.LogAndQueryRow(r.db, longestEntryQuery, user.Subject)
-	}
-	err = res.Scan(&stats.LongestEntry)
-	if err != nil && err != sql.ErrNoRows {
+	return r.rolledUpStats(&user.Subject), nil
+}
+
+// rolledUpStats reads stats from the daily rollups, for everyone when userID is nil
+func (r *queryResolver) rolledUpStats(userID *string) *models.Stats {
+	var stats = new(models.Stats)
+
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT COALESCE(sum(words_written), 0), COALESCE(max(longest_entry), 0) FROM daily_user_stats WHERE $1::varchar IS NULL OR user_id = $1", userID)
+	if err := res.Scan(&stats.WordsWritten, &stats.LongestEntry); err != nil {
 		panic(err)
 	}
 
-	longestStreakQuery := "SELECT max(day_count) as longest_streak FROM streaks"
-	if global {
-		res = wrabitDB.LogAndQueryRow(r.db, longestStreakQuery)
-	} else {
-		longestStreakQuery = longestStreakQuery + " WHERE user_id = $1"
-		res = wrabitDB.LogAndQueryRow(r.db, longestStreakQuery, user.Subject)
-	}
-	err = res.Scan(&stats.LongestStreak)
-	// Check if there hasn't been a streak yet
-	if err != nil && err.Error() == "sql: Scan error on column index 0, name \"longest_streak\": converting NULL to int is unsupported" {
-		stats.LongestStreak = 0
-	} else if err != nil && err != sql.ErrNoRows {
+	res = wrabitDB.LogAndQueryRow(r.db, "SELECT COALESCE(max(day_count), 0) FROM streaks WHERE $1::varchar IS NULL OR user_id = $1", userID)
+	if err := res.Scan(&stats.LongestStreak); err != nil {
 		panic(err)
 	}
 
-	preferredDayOfWeekQuery := "SELECT preferred_day_of_week FROM (SELECT date_part('dow', updated_at) as preferred_day_of_week FROM entries) sub GROUP BY 1 ORDER BY count(*) DESC LIMIT 1"
-	if global {
-		res = wrabitDB.LogAndQueryRow(r.db, preferredDayOfWeekQuery)
-	} else {
-		preferredDayOfWeekQuery = "SELECT preferred_day_of_week FROM (SELECT date_part('dow', updated_at) as preferred_day_of_week FROM entries WHERE user_id = $1) sub GROUP BY 1 ORDER BY count(*) DESC LIMIT 1"
-		res = wrabitDB.LogAndQueryRow(r.db, preferredDayOfWeekQuery, user.Subject)
-	}
-	err = res.Scan(&stats.PreferredDayOfWeek)
+	res = wrabitDB.LogAndQueryRow(r.db, "SELECT date_part('dow', day) FROM daily_user_stats WHERE $1::varchar IS NULL OR user_id = $1 GROUP BY 1 ORDER BY sum(entries) DESC LIMIT 1", userID)
+	err := res.Scan(&stats.PreferredDayOfWeek)
 	if err != nil && err != sql.ErrNoRows {
 		panic(err)
 	}
 
-	var resTimes = new(sql.Rows)
-	preferredWritingTimesQuery := "SELECT hour, count(*) FROM (SELECT date_part('hour', updated_at) as hour FROM entries) sub GROUP BY 1 ORDER BY 2 DESC"
-	if global {
-		resTimes = wrabitDB.LogAndQuery(r.db, preferredWritingTimesQuery)
-	} else {
-		preferredWritingTimesQuery := "SELECT hour, count(*) FROM (SELECT date_part('hour', updated_at) as hour FROM entries WHERE user_id = $1) sub GROUP BY 1 ORDER BY 2 DESC"
-		resTimes = wrabitDB.LogAndQuery(r.db, preferredWritingTimesQuery, user.Subject)
-	}
+	resTimes := wrabitDB.LogAndQuery(r.db, "SELECT h.hour - 1, sum(h.count) FROM daily_user_stats d, unnest(d.hours) WITH ORDINALITY AS h(count, hour) WHERE $1::varchar IS NULL OR d.user_id = $1 GROUP BY 1 HAVING sum(h.count) > 0 ORDER BY 2 DESC", userID)
 	defer resTimes.Close()
 	for resTimes.Next() {
 		var preferredWritingTime = new(models.PreferredWritingTime)
@@ -484,7 +409,7 @@ func (r *queryResolver) Stats(ctx context.Context, global bool) (*models.Stats,
 		stats.PreferredWritingTimes = append(stats.PreferredWritingTimes, preferredWritingTime)
 	}
 
-	return stats, nil
+	return stats
 }
 
 // Individul resolvers
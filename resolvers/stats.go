@@ -0,0 +1,142 @@
+package resolvers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/writewithwrabit/server/auth"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/stats"
+	"github.com/writewithwrabit/server/streaks"
+)
+
+func (r *queryResolver) StatsRange(ctx context.Context, from string, to string, granularity models.Granularity) (*models.RangeStats, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return &models.RangeStats{}, fmt.Errorf("Access denied")
+	}
+
+	settings := r.streakSettings(user.Subject)
+
+	start, err := time.ParseInLocation("2006-01-02", from, settings.location)
+	if err != nil {
+		return &models.RangeStats{}, fmt.Errorf("From must be formatted as YYYY-MM-DD")
+	}
+
+	end, err := time.ParseInLocation("2006-01-02", to, settings.location)
+	if err != nil {
+		return &models.RangeStats{}, fmt.Errorf("To must be formatted as YYYY-MM-DD")
+	}
+
+	if end.Before(start) || end.Sub(start) > stats.MaxRangeDays*24*time.Hour {
+		return &models.RangeStats{}, fmt.Errorf("Ranges must end after they start and cover at most %d days", stats.MaxRangeDays)
+	}
+
+	rangeStats := &models.RangeStats{
+		From:        from,
+		To:          to,
+		Granularity: granularity,
+		Buckets:     stats.Buckets(start, end, granularity),
+	}
+
+	buckets := map[string]*models.StatsBucket{}
+	for _, bucket := range rangeStats.Buckets {
+		buckets[bucket.Start] = bucket
+	}
+
+	// Everything is grouped by the day the user wrote on where they live
+	res := wrabitDB.LogAndQuery(r.db, "SELECT word_count, goal_hit, to_char(created_at AT TIME ZONE $2, 'YYYY-MM-DD') FROM entries WHERE user_id = $1 AND word_count > 0 AND deleted_at IS NULL AND created_at >= $3 AND created_at < $4", user.Subject, settings.location.String(), start.UTC(), end.AddDate(0, 0, 1).UTC())
+
+	entries := 0
+	daysWritten := map[string]bool{}
+	goalDays := map[string]bool{}
+	for res.Next() {
+		var wordCount int
+		var goalHit bool
+		var date string
+		if err := res.Scan(&wordCount, &goalHit, &date); err != nil {
+			res.Close()
+			panic(err)
+		}
+
+		day, _ := time.ParseInLocation("2006-01-02", date, settings.location)
+		bucket := buckets[stats.BucketStart(day, granularity).Format("2006-01-02")]
+		bucket.WordsWritten += wordCount
+		bucket.Entries++
+
+		daysWritten[date] = true
+		if goalHit && !goalDays[date] {
+			goalDays[date] = true
+			bucket.GoalsHit++
+		}
+
+		rangeStats.WordsWritten += wordCount
+		entries++
+	}
+	res.Close()
+
+	if entries > 0 {
+		rangeStats.AverageEntryLength = float64(rangeStats.WordsWritten) / float64(entries)
+		rangeStats.GoalHitRate = float64(len(goalDays)) / float64(len(daysWritten))
+	}
+
+	// Consistency is the share of days the user meant to write on that they did.
+	// Rest days and days that haven't happened yet aren't expected.
+	today := streaks.Day(time.Now(), settings.location)
+	expected := 0
+	for day := start; !day.After(end) && !day.After(today); day = day.AddDate(0, 0, 1) {
+		if !streaks.IsRestDay(day, settings.restDays) || daysWritten[day.Format("2006-01-02")] {
+			expected++
+		}
+	}
+	if expected > 0 {
+		rangeStats.ConsistencyScore = float64(len(daysWritten)) / float64(expected)
+	}
+
+	// Vocabulary needs the words themselves, which the server can't read for client encrypted entries.
+	// Every entry has to be decrypted for it so it is left out of longer ranges.
+	if end.Sub(start) <= stats.MaxVocabularyDays*24*time.Hour {
+		vocabularySize := stats.Vocabulary(r.rangeTexts(user.Subject, start, end))
+		rangeStats.VocabularySize = &vocabularySize
+	}
+
+	var minutesToGoal sql.NullFloat64
+	row := wrabitDB.LogAndQueryRow(r.db, "SELECT avg(extract(epoch FROM goal_hit_at - started_at)) / 60 FROM entries WHERE user_id = $1 AND deleted_at IS NULL AND goal_hit_at IS NOT NULL AND started_at IS NOT NULL AND created_at >= $2 AND created_at < $3", user.Subject, start.UTC(), end.AddDate(0, 0, 1).UTC())
+	if err := row.Scan(&minutesToGoal); err != nil {
+		panic(err)
+	}
+	if minutesToGoal.Valid {
+		rangeStats.MinutesToGoal = &minutesToGoal.Float64
+	}
+
+	return rangeStats, nil
+}
+
+// rangeTexts decrypts the content of the entries written between two local days
+func (r *Resolver) rangeTexts(userID string, start time.Time, end time.Time) []string {
+	res := wrabitDB.LogAndQuery(r.db, "SELECT "+entryColumns+" FROM entries WHERE user_id = $1 AND word_count > 0 AND deleted_at IS NULL AND client_encrypted = false AND created_at >= $2 AND created_at < $3", userID, start.UTC(), end.AddDate(0, 0, 1).UTC())
+
+	var entries []*models.Entry
+	for res.Next() {
+		var entry = new(models.Entry)
+		if err := scanEntry(res, entry); err != nil {
+			res.Close()
+			panic(err)
+		}
+
+		entries = append(entries, entry)
+	}
+	res.Close()
+
+	r.openEntries(entries...)
+
+	texts := []string{}
+	for _, entry := range entries {
+		texts = append(texts, entry.Content)
+	}
+
+	return texts
+}
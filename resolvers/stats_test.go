@@ -0,0 +1,147 @@
+package resolvers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	firebase "firebase.google.com/go/auth"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/writewithwrabit/server/auth"
+	"github.com/writewithwrabit/server/keystore"
+	"github.com/writewithwrabit/server/models"
+)
+
+// expectRangeSettings has a user in UTC who rests on Sundays
+func expectRangeSettings(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT timezone, rest_days, streak_freezes FROM users WHERE firebase_id \\= \\$1").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone", "rest_days", "streak_freezes"}).AddRow("UTC", "{0}", 0))
+}
+
+func TestStatsRangeByWeek(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(db, "")},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	start := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2020, 3, 16, 0, 0, 0, 0, time.UTC)
+
+	expectRangeSettings(mock)
+	mock.ExpectQuery("SELECT word_count, goal_hit, to_char\\(created_at AT TIME ZONE \\$2, 'YYYY-MM-DD'\\) FROM entries").
+		WithArgs("abcdefg", "UTC", start, end).
+		WillReturnRows(sqlmock.NewRows([]string{"word_count", "goal_hit", "date"}).
+			AddRow(500, true, "2020-03-02").
+			AddRow(300, false, "2020-03-02").
+			AddRow(200, true, "2020-03-10"))
+
+	// Entries from before encryption are plaintext
+	rows := sqlmock.NewRows([]string{"id", "user_id", "word_count", "content", "created_at", "updated_at", "goal_hit", "version", "key_id", "client_encrypted", "kind", "title"}).
+		AddRow("1", "abcdefg", 500, "<p>The cat sat</p>", "2020-03-02", "2020-03-02", true, 1, nil, false, "DAILY", nil).
+		AddRow("2", "abcdefg", 200, "<p>the dog sat</p>", "2020-03-10", "2020-03-10", true, 1, nil, false, "DAILY", nil)
+	mock.ExpectQuery("SELECT (.+) FROM entries WHERE user_id \\= \\$1 AND word_count > 0 AND deleted_at IS NULL AND client_encrypted = false").
+		WithArgs("abcdefg", start, end).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT avg\\(extract\\(epoch FROM goal_hit_at - started_at\\)\\) / 60 FROM entries").
+		WithArgs("abcdefg", start, end).WillReturnRows(sqlmock.NewRows([]string{"avg"}).AddRow(nil))
+
+	stats, err := queryResolver.StatsRange(ctx, "2020-03-02", "2020-03-15", models.GranularityWeek)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*models.StatsBucket{
+		{Start: "2020-03-02", WordsWritten: 800, Entries: 2, GoalsHit: 1},
+		{Start: "2020-03-09", WordsWritten: 200, Entries: 1, GoalsHit: 1},
+	}, stats.Buckets)
+	assert.Equal(t, 1000, stats.WordsWritten)
+	assert.InDelta(t, 333.33, stats.AverageEntryLength, 0.01)
+	assert.Equal(t, 1.0, stats.GoalHitRate)
+	// Two of the twelve days that weren't Sundays
+	assert.InDelta(t, 2.0/12.0, stats.ConsistencyScore, 0.0001)
+	assert.Equal(t, 4, *stats.VocabularySize)
+	assert.Nil(t, stats.MinutesToGoal)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStatsRangeLeavesVocabularyOutOfLongRanges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{db: db, keys: keystore.New(db, "")},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	expectRangeSettings(mock)
+	mock.ExpectQuery("SELECT word_count, goal_hit, (.+) FROM entries").
+		WillReturnRows(sqlmock.NewRows([]string{"word_count", "goal_hit", "date"}).AddRow(500, true, "2019-06-03"))
+	mock.ExpectQuery("SELECT avg\\(extract\\(epoch FROM goal_hit_at - started_at\\)\\) / 60 FROM entries").
+		WillReturnRows(sqlmock.NewRows([]string{"avg"}).AddRow(12.5))
+
+	stats, err := queryResolver.StatsRange(ctx, "2019-01-01", "2019-12-31", models.GranularityMonth)
+
+	assert.Nil(t, err)
+	assert.Len(t, stats.Buckets, 12)
+	assert.Equal(t, 500, stats.Buckets[5].WordsWritten)
+	assert.Nil(t, stats.VocabularySize)
+	assert.Equal(t, 12.5, *stats.MinutesToGoal)
+
+	// No entry content was loaded
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStatsRangeGuardsDates(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		err  string
+	}{
+		{"unparseable start", "March 1st", "2020-03-31", "From must be formatted as YYYY-MM-DD"},
+		{"unparseable end", "2020-03-01", "2020-03", "To must be formatted as YYYY-MM-DD"},
+		{"ends before it starts", "2020-03-31", "2020-03-01", "Ranges must end after they start and cover at most 731 days"},
+		{"too long", "2017-01-01", "2020-01-01", "Ranges must end after they start and cover at most 731 days"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			queryResolver := &queryResolver{
+				Resolver: &Resolver{db: db},
+			}
+
+			ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+			expectRangeSettings(mock)
+
+			_, err = queryResolver.StatsRange(ctx, tt.from, tt.to, models.GranularityDay)
+
+			assert.EqualError(t, err, tt.err)
+
+			// Nothing is read for a range that is turned down
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+package resolvers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/writewithwrabit/server/auth"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/reminders"
+	"github.com/writewithwrabit/server/streaks"
+	"github.com/writewithwrabit/server/webhooks"
+)
+
+// Page sizes for the streak history
+const (
+	defaultStreaksPage = 20
+	maxStreaksPage     = 100
+)
+
+// streakSettings are the parts of a user that decide whether a streak survives a missed day
+type streakSettings struct {
+	location *time.Location
+	restDays []int
+	freezes  int
+}
+
+func (r *queryResolver) StreakStatus(ctx context.Context, userID string) (*models.StreakStatus, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return &models.StreakStatus{}, fmt.Errorf("Access denied")
+	}
+
+	return r.streakStatus(userID), nil
+}
+
+func (r *mutationResolver) UpdateRestDays(ctx context.Context, userID string, restDays []int) (*models.StreakStatus, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return &models.StreakStatus{}, fmt.Errorf("Access denied")
+	}
+
+	seen := map[int]bool{}
+	days := []int64{}
+	for _, day := range restDays {
+		if day < 0 || day > 6 {
+			return &models.StreakStatus{}, fmt.Errorf("Days of week must be between 0 (Sunday) and 6 (Saturday)")
+		}
+
+		if !seen[day] {
+			seen[day] = true
+			days = append(days, int64(day))
+		}
+	}
+
+	if len(days) > streaks.MaxRestDays {
+		return &models.StreakStatus{}, fmt.Errorf("Only %d rest days can be taken each week", streaks.MaxRestDays)
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i] < days[j] })
+
+	wrabitDB.LogAndExec(r.db, "UPDATE users SET rest_days = $1 WHERE firebase_id = $2", pq.Array(days), userID)
+
+	return r.streakStatus(userID), nil
+}
+
+func (r *queryResolver) Streaks(ctx context.Context, userID string, first *int, after *string) (*models.StreakConnection, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return &models.StreakConnection{}, fmt.Errorf("Access denied")
+	}
+
+	limit := defaultStreaksPage
+	if first != nil {
+		if *first < 1 || *first > maxStreaksPage {
+			return &models.StreakConnection{}, fmt.Errorf("First must be between 1 and %d", maxStreaksPage)
+		}
+
+		limit = *first
+	}
+
+	var afterID *string
+	if after != nil {
+		id, err := decodeCursor("streak", *after)
+		if err != nil {
+			return &models.StreakConnection{}, err
+		}
+
+		afterID = &id
+	}
+
+	connection := &models.StreakConnection{
+		Edges:    []*models.StreakEdge{},
+		PageInfo: &models.PageInfo{},
+	}
+
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT count(*) FROM streaks WHERE user_id = $1", userID)
+	if err := res.Scan(&connection.TotalCount); err != nil {
+		panic(err)
+	}
+
+	// Newest first, fetching one extra row to know if there is another page
+	location := r.userLocation(userID)
+	rows := wrabitDB.LogAndQuery(r.db, "SELECT id, user_id, day_count, last_entry_id, to_char(created_at AT TIME ZONE $2, 'YYYY-MM-DD'), to_char(updated_at AT TIME ZONE $2, 'YYYY-MM-DD'), created_at, updated_at FROM streaks WHERE user_id = $1 AND ($3::int IS NULL OR id < $3) ORDER BY id DESC LIMIT $4", userID, location.String(), afterID, limit+1)
+	defer rows.Close()
+
+	for rows.Next() {
+		var streak = new(models.Streak)
+		if err := rows.Scan(&streak.ID, &streak.UserID, &streak.DayCount, &streak.LastEntryID, &streak.StartDate, &streak.EndDate, &streak.CreatedAt, &streak.UpdatedAt); err != nil {
+			panic(err)
+		}
+
+		if len(connection.Edges) == limit {
+			connection.PageInfo.HasNextPage = true
+			break
+		}
+
+		cursor := encodeCursor("streak", streak.ID)
+		connection.Edges = append(connection.Edges, &models.StreakEdge{Cursor: cursor, Node: streak})
+		connection.PageInfo.EndCursor = &cursor
+	}
+
+	return connection, nil
+}
+
+func (r *queryResolver) WritingCalendar(ctx context.Context, userID string, year int) ([]*models.CalendarDay, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return []*models.CalendarDay{}, fmt.Errorf("Access denied")
+	}
+
+	if year < 1970 || year > time.Now().Year()+1 {
+		return []*models.CalendarDay{}, fmt.Errorf("Year is out of range")
+	}
+
+	settings := r.streakSettings(userID)
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, settings.location)
+	end := start.AddDate(1, 0, 0)
+
+	var days []*models.CalendarDay
+	byDate := map[string]*models.CalendarDay{}
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		calendarDay := &models.CalendarDay{
+			Date:    day.Format("2006-01-02"),
+			RestDay: streaks.IsRestDay(day, settings.restDays),
+		}
+		days = append(days, calendarDay)
+		byDate[calendarDay.Date] = calendarDay
+	}
+
+	res := wrabitDB.LogAndQuery(r.db, "SELECT to_char(created_at AT TIME ZONE $2, 'YYYY-MM-DD'), sum(word_count), bool_or(goal_hit) FROM entries WHERE user_id = $1 AND deleted_at IS NULL AND created_at >= $3 AND created_at < $4 GROUP BY 1", userID, settings.location.String(), start.UTC(), end.UTC())
+	for res.Next() {
+		var date string
+		var wordCount int
+		var goalHit bool
+		if err := res.Scan(&date, &wordCount, &goalHit); err != nil {
+			res.Close()
+			panic(err)
+		}
+
+		if day, ok := byDate[date]; ok {
+			day.WordCount = wordCount
+			day.GoalHit = goalHit
+		}
+	}
+	res.Close()
+
+	res = wrabitDB.LogAndQuery(r.db, "SELECT to_char(day, 'YYYY-MM-DD') FROM frozen_days WHERE user_id = $1 AND day >= $2 AND day < $3", userID, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	for res.Next() {
+		var date string
+		if err := res.Scan(&date); err != nil {
+			res.Close()
+			panic(err)
+		}
+
+		if day, ok := byDate[date]; ok {
+			day.Frozen = true
+		}
+	}
+	res.Close()
+
+	// Missed days the freeze pass hasn't reached yet show the way they will once it has
+	if latest := r.latestStreak(userID, settings.location); latest != nil {
+		pending, _ := latest.pending(settings)
+		for _, missed := range pending {
+			if day, ok := byDate[missed.Format("2006-01-02")]; ok {
+				day.Frozen = true
+			}
+		}
+	}
+
+	return days, nil
+}
+
+func (r *userResolver) CurrentStreak(ctx context.Context, obj *models.User) (int, error) {
+	if obj.FirebaseID == nil {
+		return 0, nil
+	}
+
+	return r.streakStatus(*obj.FirebaseID).DayCount, nil
+}
+
+func (r *Resolver) streakStatus(userID string) *models.StreakStatus {
+	settings := r.streakSettings(userID)
+
+	status := &models.StreakStatus{
+		RestDays:         settings.restDays,
+		FreezesRemaining: settings.freezes,
+	}
+
+	latest := r.latestStreak(userID, settings.location)
+	if latest == nil {
+		return status
+	}
+
+	lastGoalHitOn := latest.last.Format("2006-01-02")
+	status.LastGoalHitOn = &lastGoalHitOn
+
+	// Freezes the freeze pass hasn't spent yet are counted as spent
+	pending, ok := latest.pending(settings)
+	status.FreezesRemaining -= len(pending)
+	if !ok {
+		return status
+	}
+
+	covered := latest.covered
+	if len(pending) > 0 {
+		covered = pending[len(pending)-1]
+	}
+
+	today := streaks.Day(time.Now(), settings.location)
+	status.DayCount = latest.dayCount
+	if covered.Before(today) && !streaks.IsRestDay(today, settings.restDays) {
+		status.FreezesNeeded = 1
+	}
+	if streaks.AtRisk(covered, today, settings.restDays, status.FreezesRemaining) {
+		status.DaysAtRisk = latest.dayCount
+	}
+
+	return status
+}
+
+// latestStreak is the parts of a user's newest streak that decide whether it carries on
+type latestStreak struct {
+	dayCount int
+	// last is the day the goal was last hit
+	last time.Time
+	// covered is the last day the streak reaches, counting frozen days
+	covered time.Time
+}
+
+// latestStreak reads the user's newest streak, or nil if they haven't had one
+func (r *Resolver) latestStreak(userID string, location *time.Location) *latestStreak {
+	var latest latestStreak
+	var updatedAt time.Time
+	var lastFrozen sql.NullTime
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT s.day_count, s.updated_at, (SELECT max(f.day) FROM frozen_days f WHERE f.streak_id = s.id) FROM streaks s WHERE s.user_id = $1 ORDER BY s.created_at DESC LIMIT 1", userID)
+	err := res.Scan(&latest.dayCount, &updatedAt, &lastFrozen)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		panic(err)
+	}
+
+	latest.last = streaks.Day(updatedAt, location)
+	latest.covered = streaks.Covered(latest.last, lastFrozen)
+
+	return &latest
+}
+
+// pending lists the days before today the streak missed that streaks.FreezeMissed
+// hasn't spent a freeze on yet, and whether the streak survives them
+func (l *latestStreak) pending(settings *streakSettings) ([]time.Time, bool) {
+	today := streaks.Day(time.Now(), settings.location)
+	return streaks.Spend(l.covered, today, settings.restDays, settings.freezes)
+}
+
+// extendStreak counts a goal hit on the given day towards the user's streak.
+// Missed days are normally frozen by streaks.FreezeMissed already. Any it
+// hasn't reached yet are frozen here the same way, and a new streak is
+// started if the freezes run out first.
+// It returns the streak length and whether this entry extended it.
+func (r *mutationResolver) extendStreak(entry *models.Entry, date string) (int, bool) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	// Take the same lock as streaks.Lock so a save on another device or a
+	// recompute waits until this goal has been counted
+	settings := scanStreakSettings(wrabitDB.LogAndQueryRowTx(tx, "SELECT timezone, rest_days, streak_freezes FROM users WHERE firebase_id = $1 FOR UPDATE", entry.UserID))
+	today := streaks.ParseDay(date, settings.location)
+
+	// Get the latest streak for the user
+	var streak = new(models.Streak)
+	var updatedAt time.Time
+	var lastFrozen sql.NullTime
+	res := wrabitDB.LogAndQueryRowTx(tx, "SELECT s.id, s.user_id, s.day_count, s.last_entry_id, s.updated_at, (SELECT max(f.day) FROM frozen_days f WHERE f.streak_id = s.id) FROM streaks s WHERE s.user_id = $1 ORDER BY s.created_at DESC LIMIT 1", entry.UserID)
+	err = res.Scan(&streak.ID, &streak.UserID, &streak.DayCount, &streak.LastEntryID, &updatedAt, &lastFrozen)
+	if err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+
+	if err == nil && streak.LastEntryID == entry.ID {
+		return streak.DayCount, false
+	}
+
+	// From here on this is the first save that counts the entry's goal.
+	// Webhooks go out once the streak is saved.
+	day := today.Format("2006-01-02")
+	var events []func()
+	publishEvents := func() {
+		for _, publish := range events {
+			go publish()
+		}
+	}
+	events = append(events, func() {
+		r.publish(entry.UserID, models.WebhookEventEntryGoalHit, webhooks.GoalHit{EntryID: entry.ID, WordCount: entry.WordCount, Date: day})
+	})
+
+	continues := false
+	if err == nil {
+		covered := streaks.Covered(streaks.Day(updatedAt, settings.location), lastFrozen)
+
+		// Goals hit before the last day the streak reaches have already been counted
+		if today.Before(covered) {
+			commit(tx)
+			publishEvents()
+			return 0, false
+		}
+
+		var frozen []time.Time
+		frozen, continues = streaks.Spend(covered, today, settings.restDays, settings.freezes)
+		if len(frozen) > 0 {
+			if err := streaks.Freeze(tx, entry.UserID, streak.ID, frozen); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	// If no streak exists, create one
+	dayCount := 1
+	if !continues {
+		if err == nil {
+			broken := *streak
+			events = append(events, func() {
+				r.publish(entry.UserID, models.WebhookEventStreakBroken, webhooks.Streak{StreakID: broken.ID, DayCount: broken.DayCount, Date: day})
+			})
+		}
+
+		res := wrabitDB.LogAndQueryRowTx(tx, "INSERT INTO streaks (user_id, day_count, last_entry_id) VALUES ($1, $2, $3) RETURNING id", entry.UserID, dayCount, entry.ID)
+		if err := res.Scan(&streak.ID); err != nil {
+			panic(err)
+		}
+	} else {
+		dayCount = streak.DayCount + 1
+		res := wrabitDB.LogAndQueryRowTx(tx, "UPDATE streaks SET last_entry_id = $1, day_count = $2 WHERE id = $3 AND user_id = $4 RETURNING id", entry.ID, dayCount, streak.ID, entry.UserID)
+		if err := res.Scan(&streak.ID); err != nil {
+			panic(err)
+		}
+	}
+
+	extended := *streak
+	events = append(events, func() {
+		r.publish(entry.UserID, models.WebhookEventStreakExtended, webhooks.Streak{StreakID: extended.ID, DayCount: dayCount, Date: day})
+	})
+
+	if earned := streaks.Earned(dayCount); earned > 0 {
+		if _, err := wrabitDB.LogAndExecTx(tx, "UPDATE users SET streak_freezes = LEAST(streak_freezes + $1, $2) WHERE firebase_id = $3", earned, streaks.MaxFreezes, entry.UserID); err != nil {
+			panic(err)
+		}
+	}
+
+	commit(tx)
+	publishEvents()
+
+	return dayCount, true
+}
+
+func (r *Resolver) streakSettings(userID string) *streakSettings {
+	return scanStreakSettings(wrabitDB.LogAndQueryRow(r.db, "SELECT timezone, rest_days, streak_freezes FROM users WHERE firebase_id = $1", userID))
+}
+
+// scanStreakSettings reads the timezone, rest days and freezes selected from a user
+func scanStreakSettings(row scanner) *streakSettings {
+	var timezone string
+	var restDays []int64
+	settings := &streakSettings{restDays: []int{}}
+
+	if err := row.Scan(&timezone, pq.Array(&restDays), &settings.freezes); err != nil && err != sql.ErrNoRows {
+		panic(err)
+	}
+
+	settings.location = reminders.Location(timezone)
+	for _, day := range restDays {
+		settings.restDays = append(settings.restDays, int(day))
+	}
+
+	return settings
+}
+
+// commit ends a transaction whose statements all succeeded
+func commit(tx *sql.Tx) {
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// encodeCursor builds an opaque pagination cursor for a row
+func encodeCursor(kind string, id string) string {
+	return base64.StdEncoding.EncodeToString([]byte(kind + ":" + id))
+}
+
+func decodeCursor(kind string, cursor string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(decoded), kind+":") {
+		return "", fmt.Errorf("Invalid cursor")
+	}
+
+	id := strings.TrimPrefix(string(decoded), kind+":")
+	if _, err := strconv.Atoi(id); err != nil {
+		return "", fmt.Errorf("Invalid cursor")
+	}
+
+	return id, nil
+}
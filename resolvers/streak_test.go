@@ -0,0 +1,407 @@
+package resolvers
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	firebase "firebase.google.com/go/auth"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/writewithwrabit/server/auth"
+	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/streaks"
+)
+
+func TestStreaksPaginates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{db: db},
+	}
+
+	token := &firebase.Token{
+		Subject: "abcdefg",
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, token)
+
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM streaks WHERE user_id \\= \\$1").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery("SELECT timezone FROM users WHERE firebase_id \\= \\$1").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone"}).AddRow("UTC"))
+
+	rows := sqlmock.NewRows([]string{"id", "user_id", "day_count", "last_entry_id", "start_date", "end_date", "created_at", "updated_at"}).
+		AddRow("9", "abcdefg", 3, "30", "2020-03-01", "2020-03-03", "2020-03-01", "2020-03-03").
+		AddRow("8", "abcdefg", 7, "20", "2020-02-01", "2020-02-07", "2020-02-01", "2020-02-07")
+	mock.ExpectQuery("SELECT (.+) FROM streaks WHERE user_id \\= \\$1 AND \\(\\$3::int IS NULL OR id < \\$3\\) ORDER BY id DESC LIMIT \\$4").
+		WithArgs("abcdefg", "UTC", "10", 2).WillReturnRows(rows)
+
+	first := 1
+	after := encodeCursor("streak", "10")
+	connection, err := queryResolver.Streaks(ctx, "abcdefg", &first, &after)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, connection.TotalCount)
+	assert.Len(t, connection.Edges, 1)
+	assert.Equal(t, "2020-03-01", connection.Edges[0].Node.StartDate)
+	assert.True(t, connection.PageInfo.HasNextPage)
+	assert.Equal(t, encodeCursor("streak", "9"), *connection.PageInfo.EndCursor)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStreaksRejectsForeignCursors(t *testing.T) {
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	after := encodeCursor("entry", "10")
+	_, err := queryResolver.Streaks(ctx, "abcdefg", nil, &after)
+
+	assert.EqualError(t, err, "Invalid cursor")
+}
+
+func TestStreaksRejectsCursorsWithoutAnID(t *testing.T) {
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	// Would otherwise fail the ::int cast in the query
+	after := base64.StdEncoding.EncodeToString([]byte("streak:x"))
+	_, err := queryResolver.Streaks(ctx, "abcdefg", nil, &after)
+
+	assert.EqualError(t, err, "Invalid cursor")
+}
+
+func TestStreaksOfAnotherUser(t *testing.T) {
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	_, err := queryResolver.Streaks(ctx, "someoneelse", nil, nil)
+
+	assert.EqualError(t, err, "Access denied")
+}
+
+func TestWritingCalendar(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{db: db},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	// Sundays are rest days
+	mock.ExpectQuery("SELECT timezone, rest_days, streak_freezes FROM users WHERE firebase_id \\= \\$1").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone", "rest_days", "streak_freezes"}).AddRow("America/Toronto", "{0}", 1))
+
+	// The year runs from midnight in the user's timezone
+	start := time.Date(2020, time.January, 1, 5, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT to_char\\(created_at AT TIME ZONE \\$2, 'YYYY-MM-DD'\\), sum\\(word_count\\), bool_or\\(goal_hit\\) FROM entries").
+		WithArgs("abcdefg", "America/Toronto", start, start.AddDate(1, 0, 0)).
+		WillReturnRows(sqlmock.NewRows([]string{"date", "sum", "bool_or"}).
+			AddRow("2020-01-01", 1200, true).
+			AddRow("2020-01-02", 300, false))
+	mock.ExpectQuery("SELECT to_char\\(day, 'YYYY-MM-DD'\\) FROM frozen_days").
+		WithArgs("abcdefg", "2020-01-01", "2021-01-01").
+		WillReturnRows(sqlmock.NewRows([]string{"day"}).AddRow("2020-01-03"))
+	mock.ExpectQuery("SELECT s.day_count, s.updated_at, (.+) FROM streaks s WHERE s.user_id \\= \\$1").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"day_count", "updated_at", "max"}))
+
+	days, err := queryResolver.WritingCalendar(ctx, "abcdefg", 2020)
+
+	assert.Nil(t, err)
+	assert.Len(t, days, 366)
+	assert.Equal(t, &models.CalendarDay{Date: "2020-01-01", WordCount: 1200, GoalHit: true}, days[0])
+	assert.Equal(t, &models.CalendarDay{Date: "2020-01-02", WordCount: 300}, days[1])
+	assert.Equal(t, &models.CalendarDay{Date: "2020-01-03", Frozen: true}, days[2])
+	assert.Equal(t, &models.CalendarDay{Date: "2020-01-05", RestDay: true}, days[4])
+	assert.Equal(t, "2020-12-31", days[365].Date)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWritingCalendarShowsFreezesNotYetSpent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{db: db},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	// The goal was last hit three days ago and the day after was already frozen
+	today := streaks.Day(time.Now(), time.UTC)
+	yesterday := today.AddDate(0, 0, -1)
+	mock.ExpectQuery("SELECT timezone, rest_days, streak_freezes FROM users WHERE firebase_id \\= \\$1").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone", "rest_days", "streak_freezes"}).AddRow("UTC", "{}", 1))
+	mock.ExpectQuery("SELECT to_char\\(created_at AT TIME ZONE \\$2, 'YYYY-MM-DD'\\), sum\\(word_count\\), bool_or\\(goal_hit\\) FROM entries").
+		WillReturnRows(sqlmock.NewRows([]string{"date", "sum", "bool_or"}))
+	mock.ExpectQuery("SELECT to_char\\(day, 'YYYY-MM-DD'\\) FROM frozen_days").
+		WillReturnRows(sqlmock.NewRows([]string{"day"}))
+	mock.ExpectQuery("SELECT s.day_count, s.updated_at, (.+) FROM streaks s WHERE s.user_id \\= \\$1").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"day_count", "updated_at", "max"}).AddRow(4, today.AddDate(0, 0, -3), today.AddDate(0, 0, -2)))
+
+	days, err := queryResolver.WritingCalendar(ctx, "abcdefg", yesterday.Year())
+
+	assert.Nil(t, err)
+	assert.True(t, days[yesterday.YearDay()-1].Frozen)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWritingCalendarYearOutOfRange(t *testing.T) {
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	_, err := queryResolver.WritingCalendar(ctx, "abcdefg", time.Now().Year()+2)
+
+	assert.EqualError(t, err, "Year is out of range")
+}
+
+func TestCurrentStreak(t *testing.T) {
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+	lastWeek := time.Now().UTC().AddDate(0, 0, -7)
+
+	tests := []struct {
+		name       string
+		updatedAt  time.Time
+		lastFrozen interface{}
+		freezes    int
+		expected   int
+	}{
+		{"goal hit yesterday", yesterday, nil, 0, 4},
+		{"missed days without freezes", lastWeek, nil, 0, 0},
+		{"missed days frozen already", lastWeek, yesterday, 0, 4},
+		{"missed days not frozen yet", lastWeek, nil, 6, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			userResolver := &userResolver{
+				Resolver: &Resolver{db: db},
+			}
+
+			mock.ExpectQuery("SELECT timezone, rest_days, streak_freezes FROM users WHERE firebase_id \\= \\$1").
+				WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone", "rest_days", "streak_freezes"}).AddRow("UTC", "{}", tt.freezes))
+			mock.ExpectQuery("SELECT s.day_count, s.updated_at, (.+) FROM streaks s WHERE s.user_id \\= \\$1").
+				WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"day_count", "updated_at", "max"}).AddRow(4, tt.updatedAt, tt.lastFrozen))
+
+			firebaseID := "abcdefg"
+			dayCount, err := userResolver.CurrentStreak(context.Background(), &models.User{FirebaseID: &firebaseID})
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, dayCount)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestStreakStatusCountsFreezesNotYetSpent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	queryResolver := &queryResolver{
+		Resolver: &Resolver{db: db},
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	// The last two days were missed and the freeze pass hasn't reached them yet
+	today := streaks.Day(time.Now(), time.UTC)
+	mock.ExpectQuery("SELECT timezone, rest_days, streak_freezes FROM users WHERE firebase_id \\= \\$1").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone", "rest_days", "streak_freezes"}).AddRow("UTC", "{}", 2))
+	mock.ExpectQuery("SELECT s.day_count, s.updated_at, (.+) FROM streaks s WHERE s.user_id \\= \\$1").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"day_count", "updated_at", "max"}).AddRow(4, today.AddDate(0, 0, -3), nil))
+
+	status, err := queryResolver.StreakStatus(ctx, "abcdefg")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 4, status.DayCount)
+	assert.Equal(t, 0, status.FreezesRemaining)
+	assert.Equal(t, 1, status.FreezesNeeded)
+	assert.Equal(t, 4, status.DaysAtRisk)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCurrentStreakWithoutUser(t *testing.T) {
+	userResolver := &userResolver{
+		Resolver: &Resolver{},
+	}
+
+	dayCount, err := userResolver.CurrentStreak(context.Background(), &models.User{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, dayCount)
+}
+
+// expectLatestStreak locks the user and finds the streak they last extended
+// along with the last day frozen for it, if any
+func expectLatestStreak(mock sqlmock.Sqlmock, freezes int, dayCount int, updatedAt time.Time, lastFrozen interface{}) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT timezone, rest_days, streak_freezes FROM users WHERE firebase_id \\= \\$1 FOR UPDATE").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"timezone", "rest_days", "streak_freezes"}).AddRow("UTC", "{}", freezes))
+	mock.ExpectQuery("SELECT s.id, s.user_id, s.day_count, s.last_entry_id, s.updated_at, (.+) FROM streaks s").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "day_count", "last_entry_id", "updated_at", "max"}).AddRow("3", "abcdefg", dayCount, "9", updatedAt, lastFrozen))
+}
+
+func TestExtendStreakSpendsFreezes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db},
+	}
+
+	// The 3rd and 4th were missed and the freeze pass hasn't reached them yet
+	expectLatestStreak(mock, 2, 4, time.Date(2020, 3, 2, 12, 0, 0, 0, time.UTC), nil)
+	mock.ExpectExec("INSERT INTO frozen_days").WithArgs("abcdefg", "3", "2020-03-03").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO frozen_days").WithArgs("abcdefg", "3", "2020-03-04").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE users SET streak_freezes \\= streak_freezes - \\$1 WHERE firebase_id \\= \\$2").
+		WithArgs(2, "abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("UPDATE streaks SET last_entry_id \\= \\$1, day_count \\= \\$2").
+		WithArgs("10", 5, "3", "abcdefg").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("3"))
+	mock.ExpectCommit()
+
+	dayCount, extended := mutResolver.extendStreak(&models.Entry{ID: "10", UserID: "abcdefg"}, "2020-03-05")
+
+	assert.Equal(t, 5, dayCount)
+	assert.True(t, extended)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestExtendStreakWithoutEnoughFreezes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db},
+	}
+
+	// Two days were missed with only one freeze, which still goes on the first of them
+	expectLatestStreak(mock, 1, 4, time.Date(2020, 3, 2, 12, 0, 0, 0, time.UTC), nil)
+	mock.ExpectExec("INSERT INTO frozen_days").WithArgs("abcdefg", "3", "2020-03-03").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE users SET streak_freezes \\= streak_freezes - \\$1 WHERE firebase_id \\= \\$2").
+		WithArgs(1, "abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO streaks \\(user_id, day_count, last_entry_id\\)").
+		WithArgs("abcdefg", 1, "10").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("4"))
+	mock.ExpectCommit()
+
+	dayCount, extended := mutResolver.extendStreak(&models.Entry{ID: "10", UserID: "abcdefg"}, "2020-03-05")
+
+	assert.Equal(t, 1, dayCount)
+	assert.True(t, extended)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestExtendStreakAfterMissedDaysWereFrozen(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db},
+	}
+
+	// The freeze pass already spent the last freezes on the 3rd and 4th
+	expectLatestStreak(mock, 0, 4, time.Date(2020, 3, 2, 12, 0, 0, 0, time.UTC), time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC))
+	mock.ExpectQuery("UPDATE streaks SET last_entry_id \\= \\$1, day_count \\= \\$2").
+		WithArgs("10", 5, "3", "abcdefg").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("3"))
+	mock.ExpectCommit()
+
+	dayCount, extended := mutResolver.extendStreak(&models.Entry{ID: "10", UserID: "abcdefg"}, "2020-03-05")
+
+	assert.Equal(t, 5, dayCount)
+	assert.True(t, extended)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestExtendStreakEarnsFreezesUpToTheCap(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mutResolver := &mutationResolver{
+		Resolver: &Resolver{db: db},
+	}
+
+	expectLatestStreak(mock, streaks.MaxFreezes, 6, time.Date(2020, 3, 4, 12, 0, 0, 0, time.UTC), nil)
+	mock.ExpectQuery("UPDATE streaks SET last_entry_id \\= \\$1, day_count \\= \\$2").
+		WithArgs("10", 7, "3", "abcdefg").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("3"))
+	mock.ExpectExec("UPDATE users SET streak_freezes \\= LEAST\\(streak_freezes \\+ \\$1, \\$2\\) WHERE firebase_id \\= \\$3").
+		WithArgs(1, streaks.MaxFreezes, "abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	dayCount, extended := mutResolver.extendStreak(&models.Entry{ID: "10", UserID: "abcdefg"}, "2020-03-05")
+
+	assert.Equal(t, 7, dayCount)
+	assert.True(t, extended)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,173 @@
+package resolvers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/writewithwrabit/server/auth"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/keystore"
+	"github.com/writewithwrabit/server/models"
+)
+
+const tagColumns = "t.id, t.user_id, t.name, t.created_at, t.updated_at"
+
+func (r *queryResolver) Tags(ctx context.Context, userID string) ([]*models.Tag, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return []*models.Tag{}, fmt.Errorf("Access denied")
+	}
+
+	res := wrabitDB.LogAndQuery(r.db, "SELECT "+tagColumns+", count(e.id) FROM tags t LEFT JOIN entry_tags et ON et.tag_id = t.id LEFT JOIN entries e ON e.id = et.entry_id AND e.deleted_at IS NULL WHERE t.user_id = $1 GROUP BY t.id ORDER BY count(e.id) DESC, t.created_at", userID)
+	defer res.Close()
+
+	tags := []*models.Tag{}
+	for res.Next() {
+		var tag = new(models.Tag)
+		if err := res.Scan(&tag.ID, &tag.UserID, &tag.Name, &tag.CreatedAt, &tag.UpdatedAt, &tag.EntryCount); err != nil {
+			panic(err)
+		}
+
+		tags = append(tags, tag)
+	}
+
+	r.openTags(userID, tags...)
+
+	return tags, nil
+}
+
+func (r *entryResolver) Tags(ctx context.Context, obj *models.Entry) ([]*models.Tag, error) {
+	res := wrabitDB.LogAndQuery(r.db, "SELECT "+tagColumns+" FROM tags t JOIN entry_tags et ON et.tag_id = t.id WHERE et.entry_id = $1 ORDER BY et.created_at", obj.ID)
+	defer res.Close()
+
+	tags := []*models.Tag{}
+	for res.Next() {
+		var tag = new(models.Tag)
+		if err := res.Scan(&tag.ID, &tag.UserID, &tag.Name, &tag.CreatedAt, &tag.UpdatedAt); err != nil {
+			panic(err)
+		}
+
+		tags = append(tags, tag)
+	}
+
+	r.openTags(obj.UserID, tags...)
+
+	return tags, nil
+}
+
+func (r *mutationResolver) AddEntryTags(ctx context.Context, entryID string, tags []string) (*models.Entry, error) {
+	entry, err := r.taggableEntry(ctx, entryID)
+	if err != nil {
+		return &models.Entry{}, err
+	}
+
+	key, err := r.keys.UserKey(entry.UserID)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, name := range tags {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		// The name hash is what makes a tag unique so only the first spelling is kept
+		var tagID string
+		res := wrabitDB.LogAndQueryRow(r.db, "INSERT INTO tags (user_id, name, name_hash) VALUES ($1, $2, $3) ON CONFLICT (user_id, name_hash) DO UPDATE SET user_id = EXCLUDED.user_id RETURNING id", entry.UserID, keystore.Seal(name, key), tagHash(key, name))
+		if err := res.Scan(&tagID); err != nil {
+			panic(err)
+		}
+
+		wrabitDB.LogAndExec(r.db, "INSERT INTO entry_tags (entry_id, tag_id, user_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", entry.ID, tagID, entry.UserID)
+	}
+
+	return entry, nil
+}
+
+func (r *mutationResolver) RemoveEntryTags(ctx context.Context, entryID string, tags []string) (*models.Entry, error) {
+	entry, err := r.taggableEntry(ctx, entryID)
+	if err != nil {
+		return &models.Entry{}, err
+	}
+
+	// A user without a key has never tagged anything
+	key, err := r.keys.FindUserKey(entry.UserID)
+	if err == keystore.ErrNoKey {
+		return entry, nil
+	} else if err != nil {
+		panic(err)
+	}
+
+	var hashes []string
+	for _, name := range tags {
+		hashes = append(hashes, tagHash(key, name))
+	}
+
+	wrabitDB.LogAndExec(r.db, "DELETE FROM entry_tags WHERE entry_id = $1 AND tag_id IN (SELECT id FROM tags WHERE user_id = $2 AND name_hash = ANY($3))", entry.ID, entry.UserID, pq.Array(hashes))
+
+	// Tags no longer on any entry are removed so the list doesn't fill up with old names
+	wrabitDB.LogAndExec(r.db, "DELETE FROM tags t WHERE t.user_id = $1 AND t.name_hash = ANY($2) AND NOT EXISTS (SELECT 1 FROM entry_tags et WHERE et.tag_id = t.id)", entry.UserID, pq.Array(hashes))
+
+	return entry, nil
+}
+
+// taggableEntry loads an entry owned by the current user
+func (r *mutationResolver) taggableEntry(ctx context.Context, entryID string) (*models.Entry, error) {
+	user := auth.ForContext(ctx)
+	if user == nil {
+		return nil, fmt.Errorf("Access denied")
+	}
+
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT "+entryColumns+" FROM entries WHERE id = $1 AND deleted_at IS NULL", entryID)
+
+	var entry = new(models.Entry)
+	err := scanEntry(res, entry)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("Entry not found")
+	} else if err != nil {
+		panic(err)
+	}
+
+	if entry.UserID != user.Subject {
+		return nil, fmt.Errorf("Access denied")
+	}
+
+	r.openEntries(entry)
+
+	return entry, nil
+}
+
+// openTags decrypts tag names in place
+func (r *Resolver) openTags(userID string, tags ...*models.Tag) {
+	if len(tags) == 0 {
+		return
+	}
+
+	// Tags are only ever written with the user's key, so it exists if they do
+	key, err := r.keys.FindUserKey(userID)
+	if err == keystore.ErrNoKey {
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	for _, tag := range tags {
+		tag.Name = keystore.Open(tag.Name, key)
+	}
+}
+
+// tagHash is a keyed hash of the normalized tag name.
+// It lets tags be matched and kept unique without storing the name in the clear.
+func tagHash(key *[32]byte, name string) string {
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte("tag:" + strings.ToLower(strings.TrimSpace(name))))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,220 @@
+package resolvers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"testing"
+
+	firebase "firebase.google.com/go/auth"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/writewithwrabit/server/auth"
+	cryptopasta "github.com/writewithwrabit/server/cryptopasta"
+	"github.com/writewithwrabit/server/keystore"
+)
+
+const tagMasterKey = "01234567890123456789012345678901"
+
+func newTagResolver(t *testing.T) (*sql.DB, *Resolver, sqlmock.Sqlmock, context.Context) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	ctx := context.WithValue(context.Background(), auth.UserCtxKey, &firebase.Token{Subject: "abcdefg"})
+
+	return db, &Resolver{db: db, keys: keystore.New(db, tagMasterKey)}, mock, ctx
+}
+
+// expectUserKey finds the user key that was already created for them
+func expectUserKey(t *testing.T, mock sqlmock.Sqlmock, key *[32]byte) {
+	master := [32]byte{}
+	copy(master[:], tagMasterKey)
+	wrapped, err := cryptopasta.Encrypt(key[:], &master)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT wrapped_key FROM encryption_keys WHERE user_id \\= \\$1 AND user_key").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"wrapped_key"}).AddRow(hex.EncodeToString(wrapped)))
+}
+
+// expectTaggableEntry finds a client encrypted entry so nothing needs opening
+func expectTaggableEntry(mock sqlmock.Sqlmock, userID string) {
+	rows := sqlmock.NewRows([]string{"id", "user_id", "word_count", "content", "created_at", "updated_at", "goal_hit", "version", "key_id", "client_encrypted", "kind", "title"}).
+		AddRow("1", userID, 20, "ciphertext", "2020-01-01", "2020-01-01", false, 1, nil, true, "DAILY", nil)
+	mock.ExpectQuery("SELECT (.+) FROM entries WHERE id \\= \\$1 AND deleted_at IS NULL").
+		WithArgs("1").WillReturnRows(rows)
+}
+
+func TestAddEntryTags(t *testing.T) {
+	db, resolver, mock, ctx := newTagResolver(t)
+	defer db.Close()
+
+	expectTaggableEntry(mock, "abcdefg")
+	key := cryptopasta.NewEncryptionKey()
+	expectUserKey(t, mock, key)
+
+	// Names are matched however they were spelled
+	mock.ExpectQuery("INSERT INTO tags \\(user_id, name, name_hash\\)").
+		WithArgs("abcdefg", sqlmock.AnyArg(), tagHash(key, "travel")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("4"))
+	mock.ExpectExec("INSERT INTO entry_tags \\(entry_id, tag_id, user_id\\)").
+		WithArgs("1", "4", "abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	entry, err := (&mutationResolver{resolver}).AddEntryTags(ctx, "1", []string{" Travel ", ""})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "1", entry.ID)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAddEntryTagsToAnotherUsersEntry(t *testing.T) {
+	db, resolver, mock, ctx := newTagResolver(t)
+	defer db.Close()
+
+	expectTaggableEntry(mock, "someoneelse")
+
+	_, err := (&mutationResolver{resolver}).AddEntryTags(ctx, "1", []string{"travel"})
+
+	assert.Equal(t, "Access denied", err.Error())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRemoveEntryTags(t *testing.T) {
+	db, resolver, mock, ctx := newTagResolver(t)
+	defer db.Close()
+
+	expectTaggableEntry(mock, "abcdefg")
+	key := cryptopasta.NewEncryptionKey()
+	expectUserKey(t, mock, key)
+
+	mock.ExpectExec("DELETE FROM entry_tags WHERE entry_id \\= \\$1").
+		WithArgs("1", "abcdefg", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM tags t WHERE t.user_id \\= \\$1 AND t.name_hash \\= ANY\\(\\$2\\) AND NOT EXISTS").
+		WithArgs("abcdefg", "{\""+tagHash(key, "travel")+"\"}").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err := (&mutationResolver{resolver}).RemoveEntryTags(ctx, "1", []string{"Travel"})
+
+	assert.Nil(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRemoveEntryTagsWithoutUserKey(t *testing.T) {
+	db, resolver, mock, ctx := newTagResolver(t)
+	defer db.Close()
+
+	expectTaggableEntry(mock, "abcdefg")
+	mock.ExpectQuery("SELECT wrapped_key FROM encryption_keys WHERE user_id \\= \\$1 AND user_key").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"wrapped_key"}))
+
+	// No key is created and nothing is deleted
+	_, err := (&mutationResolver{resolver}).RemoveEntryTags(ctx, "1", []string{"travel"})
+
+	assert.Nil(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	db, resolver, mock, ctx := newTagResolver(t)
+	defer db.Close()
+
+	key := cryptopasta.NewEncryptionKey()
+	mock.ExpectQuery("SELECT (.+), count\\(e.id\\) FROM tags t").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "created_at", "updated_at", "count"}).
+			AddRow("4", "abcdefg", keystore.Seal("travel", key), "2020-01-01", "2020-01-01", 2))
+	expectUserKey(t, mock, key)
+
+	tags, err := (&queryResolver{resolver}).Tags(ctx, "abcdefg")
+
+	assert.Nil(t, err)
+	assert.Len(t, tags, 1)
+	assert.Equal(t, "travel", tags[0].Name)
+	assert.Equal(t, 2, tags[0].EntryCount)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTagsOfAnotherUser(t *testing.T) {
+	db, resolver, mock, ctx := newTagResolver(t)
+	defer db.Close()
+
+	_, err := (&queryResolver{resolver}).Tags(ctx, "someoneelse")
+
+	assert.Equal(t, "Access denied", err.Error())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestEntriesByTag(t *testing.T) {
+	db, resolver, mock, ctx := newTagResolver(t)
+	defer db.Close()
+
+	key := cryptopasta.NewEncryptionKey()
+	expectUserKey(t, mock, key)
+	rows := sqlmock.NewRows([]string{"id", "user_id", "word_count", "content", "created_at", "updated_at", "goal_hit", "version", "key_id", "client_encrypted", "kind", "title"}).
+		AddRow("1", "abcdefg", 20, "ciphertext", "2020-01-01", "2020-01-01", false, 1, nil, true, "DAILY", nil)
+	mock.ExpectQuery("SELECT (.+) FROM entries WHERE user_id \\= \\$1 (.+) AND id IN \\(SELECT et.entry_id FROM entry_tags et JOIN tags t ON t.id \\= et.tag_id WHERE t.user_id \\= \\$1 AND t.name_hash \\= \\$2\\)").
+		WithArgs("abcdefg", tagHash(key, "travel")).WillReturnRows(rows)
+
+	tag := "Travel"
+	entries, err := (&queryResolver{resolver}).EntriesByUserID(ctx, "abcdefg", nil, nil, &tag, nil)
+
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestEntriesByTagOfAnotherUser(t *testing.T) {
+	db, resolver, mock, ctx := newTagResolver(t)
+	defer db.Close()
+
+	// Hashing with their key would create it for them
+	tag := "travel"
+	_, err := (&queryResolver{resolver}).EntriesByUserID(ctx, "someoneelse", nil, nil, &tag, nil)
+
+	assert.Equal(t, "Access denied", err.Error())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestEntriesByTagWithoutUserKey(t *testing.T) {
+	db, resolver, mock, ctx := newTagResolver(t)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT wrapped_key FROM encryption_keys WHERE user_id \\= \\$1 AND user_key").
+		WithArgs("abcdefg").WillReturnRows(sqlmock.NewRows([]string{"wrapped_key"}))
+
+	tag := "travel"
+	entries, err := (&queryResolver{resolver}).EntriesByUserID(ctx, "abcdefg", nil, nil, &tag, nil)
+
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
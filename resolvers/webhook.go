@@ -0,0 +1,185 @@
+package resolvers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/writewithwrabit/server/auth"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/models"
+	"github.com/writewithwrabit/server/webhooks"
+)
+
+// How many webhooks a user can register
+const maxWebhooks = 10
+
+// How many deliveries are listed by default and at most
+const (
+	defaultDeliveries = 20
+	maxDeliveries     = 100
+)
+
+const webhookColumns = "id, user_id, url, events, secret, created_at, updated_at"
+
+// publish tells the user's webhooks about an event. It is meant to run in its own goroutine.
+func (r *Resolver) publish(userID string, event models.WebhookEvent, data interface{}) {
+	if err := r.webhooks.Publish(context.Background(), userID, event, data); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (r *queryResolver) Webhooks(ctx context.Context, userID string) ([]*models.Webhook, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return []*models.Webhook{}, fmt.Errorf("Access denied")
+	}
+
+	res := wrabitDB.LogAndQuery(r.db, "SELECT "+webhookColumns+" FROM webhooks WHERE user_id = $1 ORDER BY created_at", userID)
+	defer res.Close()
+
+	hooks := []*models.Webhook{}
+	for res.Next() {
+		var hook = new(models.Webhook)
+		if err := scanWebhook(res, hook); err != nil {
+			panic(err)
+		}
+
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, nil
+}
+
+// WebhookDeliveries lists a webhook's most recent deliveries first
+func (r *queryResolver) WebhookDeliveries(ctx context.Context, userID string, webhookID string, limit *int) ([]*models.WebhookDelivery, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return []*models.WebhookDelivery{}, fmt.Errorf("Access denied")
+	}
+
+	count := defaultDeliveries
+	if limit != nil && *limit > 0 {
+		count = *limit
+	}
+	if count > maxDeliveries {
+		count = maxDeliveries
+	}
+
+	res := wrabitDB.LogAndQuery(r.db, "SELECT "+webhooks.DeliveryColumns+" FROM webhook_deliveries WHERE webhook_id = $1 AND user_id = $2 ORDER BY created_at DESC, id DESC LIMIT $3", webhookID, userID, count)
+	defer res.Close()
+
+	deliveries := []*models.WebhookDelivery{}
+	for res.Next() {
+		var delivery = new(models.WebhookDelivery)
+		if err := webhooks.ScanDelivery(res, delivery); err != nil {
+			panic(err)
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+func (r *mutationResolver) CreateWebhook(ctx context.Context, input models.NewWebhook) (*models.Webhook, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != input.UserID {
+		return &models.Webhook{}, fmt.Errorf("Access denied")
+	}
+
+	url := strings.TrimSpace(input.URL)
+	if err := webhooks.ValidURL(url); err != nil {
+		return &models.Webhook{}, err
+	}
+
+	if len(input.Events) == 0 {
+		return &models.Webhook{}, fmt.Errorf("Webhooks need at least one event")
+	}
+
+	var count int
+	res := wrabitDB.LogAndQueryRow(r.db, "SELECT count(*) FROM webhooks WHERE user_id = $1", input.UserID)
+	if err := res.Scan(&count); err != nil {
+		panic(err)
+	}
+
+	if count >= maxWebhooks {
+		return &models.Webhook{}, fmt.Errorf("You can only have %d webhooks, delete one first", maxWebhooks)
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		panic(err)
+	}
+
+	var events []string
+	seen := map[models.WebhookEvent]bool{}
+	for _, event := range input.Events {
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, string(event))
+		}
+	}
+
+	hook := new(models.Webhook)
+	res = wrabitDB.LogAndQueryRow(r.db, "INSERT INTO webhooks (user_id, url, events, secret) VALUES ($1, $2, $3, $4) RETURNING "+webhookColumns, input.UserID, url, pq.Array(events), secret)
+	if err := scanWebhook(res, hook); err != nil {
+		panic(err)
+	}
+
+	return hook, nil
+}
+
+// DeleteWebhook removes the webhook and its delivery log. Pending retries are dropped with it.
+func (r *mutationResolver) DeleteWebhook(ctx context.Context, userID string, id string) (*models.Webhook, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return &models.Webhook{}, fmt.Errorf("Access denied")
+	}
+
+	hook := new(models.Webhook)
+	res := wrabitDB.LogAndQueryRow(r.db, "DELETE FROM webhooks WHERE id = $1 AND user_id = $2 RETURNING "+webhookColumns, id, userID)
+	err := scanWebhook(res, hook)
+	if err == sql.ErrNoRows {
+		return &models.Webhook{}, webhooks.ErrNotFound
+	} else if err != nil {
+		panic(err)
+	}
+
+	wrabitDB.LogAndExec(r.db, "DELETE FROM webhook_deliveries WHERE webhook_id = $1 AND user_id = $2", id, userID)
+
+	return hook, nil
+}
+
+// TestWebhook sends a test event and returns how the delivery went
+func (r *mutationResolver) TestWebhook(ctx context.Context, userID string, id string) (*models.WebhookDelivery, error) {
+	user := auth.ForContext(ctx)
+	if user == nil || user.Subject != userID {
+		return &models.WebhookDelivery{}, fmt.Errorf("Access denied")
+	}
+
+	delivery, err := r.webhooks.Test(ctx, userID, id)
+	if err == webhooks.ErrNotFound {
+		return &models.WebhookDelivery{}, err
+	} else if err != nil {
+		panic(err)
+	}
+
+	return delivery, nil
+}
+
+func scanWebhook(row scanner, hook *models.Webhook) error {
+	var events []string
+	if err := row.Scan(&hook.ID, &hook.UserID, &hook.URL, pq.Array(&events), &hook.Secret, &hook.CreatedAt, &hook.UpdatedAt); err != nil {
+		return err
+	}
+
+	hook.Events = []models.WebhookEvent{}
+	for _, event := range events {
+		hook.Events = append(hook.Events, models.WebhookEvent(event))
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/models"
+)
+
+// How far back the nightly reconciliation looks for entries the rollups may have missed
+const reconcileWindow = 48 * time.Hour
+
+// hourCounts builds the per hour entry counts stored with each day
+func hourCounts() string {
+	var counts []string
+	for hour := 0; hour < 24; hour++ {
+		counts = append(counts, fmt.Sprintf("count(*) FILTER (WHERE date_part('hour', e.updated_at AT TIME ZONE d.timezone) = %d)", hour))
+	}
+
+	return "ARRAY[" + strings.Join(counts, ", ") + "]"
+}
+
+// The local day an entry written at $2 by user $1 counts towards
+const rollupDay = "SELECT firebase_id, timezone, ($2::timestamptz AT TIME ZONE timezone)::date AS day FROM users WHERE firebase_id = $1"
+
+// refreshQuery upserts the day's totals, or clears the day once its last entry
+// is gone. It is a single statement so readers never see the day missing.
+var refreshQuery = "WITH d AS (" + rollupDay + "), " +
+	"totals AS (SELECT e.user_id, d.day, sum(e.word_count) AS words_written, count(*) AS entries, max(e.word_count) AS longest_entry, bool_or(e.goal_hit) AS goal_hit, " + hourCounts() + " AS hours " +
+	"FROM entries e JOIN d ON d.firebase_id = e.user_id " +
+	"WHERE e.word_count > 0 AND e.deleted_at IS NULL AND e.created_at >= d.day - INTERVAL '1 DAY' AND e.created_at < d.day + INTERVAL '2 DAYS' AND (e.created_at AT TIME ZONE d.timezone)::date = d.day " +
+	"GROUP BY e.user_id, d.day), " +
+	"cleared AS (DELETE FROM daily_user_stats WHERE (user_id, day) IN (SELECT firebase_id, day FROM d) AND NOT EXISTS (SELECT 1 FROM totals)) " +
+	"INSERT INTO daily_user_stats (user_id, day, words_written, entries, longest_entry, goal_hit, hours) " +
+	"SELECT user_id, day, words_written, entries, longest_entry, goal_hit, hours FROM totals " +
+	"ON CONFLICT (user_id, day) DO UPDATE SET words_written = EXCLUDED.words_written, entries = EXCLUDED.entries, longest_entry = EXCLUDED.longest_entry, goal_hit = EXCLUDED.goal_hit, hours = EXCLUDED.hours"
+
+// Refresh rebuilds the rollup for the local day an entry was written on.
+// It is cheap enough to run on every entry write.
+func Refresh(db *sql.DB, userID string, writtenAt string) {
+	wrabitDB.LogAndExec(db, refreshQuery, userID, writtenAt)
+}
+
+// Reconcile refreshes every day with recently changed entries, which drops
+// the days that no longer have any. Run nightly it catches writes the
+// incremental refresh missed. The first run on an empty table builds it from scratch.
+func Reconcile(db *sql.DB) func(ctx context.Context, now time.Time) error {
+	return func(ctx context.Context, now time.Time) error {
+		var newest sql.NullTime
+		res := wrabitDB.LogAndQueryRow(db, "SELECT max(updated_at) FROM daily_user_stats")
+		if err := res.Scan(&newest); err != nil {
+			return err
+		}
+
+		since := time.Time{}
+		if newest.Valid {
+			since = newest.Time.Add(-reconcileWindow)
+		}
+
+		rows := wrabitDB.LogAndQuery(db, "SELECT DISTINCT ON (e.user_id, (e.created_at AT TIME ZONE u.timezone)::date) e.user_id, e.created_at FROM entries e JOIN users u ON u.firebase_id = e.user_id WHERE e.updated_at >= $1", since)
+
+		type written struct {
+			userID string
+			at     string
+		}
+
+		var days []written
+		for rows.Next() {
+			var day written
+			if err := rows.Scan(&day.userID, &day.at); err != nil {
+				rows.Close()
+				return err
+			}
+
+			days = append(days, day)
+		}
+		rows.Close()
+
+		for _, day := range days {
+			Refresh(db, day.userID, day.at)
+		}
+
+		return nil
+	}
+}
+
+// Cache holds the global stats so they are only computed every so often
+type Cache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	stats   *models.Stats
+	expires time.Time
+}
+
+// NewCache creates a cache that keeps stats for the given duration
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{ttl: ttl}
+}
+
+// Get returns the cached stats, loading them again once they expire.
+// A nil cache always loads.
+func (c *Cache) Get(load func() *models.Stats) *models.Stats {
+	if c == nil {
+		return load()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stats == nil || time.Now().After(c.expires) {
+		c.stats = load()
+		c.expires = time.Now().Add(c.ttl)
+	}
+
+	return c.stats
+}
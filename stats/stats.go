@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/writewithwrabit/server/models"
+)
+
+// MaxRangeDays limits how much history a single range can cover
+const MaxRangeDays = 731
+
+// MaxVocabularyDays limits the ranges vocabulary is counted for
+const MaxVocabularyDays = 92
+
+// BucketStart is the first day of the bucket a day falls in.
+// Weeks start on Monday.
+func BucketStart(day time.Time, granularity models.Granularity) time.Time {
+	switch granularity {
+	case models.GranularityWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case models.GranularityMonth:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location())
+	default:
+		return day
+	}
+}
+
+// NextBucket is the start of the bucket after the one starting at start
+func NextBucket(start time.Time, granularity models.Granularity) time.Time {
+	switch granularity {
+	case models.GranularityWeek:
+		return start.AddDate(0, 0, 7)
+	case models.GranularityMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// Buckets lists every bucket between two days, both included
+func Buckets(from time.Time, to time.Time, granularity models.Granularity) []*models.StatsBucket {
+	var buckets []*models.StatsBucket
+	for start := BucketStart(from, granularity); !start.After(to); start = NextBucket(start, granularity) {
+		buckets = append(buckets, &models.StatsBucket{Start: start.Format("2006-01-02")})
+	}
+
+	return buckets
+}
+
+// Entries are saved as HTML from the editor
+var markup = regexp.MustCompile(`<[^>]*>`)
+
+// PlainText removes the editor's markup from an entry
+func PlainText(text string) string {
+	return markup.ReplaceAllString(text, " ")
+}
+
+// Words splits text into lowercase words, ignoring punctuation and markup
+func Words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(PlainText(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+}
+
+// Vocabulary counts the distinct words used across all the texts
+func Vocabulary(texts []string) int {
+	seen := map[string]bool{}
+	for _, text := range texts {
+		for _, word := range Words(text) {
+			seen[strings.Trim(word, "'")] = true
+		}
+	}
+	delete(seen, "")
+
+	return len(seen)
+}
@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/writewithwrabit/server/models"
+)
+
+func day(date string) time.Time {
+	d, _ := time.Parse("2006-01-02", date)
+	return d
+}
+
+func TestBucketStart(t *testing.T) {
+	// 2020-03-04 is a Wednesday
+	assert.Equal(t, day("2020-03-04"), BucketStart(day("2020-03-04"), models.GranularityDay))
+	assert.Equal(t, day("2020-03-02"), BucketStart(day("2020-03-04"), models.GranularityWeek))
+	assert.Equal(t, day("2020-03-02"), BucketStart(day("2020-03-08"), models.GranularityWeek))
+	assert.Equal(t, day("2020-03-01"), BucketStart(day("2020-03-04"), models.GranularityMonth))
+}
+
+func TestBuckets(t *testing.T) {
+	buckets := Buckets(day("2020-01-15"), day("2020-03-01"), models.GranularityMonth)
+
+	var starts []string
+	for _, bucket := range buckets {
+		starts = append(starts, bucket.Start)
+	}
+
+	assert.Equal(t, []string{"2020-01-01", "2020-02-01", "2020-03-01"}, starts)
+	assert.Len(t, Buckets(day("2020-03-01"), day("2020-03-07"), models.GranularityDay), 7)
+}
+
+func TestVocabulary(t *testing.T) {
+	assert.Equal(t, 5, Vocabulary([]string{
+		"<p>The cat didn't sit.</p>",
+		"the CAT sat!",
+	}))
+	assert.Equal(t, 0, Vocabulary(nil))
+}
+
+func TestCacheReloadsAfterExpiry(t *testing.T) {
+	loads := 0
+	load := func() *models.Stats {
+		loads++
+		return &models.Stats{WordsWritten: loads}
+	}
+
+	cache := NewCache(time.Hour)
+	cache.Get(load)
+	assert.Equal(t, 1, cache.Get(load).WordsWritten)
+
+	cache.expires = time.Now().Add(-time.Second)
+	assert.Equal(t, 2, cache.Get(load).WordsWritten)
+
+	var uncached *Cache
+	assert.Equal(t, 3, uncached.Get(load).WordsWritten)
+}
+
+func TestReconcileRefreshesChangedDaysOnly(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	newest := time.Date(2020, 3, 5, 4, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT max\\(updated_at\\) FROM daily_user_stats").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(newest))
+	mock.ExpectQuery("SELECT DISTINCT ON (.+) FROM entries e JOIN users u (.+) WHERE e.updated_at >= \\$1").
+		WithArgs(newest.Add(-reconcileWindow)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "created_at"}).AddRow("abcdefg", "2020-03-04T10:00:00Z"))
+
+	// Each day is upserted or cleared in one statement and nothing else is scanned
+	mock.ExpectExec("WITH d AS (.+) cleared AS \\(DELETE FROM daily_user_stats (.+) AND NOT EXISTS \\(SELECT 1 FROM totals\\)\\) INSERT INTO daily_user_stats").
+		WithArgs("abcdefg", "2020-03-04T10:00:00Z").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.Nil(t, Reconcile(db)(context.Background(), time.Now()))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
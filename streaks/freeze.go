@@ -0,0 +1,110 @@
+package streaks
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	wrabitDB "github.com/writewithwrabit/server/db"
+)
+
+// FreezeMissed spends a freeze on each day a user's latest streak missed once
+// the day is over in their timezone, the same way Replay does. A streak that
+// runs out of freezes is left as it is and the next goal hit starts a new one.
+// It is meant to run hourly so freezes are spent soon after each local midnight.
+func FreezeMissed(db *sql.DB) func(ctx context.Context, now time.Time) error {
+	return func(ctx context.Context, now time.Time) error {
+		// Rest days and freezes can only keep a streak going for a while after it was last extended
+		since := now.AddDate(0, 0, -(MaxFreezes + MaxRestDays + 2))
+		rows := wrabitDB.LogAndQuery(db, "SELECT firebase_id FROM users u WHERE streak_freezes > 0 AND EXISTS (SELECT 1 FROM streaks s WHERE s.user_id = u.firebase_id AND s.updated_at >= $1)", since)
+
+		var users []string
+		for rows.Next() {
+			var userID string
+			if err := rows.Scan(&userID); err != nil {
+				rows.Close()
+				return err
+			}
+
+			users = append(users, userID)
+		}
+		rows.Close()
+
+		for _, userID := range users {
+			if err := freezeMissed(db, userID, now); err != nil {
+				log.Printf("failed to spend freezes for %s: %v", userID, err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// freezeMissed spends the user's freezes on the days their latest streak
+// missed before their local today, in its own transaction
+func freezeMissed(db *sql.DB, userID string, now time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := Lock(tx, userID); err != nil {
+		return err
+	}
+
+	var restDays []int64
+	var freezes int
+	var today, last time.Time
+	var streakID string
+	var lastFrozen sql.NullTime
+	res := wrabitDB.LogAndQueryRowTx(tx, "SELECT u.rest_days, u.streak_freezes, ($2::timestamptz AT TIME ZONE u.timezone)::date, s.id, (s.updated_at AT TIME ZONE u.timezone)::date, (SELECT max(f.day) FROM frozen_days f WHERE f.streak_id = s.id) FROM users u JOIN streaks s ON s.user_id = u.firebase_id WHERE u.firebase_id = $1 ORDER BY s.created_at DESC LIMIT 1", userID, now)
+	if err := res.Scan(pq.Array(&restDays), &freezes, &today, &streakID, &last, &lastFrozen); err != nil {
+		return err
+	}
+
+	var days []int
+	for _, day := range restDays {
+		days = append(days, int(day))
+	}
+
+	frozen, _ := Spend(Covered(last, lastFrozen), today, days, freezes)
+	if len(frozen) == 0 {
+		return nil
+	}
+
+	if err := Freeze(tx, userID, streakID, frozen); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Freeze spends one of the user's freezes on each day and records it against
+// the streak. Days that are already frozen don't take another one.
+// The user must be locked (see Lock) and have enough freezes left.
+func Freeze(tx *sql.Tx, userID string, streakID string, days []time.Time) error {
+	var spent int64
+	for _, day := range days {
+		res, err := wrabitDB.LogAndExecTx(tx, "INSERT INTO frozen_days (user_id, streak_id, day) VALUES ($1, $2, $3) ON CONFLICT (user_id, day) DO NOTHING", userID, streakID, day.Format("2006-01-02"))
+		if err != nil {
+			return err
+		}
+
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		spent += inserted
+	}
+
+	if spent == 0 {
+		return nil
+	}
+
+	_, err := wrabitDB.LogAndExecTx(tx, "UPDATE users SET streak_freezes = streak_freezes - $1 WHERE firebase_id = $2", spent, userID)
+
+	return err
+}
@@ -0,0 +1,255 @@
+package streaks
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	wrabitDB "github.com/writewithwrabit/server/db"
+)
+
+// History is everything about a user's streaks that can be rebuilt from their entries
+type History struct {
+	Streaks []*Streak
+	Freezes int
+	// Orphaned are saved frozen days that don't belong to any of the streaks
+	Orphaned []time.Time
+}
+
+// Lock takes the lock on the user's row that every change to their streaks
+// holds until its transaction ends, so goals hit on two devices at once and
+// recomputes can't interleave. It returns the user's timezone.
+func Lock(tx *sql.Tx, userID string) (string, error) {
+	var timezone string
+	res := wrabitDB.LogAndQueryRowTx(tx, "SELECT timezone FROM users WHERE firebase_id = $1 FOR UPDATE", userID)
+	if err := res.Scan(&timezone); err != nil {
+		return "", err
+	}
+
+	return timezone, nil
+}
+
+// Rebuild replays the goals a user hit on their daily entries, one per local day.
+// Rest days are taken from the user's current settings since earlier ones aren't kept.
+// Freezes are spent on the days missed up to the user's local today.
+func Rebuild(tx *sql.Tx, userID string) (*History, error) {
+	var restDays []int64
+	var today time.Time
+	res := wrabitDB.LogAndQueryRowTx(tx, "SELECT rest_days, (now() AT TIME ZONE timezone)::date FROM users WHERE firebase_id = $1", userID)
+	if err := res.Scan(pq.Array(&restDays), &today); err != nil {
+		return nil, err
+	}
+
+	var days []int
+	for _, day := range restDays {
+		days = append(days, int(day))
+	}
+
+	// Only daily entries count toward goals and streaks
+	rows, err := wrabitDB.LogAndQueryTx(tx, "SELECT e.id, (e.created_at AT TIME ZONE u.timezone)::date FROM entries e JOIN users u ON u.firebase_id = e.user_id WHERE e.user_id = $1 AND e.kind = 'DAILY' AND e.goal_hit AND e.deleted_at IS NULL ORDER BY e.created_at, e.id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []GoalHit
+	for rows.Next() {
+		var hit GoalHit
+		if err := rows.Scan(&hit.EntryID, &hit.Day); err != nil {
+			return nil, err
+		}
+
+		hits = append(hits, hit)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	history := new(History)
+	history.Streaks, history.Freezes = Replay(hits, days, today)
+
+	return history, nil
+}
+
+// Load reads a user's streaks as they are saved
+func Load(tx *sql.Tx, userID string) (*History, error) {
+	history := new(History)
+	res := wrabitDB.LogAndQueryRowTx(tx, "SELECT streak_freezes FROM users WHERE firebase_id = $1", userID)
+	if err := res.Scan(&history.Freezes); err != nil {
+		return nil, err
+	}
+
+	rows, err := wrabitDB.LogAndQueryTx(tx, "SELECT s.id, s.day_count, COALESCE(s.last_entry_id::varchar, ''), (s.created_at AT TIME ZONE u.timezone)::date, (s.updated_at AT TIME ZONE u.timezone)::date FROM streaks s JOIN users u ON u.firebase_id = s.user_id WHERE s.user_id = $1 ORDER BY s.created_at, s.id", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[string]*Streak{}
+	for rows.Next() {
+		var id string
+		var streak = new(Streak)
+		if err := rows.Scan(&id, &streak.DayCount, &streak.LastEntryID, &streak.Start, &streak.End); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		byID[id] = streak
+		history.Streaks = append(history.Streaks, streak)
+	}
+	rows.Close()
+
+	rows, err = wrabitDB.LogAndQueryTx(tx, "SELECT streak_id, day FROM frozen_days WHERE user_id = $1 ORDER BY day", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var streakID sql.NullString
+		var day time.Time
+		if err := rows.Scan(&streakID, &day); err != nil {
+			return nil, err
+		}
+
+		if streak, ok := byID[streakID.String]; ok {
+			streak.Frozen = append(streak.Frozen, day)
+		} else {
+			history.Orphaned = append(history.Orphaned, day)
+		}
+	}
+
+	return history, rows.Err()
+}
+
+// Drift lists how saved streaks differ from rebuilt ones.
+// Streaks are matched up by the day they started.
+func Drift(saved *History, rebuilt *History) []string {
+	var drift []string
+
+	byStart := map[string]*Streak{}
+	for _, streak := range saved.Streaks {
+		byStart[streak.Start.Format("2006-01-02")] = streak
+	}
+
+	for _, want := range rebuilt.Streaks {
+		start := want.Start.Format("2006-01-02")
+		got, ok := byStart[start]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("missing streak %s", describe(want)))
+			continue
+		}
+		delete(byStart, start)
+
+		if got.DayCount != want.DayCount || !got.End.Equal(want.End) || got.LastEntryID != want.LastEntryID || len(got.Frozen) != len(want.Frozen) {
+			drift = append(drift, fmt.Sprintf("streak %s should be %s", describe(got), describe(want)))
+		} else if formatDays(got.Frozen) != formatDays(want.Frozen) {
+			drift = append(drift, fmt.Sprintf("streak %s froze %s but should have frozen %s", start, formatDays(got.Frozen), formatDays(want.Frozen)))
+		}
+	}
+
+	for _, got := range saved.Streaks {
+		if _, ok := byStart[got.Start.Format("2006-01-02")]; ok {
+			drift = append(drift, fmt.Sprintf("unexpected streak %s", describe(got)))
+		}
+	}
+
+	if len(saved.Orphaned) > 0 {
+		drift = append(drift, fmt.Sprintf("frozen days %s belong to no streak", formatDays(saved.Orphaned)))
+	}
+
+	if saved.Freezes != rebuilt.Freezes {
+		drift = append(drift, fmt.Sprintf("%d freezes should be %d", saved.Freezes, rebuilt.Freezes))
+	}
+
+	return drift
+}
+
+func describe(streak *Streak) string {
+	return fmt.Sprintf("%s to %s (%d days, %d frozen, last entry %s)", streak.Start.Format("2006-01-02"), streak.End.Format("2006-01-02"), streak.DayCount, len(streak.Frozen), streak.LastEntryID)
+}
+
+func formatDays(days []time.Time) string {
+	var dates []string
+	for _, day := range days {
+		dates = append(dates, day.Format("2006-01-02"))
+	}
+
+	return strings.Join(dates, ", ")
+}
+
+// Save replaces a user's streaks, frozen days and freezes.
+// Each streak's timestamps are set to the local days it started and was last
+// extended in the user's timezone, as returned by Lock.
+func Save(tx *sql.Tx, userID string, timezone string, history *History) error {
+	if _, err := wrabitDB.LogAndExecTx(tx, "DELETE FROM frozen_days WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	if _, err := wrabitDB.LogAndExecTx(tx, "DELETE FROM streaks WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	for _, streak := range history.Streaks {
+		var id string
+		res := wrabitDB.LogAndQueryRowTx(tx, "INSERT INTO streaks (user_id, day_count, last_entry_id, created_at, updated_at) VALUES ($1, $2, $3, $4::timestamp AT TIME ZONE $6, $5::timestamp AT TIME ZONE $6) RETURNING id", userID, streak.DayCount, streak.LastEntryID, streak.Start.Format("2006-01-02"), streak.End.Format("2006-01-02"), timezone)
+		if err := res.Scan(&id); err != nil {
+			return err
+		}
+
+		for _, day := range streak.Frozen {
+			if _, err := wrabitDB.LogAndExecTx(tx, "INSERT INTO frozen_days (user_id, streak_id, day) VALUES ($1, $2, $3)", userID, id, day.Format("2006-01-02")); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := wrabitDB.LogAndExecTx(tx, "UPDATE users SET streak_freezes = $1 WHERE firebase_id = $2", history.Freezes, userID)
+
+	return err
+}
+
+// Recompute rebuilds a user's streaks in its own transaction and returns how
+// the saved ones drifted. They are only replaced when apply is set.
+func Recompute(db *sql.DB, userID string, apply bool) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	drift, err := RecomputeTx(tx, userID, apply)
+	if err != nil {
+		return nil, err
+	}
+
+	return drift, tx.Commit()
+}
+
+// RecomputeTx is Recompute inside a transaction that also changes the
+// entries the streaks are rebuilt from. The user stays locked until it ends.
+func RecomputeTx(tx *sql.Tx, userID string, apply bool) ([]string, error) {
+	timezone, err := Lock(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rebuilt, err := Rebuild(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	saved, err := Load(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := Drift(saved, rebuilt)
+	if len(drift) == 0 || !apply {
+		return drift, nil
+	}
+
+	return drift, Save(tx, userID, timezone, rebuilt)
+}
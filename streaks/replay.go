@@ -0,0 +1,75 @@
+package streaks
+
+import (
+	"time"
+)
+
+// GoalHit is a day the user hit their goal and the entry they hit it with
+type GoalHit struct {
+	EntryID string
+	Day     time.Time
+}
+
+// Streak is a run of goal hits rebuilt by Replay
+type Streak struct {
+	Start       time.Time
+	End         time.Time
+	DayCount    int
+	LastEntryID string
+	Frozen      []time.Time
+}
+
+// Replay rebuilds a user's streaks from the days they hit their goal, oldest first.
+// Freezes are earned and spent the same way as when goals are hit one at a time,
+// with a freeze spent on each missed day before today, and only the first goal
+// hit on a day counts.
+// It returns the streaks along with the freezes left over at the end.
+func Replay(hits []GoalHit, restDays []int, today time.Time) ([]*Streak, int) {
+	var history []*Streak
+	var current *Streak
+	freezes := 0
+
+	// spend freezes on the days the current streak missed before the given day
+	spend := func(day time.Time) bool {
+		covered := current.End
+		if len(current.Frozen) > 0 && current.Frozen[len(current.Frozen)-1].After(covered) {
+			covered = current.Frozen[len(current.Frozen)-1]
+		}
+
+		frozen, ok := Spend(covered, day, restDays, freezes)
+		freezes -= len(frozen)
+		current.Frozen = append(current.Frozen, frozen...)
+
+		return ok
+	}
+
+	for _, hit := range hits {
+		if current != nil && !hit.Day.After(current.End) {
+			continue
+		}
+
+		if current != nil && !spend(hit.Day) {
+			current = nil
+		}
+
+		if current == nil {
+			current = &Streak{Start: hit.Day}
+			history = append(history, current)
+		}
+
+		current.End = hit.Day
+		current.DayCount++
+		current.LastEntryID = hit.EntryID
+
+		freezes += Earned(current.DayCount)
+		if freezes > MaxFreezes {
+			freezes = MaxFreezes
+		}
+	}
+
+	if current != nil {
+		spend(today)
+	}
+
+	return history, freezes
+}
@@ -0,0 +1,114 @@
+package streaks
+
+import (
+	"database/sql"
+	"time"
+)
+
+// FreezeEvery is how many days of streak earn a freeze
+const FreezeEvery = 7
+
+// MaxFreezes caps how many freezes can be saved up
+const MaxFreezes = 3
+
+// MaxRestDays is how many days a week can be taken off
+const MaxRestDays = 2
+
+// Day is midnight of the local day t falls on
+func Day(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+}
+
+// ParseDay reads the date clients send with an entry as a local day.
+// Anything unparseable is treated as today.
+func ParseDay(date string, loc *time.Location) time.Time {
+	if t, err := time.Parse(time.RFC3339, date); err == nil {
+		return Day(t, loc)
+	}
+
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, date, loc); err == nil {
+			return Day(t, loc)
+		}
+	}
+
+	return Day(time.Now(), loc)
+}
+
+// IsRestDay checks if the day is one the user doesn't need to write on
+func IsRestDay(day time.Time, restDays []int) bool {
+	for _, restDay := range restDays {
+		if time.Weekday(restDay) == day.Weekday() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Missed lists the days between the last day a streak was extended and today
+// that the user should have written on. Rest days are never missed.
+func Missed(last time.Time, today time.Time, restDays []int) []time.Time {
+	var missed []time.Time
+	for day := last.AddDate(0, 0, 1); day.Before(today); day = day.AddDate(0, 0, 1) {
+		if !IsRestDay(day, restDays) {
+			missed = append(missed, day)
+		}
+	}
+
+	return missed
+}
+
+// Spend walks the days a streak missed between the last day it covers and
+// today, spending a freeze on each one while there are any left.
+// It returns the days frozen and whether the streak survived all of them.
+// Days frozen before the streak ran out of freezes stay spent.
+func Spend(covered time.Time, today time.Time, restDays []int, freezes int) ([]time.Time, bool) {
+	var frozen []time.Time
+	for _, day := range Missed(covered, today, restDays) {
+		if len(frozen) == freezes {
+			return frozen, false
+		}
+
+		frozen = append(frozen, day)
+	}
+
+	return frozen, true
+}
+
+// Covered is the last day a streak reaches: the day its goal was last hit or,
+// if later, the last day a freeze was spent on for it
+func Covered(last time.Time, lastFrozen sql.NullTime) time.Time {
+	if !lastFrozen.Valid {
+		return last
+	}
+
+	frozen := time.Date(lastFrozen.Time.Year(), lastFrozen.Time.Month(), lastFrozen.Time.Day(), 0, 0, 0, 0, last.Location())
+	if frozen.After(last) {
+		return frozen
+	}
+
+	return last
+}
+
+// Earned is the number of freezes reaching a streak length earns
+func Earned(dayCount int) int {
+	if dayCount > 0 && dayCount%FreezeEvery == 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// AtRisk checks if a streak will be lost unless the user writes today.
+// Last is the day the streak covers (see Covered).
+func AtRisk(last time.Time, today time.Time, restDays []int, freezes int) bool {
+	if !last.Before(today) || IsRestDay(today, restDays) {
+		return false
+	}
+
+	missed := Missed(last, today, restDays)
+
+	return len(missed) <= freezes && len(missed)+1 > freezes
+}
@@ -0,0 +1,184 @@
+package streaks
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func day(date string) time.Time {
+	d, _ := time.Parse("2006-01-02", date)
+	return d
+}
+
+func TestMissedSkipsRestDays(t *testing.T) {
+	// 2020-03-07 is a Saturday and 2020-03-08 a Sunday
+	missed := Missed(day("2020-03-06"), day("2020-03-10"), []int{0, 6})
+
+	assert.Equal(t, []time.Time{day("2020-03-09")}, missed)
+	assert.Empty(t, Missed(day("2020-03-06"), day("2020-03-07"), nil))
+	assert.Empty(t, Missed(day("2020-03-06"), day("2020-03-06"), nil))
+}
+
+func TestSpendFreezesMissedDays(t *testing.T) {
+	frozen, ok := Spend(day("2020-03-02"), day("2020-03-05"), nil, 2)
+	assert.True(t, ok)
+	assert.Equal(t, []time.Time{day("2020-03-03"), day("2020-03-04")}, frozen)
+
+	// The first missed day still takes the only freeze
+	frozen, ok = Spend(day("2020-03-02"), day("2020-03-05"), nil, 1)
+	assert.False(t, ok)
+	assert.Equal(t, []time.Time{day("2020-03-03")}, frozen)
+
+	frozen, ok = Spend(day("2020-03-02"), day("2020-03-05"), []int{2, 3}, 0)
+	assert.True(t, ok)
+	assert.Empty(t, frozen)
+}
+
+func TestCoveredIncludesFrozenDays(t *testing.T) {
+	loc, _ := time.LoadLocation("America/Toronto")
+	last := time.Date(2020, time.March, 2, 0, 0, 0, 0, loc)
+
+	assert.Equal(t, last, Covered(last, sql.NullTime{}))
+	assert.Equal(t, last, Covered(last, sql.NullTime{Time: day("2020-02-28"), Valid: true}))
+	assert.Equal(t, time.Date(2020, time.March, 4, 0, 0, 0, 0, loc), Covered(last, sql.NullTime{Time: day("2020-03-04"), Valid: true}))
+}
+
+func TestParseDayInUserTimezone(t *testing.T) {
+	loc, _ := time.LoadLocation("America/Toronto")
+
+	assert.Equal(t, "2020-03-01", ParseDay("2020-03-02T03:00:00Z", loc).Format("2006-01-02"))
+	assert.Equal(t, "2020-03-02", ParseDay("2020-03-02", loc).Format("2006-01-02"))
+	assert.Equal(t, loc, ParseDay("2020-03-02T00:00:00", loc).Location())
+}
+
+func TestEarned(t *testing.T) {
+	assert.Equal(t, 0, Earned(0))
+	assert.Equal(t, 0, Earned(6))
+	assert.Equal(t, 1, Earned(7))
+	assert.Equal(t, 1, Earned(14))
+}
+
+func TestAtRiskOnceFreezesRunOut(t *testing.T) {
+	// Missed 2020-03-03, writing on 2020-03-04 keeps the streak with one freeze
+	assert.True(t, AtRisk(day("2020-03-02"), day("2020-03-04"), nil, 1))
+	assert.False(t, AtRisk(day("2020-03-02"), day("2020-03-04"), nil, 2))
+	// Already broken
+	assert.False(t, AtRisk(day("2020-03-02"), day("2020-03-04"), nil, 0))
+	// 2020-03-07 is a Saturday
+	assert.False(t, AtRisk(day("2020-03-06"), day("2020-03-07"), []int{6}, 0))
+	assert.False(t, AtRisk(day("2020-03-06"), day("2020-03-06"), nil, 0))
+}
+
+func TestReplay(t *testing.T) {
+	var hits []GoalHit
+	// Seven days in a row earn a freeze
+	for d := day("2020-03-01"); d.Before(day("2020-03-08")); d = d.AddDate(0, 0, 1) {
+		hits = append(hits, GoalHit{EntryID: d.Format("0102"), Day: d})
+	}
+	// Writing twice on a day counts once
+	hits = append(hits, GoalHit{EntryID: "extra", Day: day("2020-03-07")})
+	// Missing 2020-03-08 spends the freeze
+	hits = append(hits, GoalHit{EntryID: "0309", Day: day("2020-03-09")})
+	// Missing 2020-03-10 and 2020-03-11 breaks the streak
+	hits = append(hits, GoalHit{EntryID: "0312", Day: day("2020-03-12")})
+
+	history, freezes := Replay(hits, nil, day("2020-03-13"))
+
+	assert.Equal(t, 0, freezes)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, &Streak{Start: day("2020-03-01"), End: day("2020-03-09"), DayCount: 8, LastEntryID: "0309", Frozen: []time.Time{day("2020-03-08")}}, history[0])
+		assert.Equal(t, &Streak{Start: day("2020-03-12"), End: day("2020-03-12"), DayCount: 1, LastEntryID: "0312"}, history[1])
+	}
+
+	// 2020-03-07 and 2020-03-08 are the weekend
+	history, _ = Replay([]GoalHit{{"1", day("2020-03-06")}, {"2", day("2020-03-09")}}, []int{0, 6}, day("2020-03-10"))
+	assert.Len(t, history, 1)
+}
+
+func TestReplaySpendsFreezesBeforeToday(t *testing.T) {
+	var hits []GoalHit
+	for d := day("2020-03-01"); d.Before(day("2020-03-08")); d = d.AddDate(0, 0, 1) {
+		hits = append(hits, GoalHit{EntryID: d.Format("0102"), Day: d})
+	}
+
+	// Nothing has been missed yet on 2020-03-08
+	history, freezes := Replay(hits, nil, day("2020-03-08"))
+	assert.Equal(t, 1, freezes)
+	assert.Empty(t, history[0].Frozen)
+
+	// The freeze goes on 2020-03-08 as soon as it's over, and stays spent
+	// once 2020-03-09 is missed too
+	history, freezes = Replay(hits, nil, day("2020-03-11"))
+	assert.Equal(t, 0, freezes)
+	assert.Equal(t, []time.Time{day("2020-03-08")}, history[0].Frozen)
+
+	history, freezes = Replay(append(hits, GoalHit{EntryID: "0311", Day: day("2020-03-11")}), nil, day("2020-03-11"))
+	assert.Equal(t, 0, freezes)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, []time.Time{day("2020-03-08")}, history[0].Frozen)
+		assert.Equal(t, 7, history[0].DayCount)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	rebuilt := &History{Streaks: []*Streak{{Start: day("2020-03-01"), End: day("2020-03-02"), DayCount: 2, LastEntryID: "2"}}}
+	assert.Empty(t, Drift(rebuilt, rebuilt))
+
+	saved := &History{
+		Streaks: []*Streak{
+			{Start: day("2020-03-01"), End: day("2020-03-02"), DayCount: 3, LastEntryID: "2"},
+			{Start: day("2020-03-04"), End: day("2020-03-04"), DayCount: 1, LastEntryID: "3"},
+		},
+		Freezes: 1,
+	}
+	assert.Equal(t, []string{
+		"streak 2020-03-01 to 2020-03-02 (3 days, 0 frozen, last entry 2) should be 2020-03-01 to 2020-03-02 (2 days, 0 frozen, last entry 2)",
+		"unexpected streak 2020-03-04 to 2020-03-04 (1 days, 0 frozen, last entry 3)",
+		"1 freezes should be 0",
+	}, Drift(saved, rebuilt))
+}
+
+func TestDriftComparesFrozenDays(t *testing.T) {
+	rebuilt := &History{Streaks: []*Streak{{Start: day("2020-03-01"), End: day("2020-03-04"), DayCount: 3, LastEntryID: "3", Frozen: []time.Time{day("2020-03-03")}}}}
+	saved := &History{
+		Streaks:  []*Streak{{Start: day("2020-03-01"), End: day("2020-03-04"), DayCount: 3, LastEntryID: "3", Frozen: []time.Time{day("2020-03-02")}}},
+		Orphaned: []time.Time{day("2020-02-20"), day("2020-02-21")},
+	}
+
+	assert.Equal(t, []string{
+		"streak 2020-03-01 froze 2020-03-02 but should have frozen 2020-03-03",
+		"frozen days 2020-02-20, 2020-02-21 belong to no streak",
+	}, Drift(saved, rebuilt))
+}
+
+func TestFreezeMissedSpendsFreezesOnceTheDayIsOver(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Date(2020, time.March, 5, 6, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT firebase_id FROM users u WHERE streak_freezes > 0").
+		WillReturnRows(sqlmock.NewRows([]string{"firebase_id"}).AddRow("abcdefg"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT timezone FROM users WHERE firebase_id = \\$1 FOR UPDATE").
+		WithArgs("abcdefg").
+		WillReturnRows(sqlmock.NewRows([]string{"timezone"}).AddRow("America/Toronto"))
+	// Last hit on 2020-03-02 and 2020-03-03 already frozen, so only 2020-03-04 is left to freeze
+	mock.ExpectQuery("SELECT u.rest_days, u.streak_freezes").
+		WithArgs("abcdefg", now).
+		WillReturnRows(sqlmock.NewRows([]string{"rest_days", "streak_freezes", "today", "id", "updated_at", "max"}).
+			AddRow("{}", 2, day("2020-03-05"), "3", day("2020-03-02"), day("2020-03-03")))
+	mock.ExpectExec("INSERT INTO frozen_days").WithArgs("abcdefg", "3", "2020-03-04").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE users SET streak_freezes = streak_freezes - \\$1").WithArgs(1, "abcdefg").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, FreezeMissed(db)(context.Background(), now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,88 @@
+// Package trash keeps deleted entries for a while so they can be restored
+package trash
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/keystore"
+)
+
+// Retention is how long deleted entries can be restored for
+const Retention = 30 * 24 * time.Hour
+
+// Purge permanently removes entries that have been in the trash longer than
+// Retention. Their keys are destroyed so the content can't be read again, even
+// from backups once the key database's keystore.BackupRetention has passed.
+func Purge(db *sql.DB, keys *keystore.Store) func(ctx context.Context, now time.Time) error {
+	return func(ctx context.Context, now time.Time) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		rows, err := wrabitDB.LogAndQueryTx(tx, "SELECT id, key_id FROM entries WHERE deleted_at <= $1 FOR UPDATE", now.Add(-Retention))
+		if err != nil {
+			return err
+		}
+
+		var ids, keyIDs []string
+		for rows.Next() {
+			var id string
+			var keyID sql.NullString
+			if err := rows.Scan(&id, &keyID); err != nil {
+				rows.Close()
+				return err
+			}
+
+			ids = append(ids, id)
+			if keyID.Valid {
+				keyIDs = append(keyIDs, keyID.String)
+			}
+		}
+		rows.Close()
+
+		if len(ids) == 0 {
+			return nil
+		}
+
+		if err := Erase(tx, keys, ids, keyIDs, "entry deleted"); err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}
+}
+
+// Erase removes entries along with everything about them and destroys their
+// keys. The keys live in their own database so they are destroyed before the
+// entries' transaction commits. If it then fails the entries are left
+// unreadable, and the entries go last so their keys are never lost track of
+// while the content is still around.
+func Erase(tx *sql.Tx, keys *keystore.Store, ids []string, keyIDs []string, reason string) error {
+	statements := []string{
+		"UPDATE check_ins SET entry_id = NULL WHERE entry_id = ANY($1::int[])",
+		"UPDATE achievements SET entry_id = NULL WHERE entry_id = ANY($1::int[])",
+		"UPDATE donations SET entry_id = NULL WHERE entry_id = ANY($1::varchar[])",
+		"DELETE FROM entry_tags WHERE entry_id = ANY($1::int[])",
+		"DELETE FROM entry_insights WHERE entry_id = ANY($1::int[])",
+	}
+	for _, statement := range statements {
+		if _, err := wrabitDB.LogAndExecTx(tx, statement, pq.Array(ids)); err != nil {
+			return err
+		}
+	}
+
+	// Shred the content so the copies in backups of this database can't be read
+	if err := keys.DestroyMany(keyIDs, reason); err != nil {
+		return err
+	}
+
+	_, err := wrabitDB.LogAndExecTx(tx, "DELETE FROM entries WHERE id = ANY($1::int[])", pq.Array(ids))
+
+	return err
+}
@@ -0,0 +1,100 @@
+package trash
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/writewithwrabit/server/keystore"
+)
+
+func TestPurgeErasesEntriesPastRetention(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	keysDB, keysMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer keysDB.Close()
+
+	now := time.Now()
+
+	ids := "{\"1\",\"2\"}"
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, key_id FROM entries WHERE deleted_at <= \\$1 FOR UPDATE").
+		WithArgs(now.Add(-Retention)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "key_id"}).AddRow("1", "5").AddRow("2", nil))
+	mock.ExpectExec("UPDATE check_ins SET entry_id = NULL WHERE entry_id = ANY").WithArgs(ids).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE achievements SET entry_id = NULL WHERE entry_id = ANY").WithArgs(ids).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE donations SET entry_id = NULL WHERE entry_id = ANY").WithArgs(ids).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM entry_tags WHERE entry_id = ANY").WithArgs(ids).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM entry_insights WHERE entry_id = ANY").WithArgs(ids).WillReturnResult(sqlmock.NewResult(0, 2))
+	// Keys are destroyed in their own database before the entries go
+	keysMock.ExpectBegin()
+	keysMock.ExpectQuery("UPDATE encryption_keys SET wrapped_key = NULL, destroyed_at = NOW\\(\\) WHERE id = ANY\\(\\$1::int\\[\\]\\)").
+		WithArgs("{\"5\"}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("5", "abcdefg"))
+	keysMock.ExpectExec("INSERT INTO key_audit").WithArgs("5", "abcdefg", "entry deleted").WillReturnResult(sqlmock.NewResult(1, 1))
+	keysMock.ExpectCommit()
+	mock.ExpectExec("DELETE FROM entries WHERE id = ANY").WithArgs(ids).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	err = Purge(db, keystore.New(keysDB, ""))(context.Background(), now)
+
+	assert.Nil(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+	if err := keysMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled key expectations: %s", err)
+	}
+}
+
+func TestPurgeKeepsEntriesWhenKeysCantBeDestroyed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	keysDB, keysMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer keysDB.Close()
+
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, key_id FROM entries WHERE deleted_at <= \\$1 FOR UPDATE").
+		WithArgs(now.Add(-Retention)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "key_id"}).AddRow("1", "5"))
+	mock.ExpectExec("UPDATE check_ins").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE achievements").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE donations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM entry_tags").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM entry_insights").WillReturnResult(sqlmock.NewResult(0, 0))
+	keysMock.ExpectBegin()
+	keysMock.ExpectQuery("UPDATE encryption_keys").WithArgs("{\"5\"}").WillReturnError(sql.ErrConnDone)
+	keysMock.ExpectRollback()
+	mock.ExpectRollback()
+
+	err = Purge(db, keystore.New(keysDB, ""))(context.Background(), now)
+
+	assert.Equal(t, sql.ErrConnDone, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+	if err := keysMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled key expectations: %s", err)
+	}
+}
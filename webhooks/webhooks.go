@@ -0,0 +1,488 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	wrabitDB "github.com/writewithwrabit/server/db"
+	"github.com/writewithwrabit/server/models"
+)
+
+// MaxAttempts is how many times a delivery is tried before giving up
+const MaxAttempts = 8
+
+// SignatureTolerance is how old a signature can be before receivers should reject it
+const SignatureTolerance = 5 * time.Minute
+
+// TestEvent is sent by test deliveries
+const TestEvent = "webhook.test"
+
+// How many pending deliveries are retried each time the cron runs
+const retryBatch = 100
+
+// How long a delivery being attempted is kept from other attempts.
+// It outlasts a whole batch of attempts timing out.
+const claimLease = 30 * time.Minute
+
+// ErrNotFound is returned when the webhook doesn't exist or belongs to someone else
+var ErrNotFound = errors.New("webhook not found")
+
+// Events are the names deliveries are sent with
+var Events = map[models.WebhookEvent]string{
+	models.WebhookEventEntryGoalHit:      "entry.goal_hit",
+	models.WebhookEventStreakExtended:    "streak.extended",
+	models.WebhookEventStreakBroken:      "streak.broken",
+	models.WebhookEventAchievementEarned: "achievement.earned",
+}
+
+// Payload is the JSON body of every delivery
+type Payload struct {
+	Event     string      `json:"event"`
+	CreatedAt string      `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+// GoalHit is the data sent with entry.goal_hit.
+// Entry content is never sent.
+type GoalHit struct {
+	EntryID   string `json:"entryID"`
+	WordCount int    `json:"wordCount"`
+	Date      string `json:"date"`
+}
+
+// Streak is the data sent with streak.extended and streak.broken
+type Streak struct {
+	StreakID string `json:"streakID"`
+	DayCount int    `json:"dayCount"`
+	Date     string `json:"date"`
+}
+
+// delivery is a single event on its way to a webhook
+type delivery struct {
+	id       string
+	url      string
+	secret   string
+	event    string
+	payload  string
+	attempts int
+}
+
+// Dispatcher sends events to the webhooks users registered for them
+type Dispatcher struct {
+	db         *sql.DB
+	httpClient *http.Client
+}
+
+func New(db *sql.DB) *Dispatcher {
+	return &Dispatcher{
+		db:         db,
+		httpClient: NewPublicClient(),
+	}
+}
+
+// NewPublicClient builds a client that only connects to public addresses and
+// doesn't follow redirects, for anything sent to URLs users gave us
+func NewPublicClient() *http.Client {
+	return newHTTPClient(publicOnly)
+}
+
+// newHTTPClient builds the client deliveries are sent with. control is run
+// on every address dialed, after DNS has been resolved.
+func newHTTPClient(control func(network, address string, c syscall.RawConn) error) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: control,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// A proxy would dial on the server's behalf, out of reach of control
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		// Redirects count as failures so deliveries only go where the user asked
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Publish records a delivery for every webhook subscribed to the event and makes the first attempt.
+// Failed deliveries are picked up again by Retry.
+func (d *Dispatcher) Publish(ctx context.Context, userID string, event models.WebhookEvent, data interface{}) error {
+	if d == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(Payload{
+		Event:     Events[event],
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	res := wrabitDB.LogAndQuery(d.db, "SELECT id, url, secret FROM webhooks WHERE user_id = $1 AND $2 = ANY(events)", userID, event)
+
+	var webhookIDs []string
+	var deliveries []*delivery
+	for res.Next() {
+		var webhookID string
+		var delivery = &delivery{event: Events[event], payload: string(payload)}
+		if err := res.Scan(&webhookID, &delivery.url, &delivery.secret); err != nil {
+			res.Close()
+			return err
+		}
+
+		webhookIDs = append(webhookIDs, webhookID)
+		deliveries = append(deliveries, delivery)
+	}
+	res.Close()
+
+	// Record every delivery before trying any so a slow receiver can't lose the others.
+	// They are claimed for the first attempt, Retry only picks them up if it never finishes.
+	for i, delivery := range deliveries {
+		row := wrabitDB.LogAndQueryRow(d.db, "INSERT INTO webhook_deliveries (webhook_id, user_id, event, payload, next_attempt_at) VALUES ($1, $2, $3, $4, $5) RETURNING id", webhookIDs[i], userID, delivery.event, delivery.payload, time.Now().Add(claimLease))
+		if err := row.Scan(&delivery.id); err != nil {
+			return err
+		}
+	}
+
+	for _, delivery := range deliveries {
+		d.attempt(ctx, delivery, true)
+	}
+
+	return nil
+}
+
+// Test sends a test event to a webhook straight away. It is never retried.
+func (d *Dispatcher) Test(ctx context.Context, userID string, webhookID string) (*models.WebhookDelivery, error) {
+	delivery := &delivery{event: TestEvent}
+	res := wrabitDB.LogAndQueryRow(d.db, "SELECT url, secret FROM webhooks WHERE id = $1 AND user_id = $2", webhookID, userID)
+	if err := res.Scan(&delivery.url, &delivery.secret); err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(Payload{
+		Event:     TestEvent,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Data:      map[string]string{"webhookID": webhookID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	delivery.payload = string(payload)
+
+	res = wrabitDB.LogAndQueryRow(d.db, "INSERT INTO webhook_deliveries (webhook_id, user_id, event, payload) VALUES ($1, $2, $3, $4) RETURNING id", webhookID, userID, delivery.event, delivery.payload)
+	if err := res.Scan(&delivery.id); err != nil {
+		return nil, err
+	}
+
+	d.attempt(ctx, delivery, false)
+
+	result := new(models.WebhookDelivery)
+	res = wrabitDB.LogAndQueryRow(d.db, "SELECT "+DeliveryColumns+" FROM webhook_deliveries WHERE id = $1", delivery.id)
+	if err := ScanDelivery(res, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Retry makes the next attempt at every delivery that is due.
+// Deliveries are claimed first so overlapping runs never send one twice.
+func (d *Dispatcher) Retry(ctx context.Context, now time.Time) error {
+	res := wrabitDB.LogAndQuery(d.db, "UPDATE webhook_deliveries d SET next_attempt_at = $3 FROM webhooks w WHERE w.id = d.webhook_id AND d.id IN (SELECT id FROM webhook_deliveries WHERE delivered_at IS NULL AND next_attempt_at <= $1 ORDER BY next_attempt_at LIMIT $2 FOR UPDATE SKIP LOCKED) RETURNING d.id, w.url, w.secret, d.event, d.payload, d.attempts", now, retryBatch, now.Add(claimLease))
+
+	var deliveries []*delivery
+	for res.Next() {
+		var delivery = new(delivery)
+		if err := res.Scan(&delivery.id, &delivery.url, &delivery.secret, &delivery.event, &delivery.payload, &delivery.attempts); err != nil {
+			res.Close()
+			return err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+	res.Close()
+
+	for _, delivery := range deliveries {
+		d.attempt(ctx, delivery, true)
+	}
+
+	return nil
+}
+
+// attempt sends the delivery once and records how it went.
+// Failures are scheduled again with exponential backoff until MaxAttempts.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *delivery, retry bool) {
+	now := time.Now()
+	status, err := d.send(ctx, delivery, now)
+	delivery.attempts++
+
+	var statusCode *int
+	if status != 0 {
+		statusCode = &status
+	}
+
+	if err == nil {
+		wrabitDB.LogAndExec(d.db, "UPDATE webhook_deliveries SET attempts = $1, status_code = $2, error = NULL, delivered_at = NOW(), next_attempt_at = NULL WHERE id = $3", delivery.attempts, statusCode, delivery.id)
+		return
+	}
+
+	log.Printf("webhook delivery %s failed: %v", delivery.id, err)
+
+	var next *time.Time
+	if retry && delivery.attempts < MaxAttempts {
+		at := now.Add(Backoff(delivery.attempts))
+		next = &at
+	}
+
+	wrabitDB.LogAndExec(d.db, "UPDATE webhook_deliveries SET attempts = $1, status_code = $2, error = $3, next_attempt_at = $4 WHERE id = $5", delivery.attempts, statusCode, err.Error(), next, delivery.id)
+}
+
+// send posts the payload and returns the status the receiver responded with
+func (d *Dispatcher) send(ctx context.Context, delivery *delivery, now time.Time) (int, error) {
+	req, err := http.NewRequest("POST", delivery.url, strings.NewReader(delivery.payload))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Wrabit-Webhooks/1")
+	req.Header.Set("X-Wrabit-Event", delivery.event)
+	req.Header.Set("X-Wrabit-Delivery", delivery.id)
+	req.Header.Set("X-Wrabit-Signature", Sign(delivery.secret, now, []byte(delivery.payload)))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Read some of the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Backoff is how long to wait before the next attempt after the given number of attempts.
+// It doubles from a minute and is capped at six hours.
+func Backoff(attempts int) time.Duration {
+	wait := time.Minute
+	for i := 1; i < attempts && wait < 6*time.Hour; i++ {
+		wait *= 2
+	}
+
+	if wait > 6*time.Hour {
+		wait = 6 * time.Hour
+	}
+
+	return wait
+}
+
+// Sign creates the X-Wrabit-Signature header: the time it was signed and an
+// HMAC-SHA256 of "<time>.<payload>" using the webhook's secret
+func Sign(secret string, at time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, payload)
+}
+
+// Verify checks a signature header the way receivers should
+func Verify(secret string, header string, payload []byte, now time.Time) error {
+	var timestamp, sig string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			sig = kv[1]
+		}
+	}
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("signature has no timestamp")
+	}
+
+	if now.Sub(time.Unix(signedAt, 0)) > SignatureTolerance {
+		return fmt.Errorf("signature is too old")
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, timestamp, payload))) {
+		return fmt.Errorf("signature doesn't match")
+	}
+
+	return nil
+}
+
+func signature(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret creates the key a webhook's deliveries are signed with
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// ValidURL checks a webhook can be delivered to the URL.
+// Payloads describe someone's writing habits so they are only sent over https,
+// and never to hosts inside the network the server runs in.
+func ValidURL(raw string) error {
+	return PublicURL(raw, "Webhook URL")
+}
+
+// PublicURL checks a URL uses https and only resolves to public addresses.
+// name starts the errors so they make sense to whoever sent the URL.
+func PublicURL(raw string, name string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%s must be an absolute URL", name)
+	}
+
+	if u.Scheme != "https" {
+		return fmt.Errorf("%s must use https", name)
+	}
+
+	ips, err := lookupIP(u.Hostname())
+	if err != nil || len(ips) == 0 {
+		return fmt.Errorf("%s's host couldn't be found", name)
+	}
+
+	for _, ip := range ips {
+		if !Public(ip) {
+			return fmt.Errorf("%s must be a public address", name)
+		}
+	}
+
+	return nil
+}
+
+// lookupIP resolves hosts checked by PublicURL
+var lookupIP = net.LookupIP
+
+// Ranges that are never public: private, shared, loopback, link-local
+// (including cloud metadata servers), documentation and benchmarking networks
+var nonPublic = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets
+}
+
+// Public checks if an address is reachable on the public internet
+func Public(ip net.IP) bool {
+	if ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+
+	// IPv4 addresses mapped into IPv6 are checked as IPv4
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	for _, n := range nonPublic {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// publicOnly stops requests being dialed to addresses that aren't public,
+// which catches hosts that resolved differently after PublicURL checked them
+func publicOnly(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !Public(ip) {
+		return fmt.Errorf("address %s is not public", host)
+	}
+
+	return nil
+}
+
+// DeliveryColumns are read by ScanDelivery
+const DeliveryColumns = "id, webhook_id, event, payload, attempts, status_code, error, delivered_at, next_attempt_at, created_at"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func ScanDelivery(row scanner, delivery *models.WebhookDelivery) error {
+	return row.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Attempts, &delivery.StatusCode, &delivery.Error, &delivery.DeliveredAt, &delivery.NextAttemptAt, &delivery.CreatedAt)
+}
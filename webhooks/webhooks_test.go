@@ -0,0 +1,197 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/writewithwrabit/server/models"
+)
+
+// futureTime matches a next attempt that has been scheduled
+type futureTime struct{}
+
+func (futureTime) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.After(time.Now())
+}
+
+func newDispatcher(t *testing.T) (*Dispatcher, sqlmock.Sqlmock, *sql.DB) {
+	db, mock, err := sqlmock.New()
+	require.Nil(t, err)
+
+	// Receivers in tests listen on loopback
+	dispatcher := New(db)
+	dispatcher.httpClient = newHTTPClient(nil)
+
+	return dispatcher, mock, db
+}
+
+func TestPublish(t *testing.T) {
+	var received Payload
+	var signature, event string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		signature, event = r.Header.Get("X-Wrabit-Signature"), r.Header.Get("X-Wrabit-Event")
+
+		if err := Verify("whsec_test", signature, body, time.Now()); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.Unmarshal(body, &received)
+	}))
+	defer receiver.Close()
+
+	dispatcher, mock, db := newDispatcher(t)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, url, secret FROM webhooks WHERE user_id = \\$1 AND \\$2 = ANY\\(events\\)").
+		WithArgs("abcdefg", models.WebhookEventEntryGoalHit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "secret"}).AddRow("1", receiver.URL, "whsec_test"))
+	mock.ExpectQuery("INSERT INTO webhook_deliveries").
+		WithArgs("1", "abcdefg", "entry.goal_hit", sqlmock.AnyArg(), futureTime{}).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("7"))
+	mock.ExpectExec("UPDATE webhook_deliveries SET attempts = \\$1, status_code = \\$2, error = NULL, delivered_at = NOW\\(\\)").
+		WithArgs(1, 200, "7").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := dispatcher.Publish(context.Background(), "abcdefg", models.WebhookEventEntryGoalHit, GoalHit{EntryID: "5", WordCount: 800, Date: "2020-03-01"})
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, "entry.goal_hit", event)
+	assert.Equal(t, "entry.goal_hit", received.Event)
+	assert.Equal(t, "5", received.Data.(map[string]interface{})["entryID"])
+}
+
+func TestFailedDeliveriesAreRetried(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer receiver.Close()
+
+	dispatcher, mock, db := newDispatcher(t)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("UPDATE webhook_deliveries d SET next_attempt_at = \\$3 FROM webhooks w (.+) FOR UPDATE SKIP LOCKED\\) RETURNING d.id, w.url, w.secret, d.event, d.payload, d.attempts").
+		WithArgs(now, retryBatch, now.Add(claimLease)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "secret", "event", "payload", "attempts"}).
+			AddRow("7", receiver.URL, "whsec_test", "streak.extended", "{}", 2).
+			AddRow("8", receiver.URL, "whsec_test", "streak.extended", "{}", MaxAttempts-1))
+	mock.ExpectExec("UPDATE webhook_deliveries SET attempts = \\$1, status_code = \\$2, error = \\$3, next_attempt_at = \\$4").
+		WithArgs(3, 502, "receiver responded with 502 Bad Gateway", futureTime{}, "7").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE webhook_deliveries SET attempts = \\$1, status_code = \\$2, error = \\$3, next_attempt_at = \\$4").
+		WithArgs(MaxAttempts, 502, sqlmock.AnyArg(), nil, "8").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.Nil(t, dispatcher.Retry(context.Background(), now))
+	assert.Nil(t, mock.ExpectationsWereMet(), "the last attempt is not scheduled again")
+}
+
+func TestTest(t *testing.T) {
+	dispatcher, mock, db := newDispatcher(t)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT url, secret FROM webhooks WHERE id = \\$1 AND user_id = \\$2").
+		WithArgs("1", "someone").
+		WillReturnError(sql.ErrNoRows)
+
+	_, err := dispatcher.Test(context.Background(), "someone", "1")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Minute, Backoff(1))
+	assert.Equal(t, 2*time.Minute, Backoff(2))
+	assert.Equal(t, 64*time.Minute, Backoff(7))
+	assert.Equal(t, 6*time.Hour, Backoff(20))
+}
+
+func TestVerify(t *testing.T) {
+	payload := []byte(`{"event":"webhook.test"}`)
+	now := time.Now()
+
+	assert.Nil(t, Verify("secret", Sign("secret", now, payload), payload, now))
+	assert.NotNil(t, Verify("other", Sign("secret", now, payload), payload, now), "other secrets are rejected")
+	assert.NotNil(t, Verify("secret", Sign("secret", now, payload), []byte(`{}`), now), "changed payloads are rejected")
+	assert.NotNil(t, Verify("secret", Sign("secret", now.Add(-time.Hour), payload), payload, now), "old signatures are rejected")
+	assert.NotNil(t, Verify("secret", "v1=abc", payload, now))
+}
+
+func TestValidURL(t *testing.T) {
+	lookupIP = func(host string) ([]net.IP, error) {
+		hosts := map[string][]net.IP{
+			"example.com":      {net.ParseIP("93.184.216.34")},
+			"localhost":        {net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+			"intranet.example": {net.ParseIP("93.184.216.34"), net.ParseIP("10.0.0.5")},
+		}
+		if ips, ok := hosts[host]; ok {
+			return ips, nil
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			return []net.IP{ip}, nil
+		}
+
+		return nil, errors.New("no such host")
+	}
+	defer func() { lookupIP = net.LookupIP }()
+
+	assert.Nil(t, ValidURL("https://example.com/hooks/wrabit"))
+	assert.NotNil(t, ValidURL("http://example.com/hooks/wrabit"))
+	assert.NotNil(t, ValidURL("/hooks/wrabit"))
+	assert.NotNil(t, ValidURL("not a url"))
+	assert.NotNil(t, ValidURL("https://nowhere.example/hooks"))
+
+	for _, raw := range []string{
+		"https://localhost/hooks",
+		"https://127.0.0.1:8080/hooks",
+		"https://[::1]/hooks",
+		"https://10.1.2.3/hooks",
+		"https://172.20.0.1/hooks",
+		"https://192.168.1.1/hooks",
+		"https://169.254.169.254/computeMetadata/v1/",
+		"https://[fe80::1]/hooks",
+		"https://[fd00::1]/hooks",
+		"https://[::ffff:10.0.0.1]/hooks",
+		"https://0.0.0.0/hooks",
+		"https://intranet.example/hooks",
+	} {
+		assert.EqualError(t, ValidURL(raw), "Webhook URL must be a public address", raw)
+	}
+}
+
+func TestDeliveriesAreOnlyDialedToPublicAddresses(t *testing.T) {
+	var received bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer receiver.Close()
+
+	db, mock, err := sqlmock.New()
+	require.Nil(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE webhook_deliveries SET attempts = \\$1, status_code = \\$2, error = \\$3, next_attempt_at = \\$4").
+		WithArgs(1, nil, sqlmock.AnyArg(), futureTime{}, "7").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	New(db).attempt(context.Background(), &delivery{id: "7", url: receiver.URL, secret: "whsec_test", event: "streak.extended", payload: "{}"}, true)
+
+	assert.False(t, received)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}